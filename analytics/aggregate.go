@@ -0,0 +1,109 @@
+package analytics
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Period is the span of the bins of BinAverage and BinLast
+type Period int
+
+const (
+	Week Period = iota
+	Month
+	Year
+)
+
+// Start returns the first day of the period day is in, weeks start on Monday
+func (p Period) Start(day time.Time) time.Time {
+	day = Day(day)
+	switch p {
+	case Week:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case Month:
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(day.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// BinAverage returns the daily average of points, sorted by day, over each period, dated on the first
+// day of the period. Periods only partly covered by points are averaged over the days they cover.
+func BinAverage(points []Point, p Period) []Point {
+	return bin(points, p, func(values []float64) float64 {
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	})
+}
+
+// BinLast returns the last of points, sorted by day, in each period, dated on the first day of the period,
+// e.g. the running total at the end of each period
+func BinLast(points []Point, p Period) []Point {
+	return bin(points, p, func(values []float64) float64 {
+		return values[len(values)-1]
+	})
+}
+
+func bin(points []Point, p Period, reduce func(values []float64) float64) []Point {
+	res := []Point{}
+	var values []float64
+	var start time.Time
+	for i, point := range points {
+		if s := p.Start(point.Day); i == 0 || !s.Equal(start) {
+			if len(values) > 0 {
+				res = append(res, Point{Day: start, Value: reduce(values)})
+			}
+			start, values = s, values[:0]
+		}
+		values = append(values, point.Value)
+	}
+	if len(values) > 0 {
+		res = append(res, Point{Day: start, Value: reduce(values)})
+	}
+	return res
+}
+
+// Percentile returns the p (0 to 1) percentile of values, interpolating linearly between the closest ranks.
+// It is 0 when there are no values.
+func Percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	index := float64(len(sorted)-1) * p
+	lower := int(math.Floor(index))
+	upper := int(math.Ceil(index))
+	return sorted[lower] + (index-float64(lower))*(sorted[upper]-sorted[lower])
+}
+
+// NormalizePercentile is the percentile of the non-zero values Normalize clips at
+const NormalizePercentile = 0.98
+
+// Normalize returns points with the values above the 98th percentile of the non-zero values replaced by it,
+// so that a few spikes, like a launch day, don't flatten the rest of the series. It returns that percentile too.
+func Normalize(points []Point) ([]Point, float64) {
+	var nonZero []float64
+	for _, p := range points {
+		if p.Value > 0 {
+			nonZero = append(nonZero, p.Value)
+		}
+	}
+	limit := Percentile(nonZero, NormalizePercentile)
+
+	res := make([]Point, len(points))
+	for i, p := range points {
+		res[i] = p
+		if len(nonZero) > 0 && p.Value > limit {
+			res[i].Value = limit
+		}
+	}
+	return res, limit
+}
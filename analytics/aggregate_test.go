@@ -0,0 +1,50 @@
+package analytics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeriodStart(t *testing.T) {
+	// A Sunday
+	assert.Equal(t, day("2024-03-04"), Week.Start(day("2024-03-10")))
+	assert.Equal(t, day("2024-03-04"), Week.Start(day("2024-03-04")))
+	assert.Equal(t, day("2024-03-01"), Month.Start(day("2024-03-10")))
+	assert.Equal(t, day("2024-01-01"), Year.Start(day("2024-03-10")))
+}
+
+func TestBinning(t *testing.T) {
+	var points []Point
+	for i := range 10 {
+		points = append(points, Point{day("2024-01-29").AddDate(0, 0, i), float64(i)})
+	}
+
+	// Mon 29 Jan to Wed 7 Feb
+	assert.Equal(t, []Point{{day("2024-01-29"), 3}, {day("2024-02-05"), 8}}, BinAverage(points, Week))
+	assert.Equal(t, []Point{{day("2024-01-01"), 1}, {day("2024-02-01"), 6}}, BinAverage(points, Month))
+	assert.Equal(t, []Point{{day("2024-01-01"), 4.5}}, BinAverage(points, Year))
+	assert.Equal(t, []Point{{day("2024-01-01"), 2}, {day("2024-02-01"), 9}}, BinLast(points, Month))
+	assert.Empty(t, BinAverage(nil, Week))
+}
+
+func TestPercentile(t *testing.T) {
+	assert.Equal(t, 3.0, Percentile([]float64{5, 1, 3}, 0.5))
+	assert.InDelta(t, 4.92, Percentile([]float64{1, 2, 3, 4, 5}, 0.98), 1e-9)
+	assert.Zero(t, Percentile(nil, 0.98))
+}
+
+func TestNormalize(t *testing.T) {
+	var points []Point
+	for i := range 100 {
+		points = append(points, Point{day("2024-01-01").AddDate(0, 0, i), float64(i % 10)})
+	}
+	points[0].Value = 5000
+
+	normalized, limit := Normalize(points)
+	assert.InDelta(t, 9, limit, 1e-9)
+	assert.Equal(t, 9.0, normalized[0].Value)
+	assert.Equal(t, points[1:], normalized[1:])
+	// The input is left as it is
+	assert.Equal(t, 5000.0, points[0].Value)
+}
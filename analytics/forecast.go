@@ -0,0 +1,217 @@
+package analytics
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// Forecasting methods
+const (
+	// MethodHoltWinters is additive Holt-Winters exponential smoothing with a damped trend and weekly seasonality
+	MethodHoltWinters = "holt-winters"
+	// MethodDampedTrend is Holt exponential smoothing with a damped trend, for series too short or too
+	// irregular for a weekly seasonality
+	MethodDampedTrend = "damped-trend"
+)
+
+const (
+	// weekDays is the length of the weekly season
+	weekDays = 7
+	// confidenceZ is the normal quantile of the 95% confidence bands
+	confidenceZ = 1.96
+)
+
+// ErrShortSeries is returned when a series is too short to forecast
+var ErrShortSeries = errors.New("series too short to forecast")
+
+// The smoothing parameters tried when fitting a model
+var (
+	alphas = []float64{0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9}
+	betas  = []float64{0.01, 0.05, 0.1, 0.2, 0.3}
+	gammas = []float64{0.01, 0.05, 0.1, 0.2, 0.3, 0.5}
+	phis   = []float64{0.8, 0.9, 0.95, 0.98}
+)
+
+// SmoothingParams are the parameters of an exponential smoothing model: Alpha smooths the level, Beta the
+// trend, Gamma the seasonality, and Phi damps the trend over the forecast horizon
+type SmoothingParams struct {
+	Alpha float64 `json:"alpha"`
+	Beta  float64 `json:"beta"`
+	Gamma float64 `json:"gamma"`
+	Phi   float64 `json:"phi"`
+}
+
+// Forecast is the prediction of a daily series
+type Forecast struct {
+	Method string
+	Params SmoothingParams
+	// Sigma is the standard deviation of the one-step-ahead errors of the model over the series
+	Sigma  float64
+	Points []ForecastPoint
+}
+
+// ForecastPoint is the prediction of one day, with its 95% confidence band. The predictions and the
+// bands are never negative.
+type ForecastPoint struct {
+	Day   time.Time
+	Value float64
+	Lower float64
+	Upper float64
+}
+
+// smoother is an additive exponential smoothing model with a damped trend, seasonal when season > 0
+type smoother struct {
+	SmoothingParams
+	season int
+
+	level, trend float64
+	seasonal     []float64
+	// t is the number of observations the model went through
+	t int
+}
+
+// fit runs the model through y after initializing it from its start, returning the sum of the squared
+// one-step-ahead errors
+func (s *smoother) fit(y []float64) float64 {
+	if s.season > 0 {
+		m := s.season
+		first, second := mean(y[:m]), mean(y[m:2*m])
+		s.level, s.trend = first, (second-first)/float64(m)
+		s.seasonal = make([]float64, m)
+		for i := range m {
+			s.seasonal[i] = y[i] - first
+		}
+	} else {
+		s.level, s.trend = y[0], y[1]-y[0]
+	}
+
+	sse := 0.0
+	for _, v := range y {
+		e := v - s.predict(1)
+		sse += e * e
+		s.update(v)
+	}
+	return sse
+}
+
+// predict returns the prediction h days after the last observation
+func (s *smoother) predict(h int) float64 {
+	prediction := s.level + dampedSum(s.Phi, h)*s.trend
+	if s.season > 0 {
+		prediction += s.seasonal[(s.t+h-1)%s.season]
+	}
+	return prediction
+}
+
+func (s *smoother) update(v float64) {
+	seasonal := 0.0
+	if s.season > 0 {
+		seasonal = s.seasonal[s.t%s.season]
+	}
+
+	level := s.Alpha*(v-seasonal) + (1-s.Alpha)*(s.level+s.Phi*s.trend)
+	s.trend = s.Beta*(level-s.level) + (1-s.Beta)*s.Phi*s.trend
+	s.level = level
+	if s.season > 0 {
+		s.seasonal[s.t%s.season] = s.Gamma*(v-level) + (1-s.Gamma)*seasonal
+	}
+	s.t++
+}
+
+// variance returns the variance of the prediction h days ahead relative to the one-step-ahead variance,
+// as given for the ETS(A,Ad,A) models
+func (s *smoother) variance(h int) float64 {
+	v := 1.0
+	for j := 1; j < h; j++ {
+		c := s.Alpha * (1 + dampedSum(s.Phi, j)*s.Beta)
+		if s.season > 0 && j%s.season == 0 {
+			c += s.Gamma
+		}
+		v += c * c
+	}
+	return v
+}
+
+// dampedSum is phi + phi² + ... + phi^h
+func dampedSum(phi float64, h int) float64 {
+	sum, p := 0.0, 1.0
+	for range h {
+		p *= phi
+		sum += p
+	}
+	return sum
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// best returns the model of season with the parameters fitting y best
+func best(y []float64, season int) (*smoother, float64) {
+	gs := gammas
+	if season == 0 {
+		gs = []float64{0}
+	}
+
+	var model *smoother
+	bestSSE := math.Inf(1)
+	for _, alpha := range alphas {
+		for _, beta := range betas {
+			for _, gamma := range gs {
+				for _, phi := range phis {
+					s := &smoother{SmoothingParams: SmoothingParams{Alpha: alpha, Beta: beta, Gamma: gamma, Phi: phi}, season: season}
+					if sse := s.fit(y); sse < bestSSE {
+						model, bestSSE = s, sse
+					}
+				}
+			}
+		}
+	}
+	return model, bestSSE
+}
+
+// ForecastDaily predicts the days after points, one per day with missing days counted as 0. It fits a
+// Holt-Winters model with weekly seasonality when points span four weeks or more, and falls back to a
+// damped trend model when the series is shorter or the damped trend fits it better.
+func ForecastDaily(points []Point, days int) (Forecast, error) {
+	axis, aligned := AlignByDate([][]Point{points})
+	if len(axis) < 3 {
+		return Forecast{}, ErrShortSeries
+	}
+
+	y := make([]float64, len(axis))
+	for i, v := range aligned[0] {
+		y[i] = *v
+	}
+
+	model, sse := best(y, 0)
+	method := MethodDampedTrend
+	if len(y) >= 4*weekDays {
+		if seasonal, seasonalSSE := best(y, weekDays); seasonalSSE <= sse {
+			model, sse, method = seasonal, seasonalSSE, MethodHoltWinters
+		}
+	}
+
+	forecast := Forecast{
+		Method: method,
+		Params: model.SmoothingParams,
+		Sigma:  math.Sqrt(sse / float64(len(y))),
+	}
+	last := axis[len(axis)-1]
+	for h := 1; h <= days; h++ {
+		value := model.predict(h)
+		band := confidenceZ * forecast.Sigma * math.Sqrt(model.variance(h))
+		forecast.Points = append(forecast.Points, ForecastPoint{
+			Day:   last.AddDate(0, 0, h),
+			Value: math.Max(value, 0),
+			Lower: math.Max(value-band, 0),
+			Upper: math.Max(value+band, 0),
+		})
+	}
+	return forecast, nil
+}
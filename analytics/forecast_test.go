@@ -0,0 +1,66 @@
+package analytics
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// weekly is a series growing by 0.2 a day with quiet weekends, starting on a Monday
+func weekly(t int) float64 {
+	v := 20 + 0.2*float64(t)
+	if t%7 >= 5 {
+		v -= 12
+	}
+	return v
+}
+
+func TestForecastHoltWinters(t *testing.T) {
+	var values []float64
+	for i := range 140 {
+		values = append(values, weekly(i)+math.Sin(float64(i)))
+	}
+	points := series(values...)
+
+	forecast, err := ForecastDaily(points, 28)
+	require.NoError(t, err)
+	assert.Equal(t, MethodHoltWinters, forecast.Method)
+	require.Len(t, forecast.Points, 28)
+	assert.Equal(t, points[len(points)-1].Day.AddDate(0, 0, 1), forecast.Points[0].Day)
+
+	for h, p := range forecast.Points {
+		truth := weekly(140 + h)
+		assert.InDelta(t, truth, p.Value, 4, "day %d", h)
+		assert.LessOrEqual(t, p.Lower, truth, "day %d", h)
+		assert.GreaterOrEqual(t, p.Upper, truth, "day %d", h)
+	}
+	// The band widens with the horizon
+	first, last := forecast.Points[0], forecast.Points[27]
+	assert.Greater(t, last.Upper-last.Lower, first.Upper-first.Lower)
+}
+
+func TestForecastDampedTrend(t *testing.T) {
+	forecast, err := ForecastDaily(series(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 5)
+	require.NoError(t, err)
+	assert.Equal(t, MethodDampedTrend, forecast.Method)
+	require.Len(t, forecast.Points, 5)
+
+	// Still growing, but less and less
+	for i := 1; i < 5; i++ {
+		assert.Greater(t, forecast.Points[i].Value, forecast.Points[i-1].Value)
+	}
+	assert.Less(t, forecast.Points[4].Value, 15.0)
+
+	// Never negative
+	forecast, err = ForecastDaily(series(9, 6, 3), 10)
+	require.NoError(t, err)
+	for _, p := range forecast.Points {
+		assert.GreaterOrEqual(t, p.Lower, 0.0)
+		assert.GreaterOrEqual(t, p.Value, 0.0)
+	}
+
+	_, err = ForecastDaily(series(1, 2), 5)
+	assert.ErrorIs(t, err, ErrShortSeries)
+}
@@ -0,0 +1,103 @@
+package analytics
+
+import (
+	"math"
+	"time"
+)
+
+// firstMilestone is the smallest milestone, the next ones following the 1-2-5 series: 10, 20, 50, 100, 200...
+const firstMilestone = 10
+
+// NextMilestone returns the smallest milestone above total
+func NextMilestone(total float64) float64 {
+	threshold := float64(firstMilestone)
+	for threshold <= total {
+		threshold = milestoneAfter(threshold)
+	}
+	return threshold
+}
+
+func milestoneAfter(threshold float64) float64 {
+	magnitude := math.Pow(10, math.Floor(math.Log10(threshold)))
+	switch math.Round(threshold / magnitude) {
+	case 1:
+		return 2 * magnitude
+	case 2:
+		return 5 * magnitude
+	default:
+		return 10 * magnitude
+	}
+}
+
+// Milestone is a round number reached by a running total
+type Milestone struct {
+	Threshold float64
+	Day       time.Time
+	// Days is how many days after the previous milestone, or the first day of the series, it was reached
+	Days int
+}
+
+// Milestones returns the milestones reached by totals, a running total from its first day, in order
+func Milestones(totals []Point) []Milestone {
+	milestones := []Milestone{}
+	if len(totals) == 0 {
+		return milestones
+	}
+
+	previous := Day(totals[0].Day)
+	threshold := float64(firstMilestone)
+	for _, p := range totals {
+		for p.Value >= threshold {
+			day := Day(p.Day)
+			milestones = append(milestones, Milestone{Threshold: threshold, Day: day, Days: days(previous, day)})
+			previous = day
+			threshold = milestoneAfter(threshold)
+		}
+	}
+	return milestones
+}
+
+// Velocity returns the average daily growth of totals, a running total from its first day, over the window
+// days ending on its last day, or over the whole series when it is shorter
+func Velocity(totals []Point, window int) float64 {
+	if len(totals) == 0 {
+		return 0
+	}
+
+	last := totals[len(totals)-1]
+	since := Day(last.Day).AddDate(0, 0, -window)
+	// The total before the first day is 0
+	before, span := 0.0, days(Day(totals[0].Day), Day(last.Day))+1
+	for _, p := range totals {
+		if Day(p.Day).After(since) {
+			break
+		}
+		before, span = p.Value, window
+	}
+	return (last.Value - before) / float64(span)
+}
+
+// Projection is the day a milestone is expected to be reached
+type Projection struct {
+	Threshold float64
+	Day       time.Time
+	// Days is how many days after the last day of the series it is expected
+	Days int
+}
+
+// ProjectMilestones returns when the next count milestones above total are expected, growing by velocity a
+// day from last, none when velocity isn't positive
+func ProjectMilestones(total float64, last time.Time, velocity float64, count int) []Projection {
+	projections := []Projection{}
+	if velocity <= 0 {
+		return projections
+	}
+
+	threshold := NextMilestone(total)
+	for range count {
+		n := int(math.Ceil((threshold - total) / velocity))
+		projections = append(projections, Projection{Threshold: threshold, Day: Day(last).AddDate(0, 0, n), Days: n})
+		threshold = milestoneAfter(threshold)
+	}
+	return projections
+}
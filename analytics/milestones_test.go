@@ -0,0 +1,76 @@
+package analytics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextMilestone(t *testing.T) {
+	for total, want := range map[float64]float64{
+		0:      10,
+		9:      10,
+		10:     20,
+		19:     20,
+		20:     50,
+		99:     100,
+		1000:   2000,
+		4999:   5000,
+		5000:   10000,
+		123456: 200000,
+	} {
+		assert.Equal(t, want, NextMilestone(total), "total %v", total)
+	}
+}
+
+func TestMilestones(t *testing.T) {
+	totals := []Point{
+		{day("2024-01-01"), 3},
+		{day("2024-01-05"), 12},
+		{day("2024-01-06"), 55},
+		{day("2024-01-20"), 99},
+		{day("2024-02-01"), 100},
+	}
+
+	assert.Equal(t, []Milestone{
+		{Threshold: 10, Day: day("2024-01-05"), Days: 4},
+		{Threshold: 20, Day: day("2024-01-06"), Days: 1},
+		{Threshold: 50, Day: day("2024-01-06"), Days: 0},
+		{Threshold: 100, Day: day("2024-02-01"), Days: 26},
+	}, Milestones(totals))
+
+	assert.Empty(t, Milestones(nil))
+	assert.Empty(t, Milestones(series(1, 2, 3)))
+}
+
+func TestVelocity(t *testing.T) {
+	// 2 a day for 60 days, 5 a day for the last 10
+	var totals []Point
+	total := 0.0
+	for i := range 70 {
+		if i < 60 {
+			total += 2
+		} else {
+			total += 5
+		}
+		totals = append(totals, Point{day("2024-01-01").AddDate(0, 0, i), total})
+	}
+	assert.InDelta(t, 5, Velocity(totals, 10), 1e-9)
+	assert.InDelta(t, (10*5+20*2)/30.0, Velocity(totals, 30), 1e-9)
+
+	// Missing days keep the total of the day before
+	assert.InDelta(t, 2, Velocity(append(totals[:30:30], totals[39]), 10), 1e-9)
+
+	// Shorter than the window: from 0 before the first day
+	assert.InDelta(t, 2, Velocity(totals[:5], 30), 1e-9)
+	assert.Zero(t, Velocity(nil, 30))
+}
+
+func TestProjectMilestones(t *testing.T) {
+	assert.Equal(t, []Projection{
+		{Threshold: 1000, Day: day("2024-03-10"), Days: 9},
+		{Threshold: 2000, Day: day("2024-09-26"), Days: 209},
+	}, ProjectMilestones(955, day("2024-03-01"), 5, 2))
+
+	assert.Empty(t, ProjectMilestones(955, day("2024-03-01"), 0, 2))
+}
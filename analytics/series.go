@@ -0,0 +1,185 @@
+package analytics
+
+import (
+	"sort"
+	"time"
+)
+
+// Point is the value of a daily series on one day
+type Point struct {
+	Day   time.Time
+	Value float64
+}
+
+// Day is the UTC day of t
+func Day(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// RunningTotal returns the running total of aligned values, without value where values have none
+func RunningTotal(values []*float64) []*float64 {
+	total := 0.0
+	res := make([]*float64, len(values))
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		total += *v
+		sum := total
+		res[i] = &sum
+	}
+	return res
+}
+
+// AlignByDate puts series on a common axis of consecutive days, from the first day of any series to
+// the last. A series has no value (nil) on the days before its first point and after its last one,
+// and 0 on the days it has no point in between.
+func AlignByDate(series [][]Point) (axis []time.Time, values [][]*float64) {
+	var from, to time.Time
+	for _, points := range series {
+		if len(points) == 0 {
+			continue
+		}
+		first, last := bounds(points)
+		if from.IsZero() || first.Before(from) {
+			from = first
+		}
+		if last.After(to) {
+			to = last
+		}
+	}
+
+	if !from.IsZero() {
+		for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+			axis = append(axis, day)
+		}
+	}
+
+	values = make([][]*float64, len(series))
+	for i, points := range series {
+		var start time.Time
+		if len(points) > 0 {
+			start, _ = bounds(points)
+		}
+		values[i] = window(points, start, from, len(axis))
+	}
+	return axis, values
+}
+
+// AlignByOffset puts series on a common axis of days since their start, e.g. the creation of their repo:
+// day 0 is starts[i] for series i, or its first point when starts[i] is zero. A series is 0 on the days
+// it has no point up to its last one, and has no value (nil) after it.
+func AlignByOffset(series [][]Point, starts []time.Time) (axis []int, values [][]*float64) {
+	length := 0
+	begins := make([]time.Time, len(series))
+	for i, points := range series {
+		if len(points) == 0 {
+			continue
+		}
+		first, last := bounds(points)
+		begins[i] = first
+		if !starts[i].IsZero() && Day(starts[i]).Before(first) {
+			begins[i] = Day(starts[i])
+		}
+		length = max(length, days(begins[i], last)+1)
+	}
+
+	axis = make([]int, length)
+	for i := range axis {
+		axis[i] = i
+	}
+
+	values = make([][]*float64, len(series))
+	for i, points := range series {
+		values[i] = window(points, begins[i], begins[i], length)
+	}
+	return axis, values
+}
+
+// window returns the values of points on the length days from from, nil outside of start to the last point
+func window(points []Point, start, from time.Time, length int) []*float64 {
+	res := make([]*float64, length)
+	if len(points) == 0 {
+		return res
+	}
+
+	byDay := make(map[time.Time]float64, len(points))
+	for _, p := range points {
+		byDay[Day(p.Day)] += p.Value
+	}
+	_, last := bounds(points)
+
+	for i := range res {
+		day := from.AddDate(0, 0, i)
+		if day.Before(start) || day.After(last) {
+			continue
+		}
+		value := byDay[day]
+		res[i] = &value
+	}
+	return res
+}
+
+// bounds returns the first and last day of points, which must not be empty
+func bounds(points []Point) (first, last time.Time) {
+	first, last = Day(points[0].Day), Day(points[0].Day)
+	for _, p := range points[1:] {
+		day := Day(p.Day)
+		if day.Before(first) {
+			first = day
+		}
+		if day.After(last) {
+			last = day
+		}
+	}
+	return first, last
+}
+
+// days is the number of days from from to to
+func days(from, to time.Time) int {
+	return int(to.Sub(from).Hours() / 24)
+}
+
+// Summary describes a daily series
+type Summary struct {
+	// Total is the sum of the series
+	Total float64
+	First time.Time
+	Last  time.Time
+	// Days is the number of days from First to Last, both included
+	Days int
+	// Mean is the average value per day from First to Last
+	Mean float64
+	// Peak is the day with the highest value, the earliest on a tie
+	Peak Point
+	// Last30Days is the sum of the 30 days ending on Last
+	Last30Days float64
+}
+
+// Summarize describes points, the zero Summary when there are none
+func Summarize(points []Point) Summary {
+	if len(points) == 0 {
+		return Summary{}
+	}
+
+	sorted := make([]Point, len(points))
+	copy(sorted, points)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Day.Before(sorted[j].Day) })
+
+	var s Summary
+	s.First, s.Last = bounds(sorted)
+	s.Days = days(s.First, s.Last) + 1
+	s.Peak = Point{Day: Day(sorted[0].Day), Value: sorted[0].Value}
+	recent := s.Last.AddDate(0, 0, -29)
+	for _, p := range sorted {
+		s.Total += p.Value
+		if p.Value > s.Peak.Value {
+			s.Peak = Point{Day: Day(p.Day), Value: p.Value}
+		}
+		if !Day(p.Day).Before(recent) {
+			s.Last30Days += p.Value
+		}
+	}
+	s.Mean = s.Total / float64(s.Days)
+	return s
+}
@@ -0,0 +1,78 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func day(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func values(vs []*float64) []any {
+	res := make([]any, len(vs))
+	for i, v := range vs {
+		if v != nil {
+			res[i] = *v
+		}
+	}
+	return res
+}
+
+func TestAlignByDate(t *testing.T) {
+	a := []Point{{day("2024-01-01"), 1}, {day("2024-01-02"), 2}, {day("2024-01-04"), 4}}
+	b := []Point{{day("2024-01-03"), 3}, {day("2024-01-05"), 5}}
+
+	axis, aligned := AlignByDate([][]Point{a, b, nil})
+	require.Len(t, axis, 5)
+	assert.Equal(t, day("2024-01-01"), axis[0])
+	assert.Equal(t, day("2024-01-05"), axis[4])
+
+	// No value outside of each series, 0 on the days missing in between
+	assert.Equal(t, []any{1.0, 2.0, 0.0, 4.0, nil}, values(aligned[0]))
+	assert.Equal(t, []any{nil, nil, 3.0, 0.0, 5.0}, values(aligned[1]))
+	assert.Equal(t, []any{nil, nil, nil, nil, nil}, values(aligned[2]))
+}
+
+func TestAlignByOffset(t *testing.T) {
+	a := []Point{{day("2024-01-03"), 1}, {day("2024-01-04"), 2}}
+	b := []Point{{day("2020-06-01"), 3}, {day("2020-06-02"), 4}, {day("2020-06-03"), 5}}
+
+	// a was created two days before its first point, b has no creation day
+	axis, aligned := AlignByOffset([][]Point{a, b}, []time.Time{day("2024-01-01").Add(15 * time.Hour), {}})
+	assert.Equal(t, []int{0, 1, 2, 3}, axis)
+	assert.Equal(t, []any{0.0, 0.0, 1.0, 2.0}, values(aligned[0]))
+	assert.Equal(t, []any{3.0, 4.0, 5.0, nil}, values(aligned[1]))
+}
+
+func TestRunningTotal(t *testing.T) {
+	one, two := 1.0, 2.0
+	assert.Equal(t, []any{nil, 1.0, 1.0, 3.0, nil}, values(RunningTotal([]*float64{nil, &one, new(float64), &two, nil})))
+}
+
+func TestSummarize(t *testing.T) {
+	var points []Point
+	for i := range 40 {
+		points = append(points, Point{day("2024-01-01").AddDate(0, 0, i), 1})
+	}
+	points[10].Value = 9
+	points[20].Value = 9
+
+	s := Summarize(points)
+	assert.Equal(t, 56.0, s.Total)
+	assert.Equal(t, day("2024-01-01"), s.First)
+	assert.Equal(t, day("2024-02-09"), s.Last)
+	assert.Equal(t, 40, s.Days)
+	assert.InDelta(t, 56.0/40, s.Mean, 1e-9)
+	assert.Equal(t, Point{day("2024-01-11"), 9}, s.Peak)
+	assert.Equal(t, 46.0, s.Last30Days)
+
+	assert.Equal(t, Summary{}, Summarize(nil))
+}
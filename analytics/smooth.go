@@ -0,0 +1,130 @@
+package analytics
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// loessIterations is how many times LOESS refits the series, down-weighting the outliers of the previous fit
+	loessIterations = 2
+	loessEpsilon    = 1e-12
+)
+
+// LOESS returns the locally weighted linear regression of points, sorted by day, as regressionLoess of
+// d3-regression used by the UI: every point is fitted on the bandwidth (0 to 1) share of the points nearest
+// to it, weighted by a tricube kernel, and the fit is repeated with robustness weights reducing the pull
+// of outliers. The points are regressed on their index, like the UI does.
+func LOESS(points []Point, bandwidth float64) []Point {
+	n := len(points)
+	res := make([]Point, n)
+	copy(res, points)
+	if n < 3 {
+		return res
+	}
+
+	window := min(max(2, int(bandwidth*float64(n))), n)
+	fitted := make([]float64, n)
+	residuals := make([]float64, n)
+	robustness := make([]float64, n)
+	for i := range robustness {
+		robustness[i] = 1
+	}
+
+	for iter := 0; iter <= loessIterations; iter++ {
+		left, right := 0, window-1
+		for i := range n {
+			x := float64(i)
+			edge := right
+			if x-float64(left) > float64(right)-x {
+				edge = left
+			}
+			scale := math.Abs(float64(edge) - x)
+			if scale == 0 {
+				scale = 1
+			}
+
+			var w, sx, sy, sxy, sxx float64
+			for k := left; k <= right; k++ {
+				xk, yk := float64(k), points[k].Value
+				wk := tricube(math.Abs(x-xk)/scale) * robustness[k]
+				w += wk
+				sx += xk * wk
+				sy += yk * wk
+				sxy += yk * xk * wk
+				sxx += xk * xk * wk
+			}
+
+			intercept, slope := leastSquares(sx/w, sy/w, sxy/w, sxx/w)
+			fitted[i] = intercept + slope*x
+			residuals[i] = math.Abs(points[i].Value - fitted[i])
+
+			// Slide the window right while the next point is closer to its new right edge than to its left one
+			for next := i + 1; right+1 < n && next > left && right+1-next <= next-left; {
+				left++
+				right++
+			}
+		}
+
+		if iter == loessIterations {
+			break
+		}
+		medianResidual := median(residuals)
+		if math.Abs(medianResidual) < loessEpsilon {
+			break
+		}
+		for i, r := range residuals {
+			arg := r / (6 * medianResidual)
+			robustness[i] = loessEpsilon
+			if w := 1 - arg*arg; arg < 1 && w > loessEpsilon {
+				robustness[i] = w * w
+			}
+		}
+	}
+
+	for i := range res {
+		res[i].Value = fitted[i]
+	}
+	return res
+}
+
+func tricube(x float64) float64 {
+	x = 1 - x*x*x
+	return x * x * x
+}
+
+// leastSquares returns the line fitting the weighted means of x, y, xy and x²
+func leastSquares(x, y, xy, xx float64) (intercept, slope float64) {
+	if delta := xx - x*x; math.Abs(delta) >= 1e-24 {
+		slope = (xy - x*y) / delta
+	}
+	return y - slope*x, slope
+}
+
+func median(values []float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// RunningAverage returns the average of points over a window of days centered on each day, shrunk at
+// both ends of the series
+func RunningAverage(points []Point, window int) []Point {
+	res := make([]Point, len(points))
+	half := window / 2
+	for i := range points {
+		from, to := max(0, i-half), min(len(points)-1, i+half)
+		sum := 0.0
+		for _, p := range points[from : to+1] {
+			sum += p.Value
+		}
+		res[i] = Point{Day: points[i].Day, Value: sum / float64(to-from+1)}
+	}
+	return res
+}
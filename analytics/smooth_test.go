@@ -0,0 +1,44 @@
+package analytics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func series(values ...float64) []Point {
+	points := make([]Point, len(values))
+	for i, v := range values {
+		points[i] = Point{day("2024-01-01").AddDate(0, 0, i), v}
+	}
+	return points
+}
+
+func TestLOESS(t *testing.T) {
+	var values []float64
+	for i := range 50 {
+		values = append(values, 2*float64(i)+1)
+	}
+
+	// A line is its own regression
+	for i, p := range LOESS(series(values...), 0.3) {
+		assert.InDelta(t, values[i], p.Value, 1e-6)
+		assert.Equal(t, day("2024-01-01").AddDate(0, 0, i), p.Day)
+	}
+
+	// The robustness iterations ignore an outlier in a noisy series
+	for i := range values {
+		values[i] += float64(i%2*2 - 1)
+	}
+	values[25] = 1000
+	smoothed := LOESS(series(values...), 0.3)
+	assert.InDelta(t, 51, smoothed[25].Value, 1)
+	assert.InDelta(t, 21, smoothed[10].Value, 1)
+
+	assert.Equal(t, series(1, 5), LOESS(series(1, 5), 0.3))
+}
+
+func TestRunningAverage(t *testing.T) {
+	averaged := RunningAverage(series(1, 2, 3, 4, 5), 4)
+	assert.Equal(t, series(2, 2.5, 3, 3.5, 4), averaged)
+}
@@ -0,0 +1,126 @@
+package analytics
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// madScale turns a median absolute deviation into an estimate of the standard deviation of normal data
+const madScale = 1.4826
+
+// SpikeOptions tune DetectSpikes
+type SpikeOptions struct {
+	// BaselineDays is how many days before a day its baseline is computed over
+	BaselineDays int
+	// MinBaselineDays is how many days a baseline needs, the first days of a series are never spikes
+	MinBaselineDays int
+	// Threshold is the robust z-score a day must reach: how many standard deviations, estimated from the
+	// median absolute deviation of the baseline, it is above the median of the baseline
+	Threshold float64
+	// MinExcess is how much a day must be above the median of its baseline, so that a few stars on a
+	// quiet repo are not spikes
+	MinExcess float64
+}
+
+// Spike is a run of consecutive days significantly above their baseline
+type Spike struct {
+	Start time.Time
+	End   time.Time
+	// Peak is the highest day of the spike
+	Peak Point
+	// Baseline is the median of the days before the peak
+	Baseline float64
+	// Score is the robust z-score of the peak
+	Score float64
+	// Excess is the sum of the values above their baseline over the spike
+	Excess float64
+}
+
+// DetectSpikes returns the spikes of points, one per day with missing days counted as 0, from the most
+// significant. A day is compared with the median and the median absolute deviation of the BaselineDays
+// before it, which spikes barely move, unlike a mean and a standard deviation.
+func DetectSpikes(points []Point, opts SpikeOptions) []Spike {
+	axis, aligned := AlignByDate([][]Point{points})
+	y := make([]float64, len(axis))
+	for i, v := range aligned[0] {
+		y[i] = *v
+	}
+
+	var spikes []Spike
+	var current *Spike
+	for i := opts.MinBaselineDays; i < len(y); i++ {
+		window := y[max(0, i-opts.BaselineDays):i]
+		baseline := median(window)
+		deviations := make([]float64, len(window))
+		for j, v := range window {
+			deviations[j] = math.Abs(v - baseline)
+		}
+		scale := math.Max(median(deviations)*madScale, 1)
+
+		excess := y[i] - baseline
+		score := excess / scale
+		if score < opts.Threshold || excess < opts.MinExcess {
+			current = nil
+			continue
+		}
+
+		if current == nil {
+			spikes = append(spikes, Spike{Start: axis[i]})
+			current = &spikes[len(spikes)-1]
+		}
+		current.End = axis[i]
+		current.Excess += excess
+		if y[i] > current.Peak.Value {
+			current.Peak = Point{Day: axis[i], Value: y[i]}
+			current.Baseline, current.Score = baseline, score
+		}
+	}
+
+	sort.SliceStable(spikes, func(i, j int) bool { return spikes[i].Score > spikes[j].Score })
+	return spikes
+}
+
+// Event is something that may have caused a spike, like a post or a release
+type Event struct {
+	Source string
+	Title  string
+	URL    string
+	Time   time.Time
+	// Engagement is the reach of the event, e.g. the points of a post, 0 when unknown
+	Engagement float64
+}
+
+// Cause is an event close to a spike, as a candidate cause
+type Cause struct {
+	Event
+	// LagDays is how many days before the peak of the spike the event happened, negative when after it
+	LagDays int
+	// Score ranks the causes of a spike: the closer to the peak and the higher the engagement, the higher
+	Score float64
+}
+
+// Causes returns the events from leadDays days before the start of spike to the day after its peak,
+// ranked from the most likely cause
+func Causes(spike Spike, events []Event, leadDays int) []Cause {
+	from := spike.Start.AddDate(0, 0, -leadDays)
+	to := spike.Peak.Day.AddDate(0, 0, 1)
+
+	causes := []Cause{}
+	for _, e := range events {
+		day := Day(e.Time)
+		if day.Before(from) || day.After(to) {
+			continue
+		}
+		lag := days(day, spike.Peak.Day)
+		proximity := 1 / (1 + math.Abs(float64(lag)))
+		causes = append(causes, Cause{
+			Event:   e,
+			LagDays: lag,
+			Score:   proximity * (1 + math.Log10(1+math.Max(e.Engagement, 0))),
+		})
+	}
+
+	sort.SliceStable(causes, func(i, j int) bool { return causes[i].Score > causes[j].Score })
+	return causes
+}
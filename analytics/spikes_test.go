@@ -0,0 +1,65 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var spikeOptions = SpikeOptions{BaselineDays: 28, MinBaselineDays: 7, Threshold: 3.5, MinExcess: 10}
+
+func TestDetectSpikes(t *testing.T) {
+	var values []float64
+	for i := range 40 {
+		values = append(values, float64(4+i%3))
+	}
+	values[20], values[21] = 40, 60
+	values[32] = 30
+	// Significant but too small
+	values[36] = 12
+	// Too early to have a baseline
+	values[3] = 50
+
+	spikes := DetectSpikes(series(values...), spikeOptions)
+	require.Len(t, spikes, 2)
+
+	assert.Equal(t, day("2024-01-21"), spikes[0].Start)
+	assert.Equal(t, day("2024-01-22"), spikes[0].End)
+	assert.Equal(t, Point{day("2024-01-22"), 60}, spikes[0].Peak)
+	assert.Equal(t, 5.0, spikes[0].Baseline)
+	assert.InDelta(t, 55/madScale, spikes[0].Score, 1e-9)
+	assert.Equal(t, 35.0+55, spikes[0].Excess)
+
+	assert.Equal(t, day("2024-02-02"), spikes[1].Start)
+	assert.Equal(t, spikes[1].Start, spikes[1].End)
+	assert.Less(t, spikes[1].Score, spikes[0].Score)
+
+	// Missing days count as 0
+	points := series(values...)
+	points = append(points[:10:10], points[11:]...)
+	assert.Len(t, DetectSpikes(points, spikeOptions), 2)
+
+	assert.Empty(t, DetectSpikes(series(1, 2, 3), spikeOptions))
+}
+
+func TestCauses(t *testing.T) {
+	spike := Spike{Start: day("2024-01-21"), End: day("2024-01-22"), Peak: Point{day("2024-01-22"), 60}}
+	events := []Event{
+		{Source: "release", Title: "v1.0.0", Time: day("2024-01-22")},
+		{Source: "hackernews", Title: "Show HN", Time: day("2024-01-20").Add(15 * time.Hour), Engagement: 100},
+		{Source: "youtube", Title: "Video", Time: day("2024-01-23"), Engagement: 999},
+		{Source: "reddit", Title: "Too early", Time: day("2024-01-17"), Engagement: 500},
+		{Source: "reddit", Title: "Too late", Time: day("2024-01-24")},
+	}
+
+	causes := Causes(spike, events, 3)
+	require.Len(t, causes, 3)
+	assert.Equal(t, []string{"Video", "Show HN", "v1.0.0"}, []string{causes[0].Title, causes[1].Title, causes[2].Title})
+	assert.Equal(t, []int{-1, 2, 0}, []int{causes[0].LagDays, causes[1].LagDays, causes[2].LagDays})
+	assert.InDelta(t, 2, causes[0].Score, 1e-9)
+	assert.InDelta(t, 1, causes[2].Score, 1e-9)
+
+	assert.Empty(t, Causes(spike, nil, 3))
+}
@@ -1,7 +1,7 @@
 package cache
 
 import (
-	"fmt"
+	"context"
 	"sync"
 	"time"
 )
@@ -10,6 +10,9 @@ import (
 type Cache[T any] struct {
 	mu    sync.Mutex
 	items map[string]CacheItem[T]
+
+	// staleFor is how long items are kept after expiring so GetStale can still serve them
+	staleFor time.Duration
 }
 
 // CacheItem represents an item stored in the cache.
@@ -18,6 +21,11 @@ type CacheItem[T any] struct {
 	Expiration time.Time
 }
 
+// expired reports whether the item has expired at now. A zero expiration never expires.
+func (i CacheItem[T]) expired(now time.Time) bool {
+	return !i.Expiration.IsZero() && i.Expiration.Before(now)
+}
+
 // NewCache creates a new instance of the cache.
 func NewCache[T any]() *Cache[T] {
 	return &Cache[T]{
@@ -25,13 +33,24 @@ func NewCache[T any]() *Cache[T] {
 	}
 }
 
+// NewStaleCache creates a cache that keeps expired items for staleFor,
+// so they can still be served with GetStale while they are being refreshed.
+func NewStaleCache[T any](staleFor time.Duration) *Cache[T] {
+	c := NewCache[T]()
+	c.staleFor = staleFor
+	return c
+}
+
+// gone reports whether the item is past both its expiration and the stale window.
+func (c *Cache[T]) gone(item CacheItem[T], now time.Time) bool {
+	return item.expired(now.Add(-c.staleFor))
+}
+
 // Set adds an item to the cache with a specified key and expiration time.
 func (c *Cache[T]) Set(key string, value T, expiration time.Time) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	fmt.Printf("Setting %s\n", key)
-
 	c.items[key] = CacheItem[T]{
 		Value:      value,
 		Expiration: expiration,
@@ -40,23 +59,72 @@ func (c *Cache[T]) Set(key string, value T, expiration time.Time) {
 
 // Get retrieves an item from the cache by its key.
 func (c *Cache[T]) Get(key string) (T, bool) {
+	value, _, found := c.GetWithExpiration(key)
+	return value, found
+}
+
+// GetWithExpiration retrieves an item and its expiration time from the cache by its key.
+func (c *Cache[T]) GetWithExpiration(key string) (T, time.Time, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	item, found := c.items[key]
 	if !found {
 		var zero T
-		return zero, false
+		return zero, time.Time{}, false
 	}
 
-	if item.Expiration.Before(time.Now()) {
-		// Item has expired, remove it from the cache
-		delete(c.items, key)
+	now := time.Now()
+	if item.expired(now) {
+		// Item has expired, remove it from the cache unless it can still be served stale
+		if c.gone(item, now) {
+			delete(c.items, key)
+		}
 		var zero T
-		return zero, false
+		return zero, time.Time{}, false
 	}
 
-	return item.Value, true
+	return item.Value, item.Expiration, true
+}
+
+// GetStale retrieves an item even if it expired, as long as it is within the stale window.
+// stale reports whether the item is past its expiration.
+func (c *Cache[T]) GetStale(key string) (value T, stale bool, found bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, found := c.items[key]
+	if !found {
+		return value, false, false
+	}
+
+	now := time.Now()
+	if c.gone(item, now) {
+		delete(c.items, key)
+		return value, false, false
+	}
+
+	return item.Value, item.expired(now), true
+}
+
+// Delete removes an item from the cache.
+func (c *Cache[T]) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.items, key)
+}
+
+// DeleteExpired removes all expired items from the cache, keeping those still in the stale window.
+func (c *Cache[T]) DeleteExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for key, item := range c.items {
+		if c.gone(item, now) {
+			delete(c.items, key)
+		}
+	}
 }
 
 func (c *Cache[T]) Reset() {
@@ -65,14 +133,46 @@ func (c *Cache[T]) Reset() {
 	c.items = make(map[string]CacheItem[T])
 }
 
+func (c *Cache[T]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+// GetAllKeys returns the keys of the items that haven't expired, the ones only kept for GetStale aren't listed.
 func (c *Cache[T]) GetAllKeys() []string {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	now := time.Now()
 	keys := make([]string, 0, len(c.items))
-	for key := range c.items {
-		keys = append(keys, key)
+	for key, item := range c.items {
+		if !item.expired(now) {
+			keys = append(keys, key)
+		}
 	}
 
 	return keys
 }
+
+// Expirer is implemented by caches that can drop their expired items.
+type Expirer interface {
+	DeleteExpired()
+}
+
+// RunJanitor deletes expired items from the given caches every interval until ctx is done.
+func RunJanitor(ctx context.Context, interval time.Duration, caches ...Expirer) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, c := range caches {
+				c.DeleteExpired()
+			}
+		}
+	}
+}
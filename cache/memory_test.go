@@ -45,3 +45,53 @@ func TestGetAllKeys(t *testing.T) {
 	keys := cache.GetAllKeys()
 	assert.ElementsMatch(t, []string{"emanuelef/gh-repo-stats-server", "helm/helm-mapkubeapis"}, keys)
 }
+
+func TestDeleteAndDeleteExpired(t *testing.T) {
+	cache := NewCache[int]()
+
+	cache.Set("emanuelef/gh-repo-stats-server", 42, time.Now().Add(time.Minute))
+	cache.Set("helm/helm-mapkubeapis", 123, time.Now().Add(-time.Minute))
+	cache.Set("no/expiration", 7, time.Time{})
+
+	cache.DeleteExpired()
+	assert.ElementsMatch(t, []string{"emanuelef/gh-repo-stats-server", "no/expiration"}, cache.GetAllKeys())
+
+	cache.Delete("emanuelef/gh-repo-stats-server")
+	assert.Equal(t, 1, cache.Len())
+
+	val, found := cache.Get("no/expiration")
+	assert.True(t, found)
+	assert.Equal(t, 7, val)
+}
+
+func TestGetStale(t *testing.T) {
+	cache := NewStaleCache[int](time.Hour)
+
+	cache.Set("fresh/repo", 1, time.Now().Add(time.Minute))
+	cache.Set("stale/repo", 2, time.Now().Add(-time.Minute))
+	cache.Set("gone/repo", 3, time.Now().Add(-2*time.Hour))
+
+	val, stale, found := cache.GetStale("fresh/repo")
+	assert.True(t, found)
+	assert.False(t, stale)
+	assert.Equal(t, 1, val)
+
+	val, stale, found = cache.GetStale("stale/repo")
+	assert.True(t, found)
+	assert.True(t, stale)
+	assert.Equal(t, 2, val)
+
+	// Get never returns stale items, but leaves them for GetStale
+	_, found = cache.Get("stale/repo")
+	assert.False(t, found)
+
+	_, _, found = cache.GetStale("gone/repo")
+	assert.False(t, found)
+
+	// Stale items are kept, but not listed as cached
+	cache.DeleteExpired()
+	assert.Equal(t, 2, cache.Len())
+	assert.ElementsMatch(t, []string{"fresh/repo"}, cache.GetAllKeys())
+	_, _, found = cache.GetStale("stale/repo")
+	assert.True(t, found)
+}
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Backend stores and loads named cache snapshots.
+type Backend interface {
+	// Load returns the snapshot saved under name, or nil if there is none.
+	Load(name string) ([]byte, error)
+	// Save replaces the snapshot saved under name.
+	Save(name string, data []byte) error
+}
+
+// DirBackend keeps one JSON snapshot file per cache in a local directory.
+type DirBackend struct {
+	Dir string
+}
+
+func (b DirBackend) path(name string) string {
+	return filepath.Join(b.Dir, name+".json")
+}
+
+func (b DirBackend) Load(name string) ([]byte, error) {
+	data, err := os.ReadFile(b.path(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Save writes to a temporary file first so a crash never leaves a truncated snapshot behind.
+func (b DirBackend) Save(name string, data []byte) error {
+	if err := os.MkdirAll(b.Dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(b.Dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), b.path(name))
+}
+
+// Snapshotter is implemented by caches that can be persisted.
+type Snapshotter interface {
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+// Persister saves registered caches to a Backend and restores them on startup.
+type Persister struct {
+	backend Backend
+	mu      sync.Mutex
+	caches  map[string]Snapshotter
+}
+
+// NewPersister creates a Persister writing to the given backend.
+func NewPersister(backend Backend) *Persister {
+	return &Persister{
+		backend: backend,
+		caches:  make(map[string]Snapshotter),
+	}
+}
+
+// Register adds a cache under name and restores the snapshot previously saved for it, if any.
+// The cache is registered even when restoring fails, so the next save overwrites the bad snapshot.
+func (p *Persister) Register(name string, c Snapshotter) error {
+	p.mu.Lock()
+	p.caches[name] = c
+	p.mu.Unlock()
+
+	data, err := p.backend.Load(name)
+	if err != nil {
+		return fmt.Errorf("loading %s snapshot: %w", name, err)
+	}
+	if data == nil {
+		return nil
+	}
+
+	if err := c.Restore(data); err != nil {
+		return fmt.Errorf("restoring %s snapshot: %w", name, err)
+	}
+
+	return nil
+}
+
+// SaveAll writes a snapshot of every registered cache.
+func (p *Persister) SaveAll() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	for name, c := range p.caches {
+		data, err := c.Snapshot()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("snapshotting %s: %w", name, err))
+			continue
+		}
+		if err := p.backend.Save(name, data); err != nil {
+			errs = append(errs, fmt.Errorf("saving %s snapshot: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Run saves all caches every interval until ctx is done, then saves them one last time.
+func (p *Persister) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := p.SaveAll(); err != nil {
+				log.Printf("Error saving cache snapshots on shutdown: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := p.SaveAll(); err != nil {
+				log.Printf("Error saving cache snapshots: %v", err)
+			}
+		}
+	}
+}
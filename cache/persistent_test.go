@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDay mimics stats.JSONDay: a time.Time with a lossy MarshalJSON.
+type testDay time.Time
+
+func (d testDay) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%q", time.Time(d).Format("02-01-2006"))), nil
+}
+
+// testStarsPerDay mimics stats.StarsPerDay, which marshals to an array.
+type testStarsPerDay struct {
+	Day        testDay
+	Stars      int
+	TotalStars int
+}
+
+func (s testStarsPerDay) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{s.Day, s.Stars, s.TotalStars})
+}
+
+type testResponse struct {
+	Stars         []testStarsPerDay `json:"stars"`
+	NewLast10Days int               `json:"newLast10Days"`
+	Peak          *testStarsPerDay
+	Labels        map[string]float64
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	value := testResponse{
+		Stars: []testStarsPerDay{
+			{Day: testDay(day), Stars: 3, TotalStars: 3},
+			{Day: testDay(day.AddDate(0, 0, 1)), Stars: 2, TotalStars: 5},
+		},
+		NewLast10Days: 5,
+		Peak:          &testStarsPerDay{Day: testDay(day), Stars: 3, TotalStars: 3},
+		Labels:        map[string]float64{"ratio": 0.5},
+	}
+	expiration := time.Now().Add(time.Hour).Round(0)
+
+	src := NewCache[testResponse]()
+	src.Set("helm/helm", value, expiration)
+	src.Set("expired/repo", value, time.Now().Add(-time.Minute))
+
+	data, err := src.Snapshot()
+	require.NoError(t, err)
+
+	dst := NewCache[testResponse]()
+	require.NoError(t, dst.Restore(data))
+
+	assert.ElementsMatch(t, []string{"helm/helm"}, dst.GetAllKeys())
+
+	restored, restoredExpiration, found := dst.GetWithExpiration("helm/helm")
+	assert.True(t, found)
+	assert.Equal(t, value, restored)
+	assert.True(t, expiration.Equal(restoredExpiration))
+}
+
+func TestRestoreKeepsExistingItems(t *testing.T) {
+	src := NewCache[int]()
+	src.Set("repo", 1, time.Now().Add(time.Hour))
+	data, err := src.Snapshot()
+	require.NoError(t, err)
+
+	dst := NewCache[int]()
+	dst.Set("repo", 2, time.Now().Add(time.Hour))
+	require.NoError(t, dst.Restore(data))
+
+	val, found := dst.Get("repo")
+	assert.True(t, found)
+	assert.Equal(t, 2, val)
+}
+
+func TestPersisterRoundTrip(t *testing.T) {
+	backend := DirBackend{Dir: t.TempDir()}
+
+	stars := NewCache[[]int]()
+	stars.Set("helm/helm", []int{1, 2, 3}, time.Now().Add(time.Hour))
+
+	p := NewPersister(backend)
+	require.NoError(t, p.Register("stars", stars))
+	require.NoError(t, p.SaveAll())
+
+	reloaded := NewCache[[]int]()
+	require.NoError(t, NewPersister(backend).Register("stars", reloaded))
+
+	val, found := reloaded.Get("helm/helm")
+	assert.True(t, found)
+	assert.Equal(t, []int{1, 2, 3}, val)
+}
+
+func TestPersisterRegisterWithoutSnapshot(t *testing.T) {
+	p := NewPersister(DirBackend{Dir: t.TempDir()})
+	assert.NoError(t, p.Register("stars", NewCache[int]()))
+}
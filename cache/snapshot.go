@@ -0,0 +1,302 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Snapshots are encoded field by field through reflection instead of relying on
+// each value's own MarshalJSON. The stats types from github-repo-activity-stats
+// marshal to compact arrays for the frontend and don't round-trip through
+// encoding/json, so the snapshot format keeps exported field names and stores
+// any type convertible to time.Time (such as stats.JSONDay) as an RFC 3339
+// timestamp.
+
+var timeType = reflect.TypeFor[time.Time]()
+
+type snapshotItem struct {
+	Key        string          `json:"key"`
+	Value      json.RawMessage `json:"value"`
+	Expiration time.Time       `json:"expiration"`
+}
+
+// Snapshot encodes all items that can still be served in the cache together with their expirations.
+func (c *Cache[T]) Snapshot() ([]byte, error) {
+	c.mu.Lock()
+	now := time.Now()
+	items := make(map[string]CacheItem[T], len(c.items))
+	for key, item := range c.items {
+		if !c.gone(item, now) {
+			items[key] = item
+		}
+	}
+	c.mu.Unlock()
+
+	snapshot := make([]snapshotItem, 0, len(items))
+	for key, item := range items {
+		value, err := marshalSnapshotValue(item.Value)
+		if err != nil {
+			return nil, fmt.Errorf("encoding %s: %w", key, err)
+		}
+		snapshot = append(snapshot, snapshotItem{
+			Key:        key,
+			Value:      value,
+			Expiration: item.Expiration,
+		})
+	}
+
+	return json.Marshal(snapshot)
+}
+
+// Restore loads the items of a snapshot into the cache, skipping any that can no longer be served.
+// Items already in the cache are kept when the snapshot holds the same key.
+func (c *Cache[T]) Restore(data []byte) error {
+	var snapshot []snapshotItem
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	restored := make(map[string]CacheItem[T], len(snapshot))
+	for _, s := range snapshot {
+		item := CacheItem[T]{Expiration: s.Expiration}
+		if c.gone(item, now) {
+			continue
+		}
+		if err := unmarshalSnapshotValue(s.Value, &item.Value); err != nil {
+			return fmt.Errorf("decoding %s: %w", s.Key, err)
+		}
+		restored[s.Key] = item
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, item := range restored {
+		if _, exists := c.items[key]; !exists {
+			c.items[key] = item
+		}
+	}
+
+	return nil
+}
+
+func marshalSnapshotValue(v any) ([]byte, error) {
+	tree, err := toSnapshotTree(reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(tree)
+}
+
+func unmarshalSnapshotValue(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var tree any
+	if err := dec.Decode(&tree); err != nil {
+		return err
+	}
+	return fromSnapshotTree(tree, reflect.ValueOf(v).Elem())
+}
+
+// toSnapshotTree converts v into plain maps, slices and scalars that encoding/json
+// handles without calling any custom marshalers.
+func toSnapshotTree(v reflect.Value) (any, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	t := v.Type()
+	if t.Kind() == reflect.Struct && t.ConvertibleTo(timeType) {
+		return v.Convert(timeType).Interface().(time.Time).Format(time.RFC3339Nano), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return toSnapshotTree(v.Elem())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		}
+		list := make([]any, v.Len())
+		for i := range list {
+			elem, err := toSnapshotTree(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list[i] = elem
+		}
+		return list, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", t.Key())
+		}
+		if v.IsNil() {
+			return nil, nil
+		}
+		m := make(map[string]any, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			elem, err := toSnapshotTree(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			m[iter.Key().String()] = elem
+		}
+		return m, nil
+	case reflect.Struct:
+		m := make(map[string]any, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			elem, err := toSnapshotTree(v.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			m[field.Name] = elem
+		}
+		return m, nil
+	}
+
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+// fromSnapshotTree is the inverse of toSnapshotTree, filling v from a decoded tree.
+func fromSnapshotTree(tree any, v reflect.Value) error {
+	if tree == nil {
+		v.SetZero()
+		return nil
+	}
+
+	t := v.Type()
+	mismatch := func() error { return fmt.Errorf("cannot restore %s from %T", t, tree) }
+
+	if t.Kind() == reflect.Struct && t.ConvertibleTo(timeType) {
+		s, ok := tree.(string)
+		if !ok {
+			return mismatch()
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(parsed).Convert(t))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b, ok := tree.(bool)
+		if !ok {
+			return mismatch()
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := tree.(json.Number)
+		if !ok {
+			return mismatch()
+		}
+		i, err := n.Int64()
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := tree.(json.Number)
+		if !ok {
+			return mismatch()
+		}
+		u, err := strconv.ParseUint(n.String(), 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		n, ok := tree.(json.Number)
+		if !ok {
+			return mismatch()
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		s, ok := tree.(string)
+		if !ok {
+			return mismatch()
+		}
+		v.SetString(s)
+	case reflect.Pointer:
+		elem := reflect.New(t.Elem())
+		if err := fromSnapshotTree(tree, elem.Elem()); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Slice, reflect.Array:
+		list, ok := tree.([]any)
+		if !ok {
+			return mismatch()
+		}
+		if v.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(t, len(list), len(list)))
+		}
+		for i := 0; i < len(list) && i < v.Len(); i++ {
+			if err := fromSnapshotTree(list[i], v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m, ok := tree.(map[string]any)
+		if !ok || t.Key().Kind() != reflect.String {
+			return mismatch()
+		}
+		result := reflect.MakeMapWithSize(t, len(m))
+		for key, value := range m {
+			elem := reflect.New(t.Elem()).Elem()
+			if err := fromSnapshotTree(value, elem); err != nil {
+				return err
+			}
+			result.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), elem)
+		}
+		v.Set(result)
+	case reflect.Struct:
+		m, ok := tree.(map[string]any)
+		if !ok {
+			return mismatch()
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			value, found := m[field.Name]
+			if !field.IsExported() || !found {
+				continue
+			}
+			if err := fromSnapshotTree(value, v.Field(i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported type %s", t)
+	}
+
+	return nil
+}
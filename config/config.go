@@ -1,5 +1,62 @@
 package config
 
+import "time"
+
 const (
 	DayCached = 7
+
+	// DayStaleServed is how many days an expired stars history is still served while it is refreshed in the background
+	DayStaleServed = 30
+
+	// CacheSnapshotInterval is how often persisted caches are written to the data directory
+	CacheSnapshotInterval = 10 * time.Minute
+
+	// WarmupPause is the delay between two cache warmer fetches
+	WarmupPause = 10 * time.Minute
+	// WarmupCycleInterval is how often the cache warmer goes through its repo list
+	WarmupCycleInterval = 24 * time.Hour
+	// WarmupMinRemaining is the GitHub quota the cache warmer leaves to visitors
+	WarmupMinRemaining = 1000
+
+	// TokenReloadInterval is how often the GitHub tokens are reloaded from the environment and the tokens file
+	TokenReloadInterval = 5 * time.Minute
+
+	// GitHubRetryAttempts is how many times a GitHub call failing with a transient error is tried
+	GitHubRetryAttempts = 4
+	// GitHubRetryBaseDelay is the wait before the first retry, doubling with every retry up to GitHubRetryMaxDelay
+	GitHubRetryBaseDelay = 2 * time.Second
+	GitHubRetryMaxDelay  = time.Minute
+
+	// GitHubPageSize is how many stars, issues, forks or PRs a GraphQL page of the history fetches holds,
+	// each page costing one point of the rate limit
+	GitHubPageSize = 100
+	// GitHubPageDuration is how long fetching a GraphQL page takes, for estimates: a repo with 100K stars
+	// is fetched in about three minutes from both ends
+	GitHubPageDuration = 350 * time.Millisecond
+
+	// CompareMaxRepos is how many repos /compare puts side by side
+	CompareMaxRepos = 10
+
+	// ForecastMaxDays is how far ahead /forecast predicts
+	ForecastMaxDays = 365
+	// ForecastHistoryDays is how many of the last days of the stars history the forecast is fitted on
+	ForecastHistoryDays = 365
+
+	// SpikesBaselineDays is how many days before a day /spikes compares it with
+	SpikesBaselineDays = 28
+	// SpikesMinBaselineDays is how many days of history a day needs before /spikes considers it
+	SpikesMinBaselineDays = 7
+	// SpikesThreshold is the robust z-score a day of stars must reach to be a spike
+	SpikesThreshold = 3.5
+	// SpikesMinExcess is how many stars above its baseline a day must get to be a spike
+	SpikesMinExcess = 10
+	// SpikesLeadDays is how many days before the start of a spike an event can be its cause
+	SpikesLeadDays = 3
+	// SpikesMax is how many spikes /spikes returns, from the most significant
+	SpikesMax = 20
+
+	// MilestonesVelocityDays is how many of the last days of the stars history /milestones projects from
+	MilestonesVelocityDays = 30
+	// MilestonesMaxNext is how many upcoming milestones /milestones projects at most
+	MilestonesMaxNext = 10
 )
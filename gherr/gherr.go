@@ -0,0 +1,231 @@
+package gherr
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind tells what went wrong talking to GitHub. Each kind maps to the HTTP response the server sends back.
+type Kind string
+
+const (
+	// KindRateLimited means the token used up its hourly quota, ResetAt says when it comes back
+	KindRateLimited Kind = "rate_limited"
+	// KindSecondaryLimit means GitHub throttled the token for making too many requests too quickly
+	KindSecondaryLimit Kind = "secondary_rate_limit"
+	KindNotFound       Kind = "not_found"
+	// KindMoved means the repository was renamed or transferred
+	KindMoved        Kind = "moved"
+	KindUnauthorized Kind = "unauthorized"
+	// KindUpstream is a 5xx returned by GitHub
+	KindUpstream Kind = "upstream_error"
+	KindTimeout  Kind = "timeout"
+	KindUnknown  Kind = "unknown"
+)
+
+// DefaultSecondaryRetry is how long to wait after a secondary rate limit when GitHub doesn't say
+const DefaultSecondaryRetry = time.Minute
+
+// Error is a GitHub error with its kind
+type Error struct {
+	Kind Kind
+	// ResetAt is when the quota of a rate limited token resets, zero when unknown
+	ResetAt time.Time
+	// RetryAfter is how long GitHub asked to wait, zero when unknown
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+var (
+	retryAfterPattern = regexp.MustCompile(`retry[- ]after:?\s*(\d+)`)
+	// statusPattern reads the HTTP status of a non-200 response of the GraphQL transport,
+	// e.g. "non-200 OK status code: 502 Bad Gateway body: ..."
+	statusPattern = regexp.MustCompile(`^non-200 ok status code: (\d{3})\b`)
+)
+
+// Classify returns err as an *Error, keeping the kind of an error that was already classified.
+// It returns nil for a nil error.
+func Classify(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var classified *Error
+	if errors.As(err, &classified) {
+		return classified
+	}
+
+	e := &Error{Kind: kindOf(err), Err: err}
+	if e.Kind == KindSecondaryLimit {
+		e.RetryAfter = DefaultSecondaryRetry
+		if m := retryAfterPattern.FindStringSubmatch(strings.ToLower(innermost(err).Error())); m != nil {
+			if seconds, err := strconv.Atoi(m[1]); err == nil {
+				e.RetryAfter = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return e
+}
+
+// KindOf returns the kind of err, KindUnknown for nil
+func KindOf(err error) Kind {
+	if e := Classify(err); e != nil {
+		return e.Kind
+	}
+	return KindUnknown
+}
+
+// kindOf tells the kind of err from the HTTP status of the response, or the GraphQL error GitHub answered with.
+// Error messages can repeat the repo asked for, so they are never searched for keywords or numbers:
+// not-found is told first, as its message carries the repo name, then only the fixed start of a message is looked at.
+func kindOf(err error) Kind {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return KindTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return KindTimeout
+	}
+
+	// The error of the GraphQL client, without the context added by the callers
+	msg := strings.ToLower(innermost(err).Error())
+
+	// GraphQL NOT_FOUND: "Could not resolve to a Repository with the name 'owner/name'."
+	if strings.HasPrefix(msg, "could not resolve to ") {
+		return KindNotFound
+	}
+
+	if m := statusPattern.FindStringSubmatch(msg); m != nil {
+		status, _ := strconv.Atoi(m[1])
+		switch {
+		case status == 401:
+			return KindUnauthorized
+		case status == 301:
+			return KindMoved
+		case status == 404:
+			return KindNotFound
+		case status == 403 || status == 429:
+			// Both limits answer with the same statuses, GitHub's own message in the body tells them apart
+			body := msg[len(m[0]):]
+			if strings.Contains(body, "secondary rate limit") || strings.Contains(body, "abuse detection") {
+				return KindSecondaryLimit
+			}
+			if strings.Contains(body, "rate limit") {
+				return KindRateLimited
+			}
+		case status >= 500:
+			return KindUpstream
+		}
+		return KindUnknown
+	}
+
+	// GraphQL errors answered with a 200, told apart by their fixed messages
+	switch {
+	case strings.HasPrefix(msg, "you have exceeded a secondary rate limit"),
+		strings.HasPrefix(msg, "you have triggered an abuse detection mechanism"):
+		return KindSecondaryLimit
+	case strings.HasPrefix(msg, "api rate limit exceeded"), strings.HasPrefix(msg, "api rate limit already exceeded"):
+		// RATE_LIMITED
+		return KindRateLimited
+	case strings.HasPrefix(msg, "something went wrong while executing your query"):
+		return KindUpstream
+	}
+	return KindUnknown
+}
+
+// innermost returns the error at the end of the chain of err
+func innermost(err error) error {
+	for {
+		next := errors.Unwrap(err)
+		if next == nil {
+			return err
+		}
+		err = next
+	}
+}
+
+// Status returns the HTTP status the server answers with
+func (e *Error) Status() int {
+	switch e.Kind {
+	case KindRateLimited, KindSecondaryLimit:
+		return 429
+	case KindNotFound, KindMoved:
+		return 404
+	case KindUnauthorized:
+		return 401
+	case KindUpstream:
+		return 502
+	case KindTimeout:
+		return 504
+	}
+	return 500
+}
+
+// Message returns a message for the callers of the server, without GitHub internals
+func (e *Error) Message() string {
+	switch e.Kind {
+	case KindRateLimited:
+		return "GitHub API rate limit exceeded. Please try again later."
+	case KindSecondaryLimit:
+		return "GitHub API secondary rate limit exceeded. Please try again later."
+	case KindNotFound:
+		return "Repository not found on GitHub"
+	case KindMoved:
+		return "Repository was renamed or moved on GitHub"
+	case KindUnauthorized:
+		return "GitHub API authorization error"
+	case KindUpstream:
+		return "GitHub is having problems, please try again later"
+	case KindTimeout:
+		return "Timed out waiting for GitHub"
+	}
+	return "Internal server error while fetching GitHub data"
+}
+
+// RetryAfterSeconds returns how many seconds the caller should wait before retrying, zero when it doesn't apply
+func (e *Error) RetryAfterSeconds(now time.Time) int {
+	wait := e.RetryAfter
+	if wait == 0 && e.Kind == KindRateLimited && e.ResetAt.After(now) {
+		wait = e.ResetAt.Sub(now)
+	}
+	if wait <= 0 {
+		return 0
+	}
+	// Round up, retrying a moment too early would fail again
+	return int((wait + time.Second - 1) / time.Second)
+}
+
+// Body is the JSON error body returned for GitHub errors
+type Body struct {
+	Error      string     `json:"error"`
+	Kind       Kind       `json:"kind"`
+	RetryAfter int        `json:"retryAfter,omitempty"`
+	ResetAt    *time.Time `json:"resetAt,omitempty"`
+}
+
+// Body returns the JSON error body
+func (e *Error) Body(now time.Time) Body {
+	body := Body{
+		Error:      e.Message(),
+		Kind:       e.Kind,
+		RetryAfter: e.RetryAfterSeconds(now),
+	}
+	if !e.ResetAt.IsZero() {
+		resetAt := e.ResetAt
+		body.ResetAt = &resetAt
+	}
+	return body
+}
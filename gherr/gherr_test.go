@@ -0,0 +1,157 @@
+package gherr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyRateLimit(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+	}{
+		{"graphql", errors.New("API rate limit exceeded for user ID 123.")},
+		{"already exceeded", errors.New("API rate limit already exceeded for user ID 123.")},
+		{"403", errors.New(`non-200 OK status code: 403 Forbidden body: "{\"message\":\"API rate limit exceeded for user ID 123.\"}"`)},
+		{"429", errors.New(`non-200 OK status code: 429 Too Many Requests body: "API rate limit exceeded"`)},
+		{"wrapped", fmt.Errorf("fetching stars of helm/helm: %w", errors.New("API rate limit exceeded for user ID 123."))},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := Classify(tc.err)
+			assert.Equal(t, KindRateLimited, e.Kind)
+			assert.Equal(t, 429, e.Status())
+			assert.Contains(t, e.Message(), "rate limit")
+		})
+	}
+}
+
+func TestClassifySecondaryLimit(t *testing.T) {
+	e := Classify(errors.New("You have exceeded a secondary rate limit. Please retry after 90 seconds"))
+	assert.Equal(t, KindSecondaryLimit, e.Kind)
+	assert.Equal(t, 429, e.Status())
+	assert.Equal(t, 90*time.Second, e.RetryAfter)
+
+	e = Classify(errors.New(`non-200 OK status code: 403 Forbidden body: "You have exceeded a secondary rate limit."`))
+	assert.Equal(t, KindSecondaryLimit, e.Kind)
+	assert.Equal(t, DefaultSecondaryRetry, e.RetryAfter)
+
+	// The wait GitHub asked for isn't read from the context added around its message
+	e = Classify(fmt.Errorf("fetching acme/retry-after99999: %w", errors.New("You have exceeded a secondary rate limit.")))
+	assert.Equal(t, DefaultSecondaryRetry, e.RetryAfter)
+}
+
+func TestClassifyNotFound(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+	}{
+		{"could not resolve", errors.New("Could not resolve to a Repository with the name 'helm/nope'.")},
+		{"404", errors.New("non-200 OK status code: 404 Not Found body: \"\"")},
+		{"wrapped", fmt.Errorf("fetching stars: %w", errors.New("Could not resolve to a Repository with the name 'helm/nope'."))},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := Classify(tc.err)
+			assert.Equal(t, KindNotFound, e.Kind)
+			assert.Equal(t, 404, e.Status())
+			assert.Contains(t, e.Message(), "not found")
+		})
+	}
+}
+
+func TestClassifyIgnoresRepoName(t *testing.T) {
+	// The name of a missing repo must not decide the kind, or a single request could take tokens out of rotation
+	for _, repo := range []string{
+		"acme/http-401",
+		"acme/quota-exceeded",
+		"acme/timeout-tool",
+		"acme/rate-limit",
+		"acme/bad-credentials",
+		"acme/moved-301",
+		"acme/upstream-503",
+		"acme/secondary-rate-limit",
+	} {
+		notFound := errors.New("Could not resolve to a Repository with the name '" + repo + "'.")
+		assert.Equal(t, KindNotFound, Classify(notFound).Kind, repo)
+		assert.Equal(t, KindNotFound, Classify(fmt.Errorf("fetching %s: %w", repo, notFound)).Kind, repo)
+
+		// Context around an error that isn't GitHub's isn't searched either
+		assert.Equal(t, KindUnknown, Classify(fmt.Errorf("fetching %s: %w", repo, errors.New("unexpected end of JSON input"))).Kind, repo)
+	}
+}
+
+func TestClassifyOtherKinds(t *testing.T) {
+	testCases := []struct {
+		err    error
+		kind   Kind
+		status int
+	}{
+		{errors.New("non-200 OK status code: 401 Unauthorized body: Bad credentials"), KindUnauthorized, 401},
+		{errors.New("non-200 OK status code: 301 Moved Permanently"), KindMoved, 404},
+		{errors.New("non-200 OK status code: 502 Bad Gateway"), KindUpstream, 502},
+		{errors.New("Something went wrong while executing your query. Please include `ABC:123` when reporting this issue."), KindUpstream, 502},
+		{fmt.Errorf("fetching stars: %w", context.DeadlineExceeded), KindTimeout, 504},
+		// Only the status of the transport error counts, not numbers elsewhere
+		{errors.New("page 401 missing"), KindUnknown, 500},
+		// A 403 that isn't a rate limit says nothing about the token
+		{errors.New(`non-200 OK status code: 403 Forbidden body: "Repository access blocked"`), KindUnknown, 500},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.kind), func(t *testing.T) {
+			e := Classify(tc.err)
+			assert.Equal(t, tc.kind, e.Kind)
+			assert.Equal(t, tc.status, e.Status())
+		})
+	}
+}
+
+func TestClassifyInternalError(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+	}{
+		{"generic error", errors.New("something went wrong")},
+		{"network error", errors.New("connection refused")},
+		{"unknown", errors.New("unknown error occurred")},
+		// Keywords outside of GitHub's messages don't count
+		{"keywords", errors.New("request timeout: quota exceeded, unauthorized")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := Classify(tc.err)
+			assert.Equal(t, 500, e.Status())
+			assert.Contains(t, e.Message(), "Internal server error")
+		})
+	}
+}
+
+func TestClassifyKeepsKind(t *testing.T) {
+	e := Classify(errors.New("API rate limit exceeded"))
+	wrapped := fmt.Errorf("job failed: %w", e)
+
+	assert.Same(t, e, Classify(wrapped))
+	assert.Nil(t, Classify(nil))
+	assert.Equal(t, KindUnknown, KindOf(nil))
+}
+
+func TestBody(t *testing.T) {
+	now := time.Now()
+	e := &Error{Kind: KindRateLimited, ResetAt: now.Add(90*time.Second + 500*time.Millisecond), Err: errors.New("API rate limit exceeded")}
+
+	body := e.Body(now)
+	assert.Equal(t, KindRateLimited, body.Kind)
+	assert.Equal(t, 91, body.RetryAfter)
+	assert.NotNil(t, body.ResetAt)
+
+	assert.Zero(t, Classify(errors.New("Could not resolve to a Repository with the name 'helm/nope'.")).Body(now).RetryAfter)
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/analytics"
+	"github.com/gofiber/fiber/v2"
+)
+
+// The values of the aggregate query parameter of /allStars and the activity endpoints, named like the
+// aggregate options of the UI described in aggregate.md
+const (
+	aggregateYearly         = "yearlyBinning"
+	aggregateMonthly        = "monthlyBinning"
+	aggregateWeekly         = "weeklyBinning"
+	aggregateNormalize      = "normalize"
+	aggregateLOESS          = "loess"
+	aggregateRunningAverage = "runningAverage"
+)
+
+const (
+	// loessBandwidth is the share of the days each LOESS fit uses, as in the UI
+	loessBandwidth = 0.08
+	// runningAverageDays is the window of the running average, as in the UI
+	runningAverageDays = 120
+)
+
+var aggregatePeriods = map[string]analytics.Period{
+	aggregateYearly:  analytics.Year,
+	aggregateMonthly: analytics.Month,
+	aggregateWeekly:  analytics.Week,
+}
+
+// validAggregate reports whether aggregate is one of the supported aggregates, or empty for none
+func validAggregate(aggregate string) bool {
+	switch aggregate {
+	case "", aggregateYearly, aggregateMonthly, aggregateWeekly, aggregateNormalize, aggregateLOESS, aggregateRunningAverage:
+		return true
+	}
+	return false
+}
+
+// dailyColumns reads the per-day entries of a repostats history through their JSON encoding, shared by
+// every metric and relied upon by the UI: [day, counts..., totals...]. It returns a series per column after the day.
+func dailyColumns(entries any) ([][]analytics.Point, error) {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+
+	var columns [][]analytics.Point
+	for i, row := range rows {
+		if i == 0 {
+			if len(row) < 2 {
+				return nil, fmt.Errorf("daily entry %s has no count", row)
+			}
+			columns = make([][]analytics.Point, len(row)-1)
+		}
+		if len(row) != len(columns)+1 {
+			return nil, fmt.Errorf("daily entry %s has %d values, want %d", row, len(row), len(columns)+1)
+		}
+
+		var day string
+		if err := json.Unmarshal(row[0], &day); err != nil {
+			return nil, err
+		}
+		t, err := time.Parse("02-01-2006", day)
+		if err != nil {
+			return nil, err
+		}
+
+		for j, raw := range row[1:] {
+			var value float64
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return nil, err
+			}
+			columns[j] = append(columns[j], analytics.Point{Day: t, Value: value})
+		}
+	}
+	return columns, nil
+}
+
+// dailyPoints reads the day and the first count of the per-day entries of a repostats history, e.g. the
+// new stars of each day
+func dailyPoints(entries any) ([]analytics.Point, error) {
+	columns, err := dailyColumns(entries)
+	if err != nil || len(columns) == 0 {
+		return nil, err
+	}
+	return columns[0], nil
+}
+
+// dailyTotals reads the day and the first total of the per-day entries of a repostats history, e.g. the
+// total stars at the end of each day
+func dailyTotals(entries any) ([]analytics.Point, error) {
+	columns, err := dailyColumns(entries)
+	if err != nil || len(columns) < 2 {
+		return nil, err
+	}
+	return columns[totalColumn(len(columns))], nil
+}
+
+// totalColumn returns the index of the first total among the columns of per-day entries:
+// [day, count, total], [day, opened, closed, totalOpened, totalClosed]...
+func totalColumn(columns int) int {
+	return (columns + 1) / 2
+}
+
+// aggregateRows applies aggregate to the per-day entries of a repostats history, keeping their JSON
+// encoding: the counts are aggregated and the totals are those at the end of each bin when binning,
+// left as they are otherwise
+func aggregateRows(entries any, aggregate string) ([][]any, error) {
+	columns, err := dailyColumns(entries)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return [][]any{}, nil
+	}
+
+	counts := totalColumn(len(columns))
+	period, binned := aggregatePeriods[aggregate]
+	for j, column := range columns {
+		switch {
+		case j >= counts:
+			if binned {
+				columns[j] = analytics.BinLast(column, period)
+			}
+		case binned:
+			columns[j] = analytics.BinAverage(column, period)
+		case aggregate == aggregateNormalize:
+			columns[j], _ = analytics.Normalize(column)
+		case aggregate == aggregateLOESS:
+			columns[j] = analytics.LOESS(column, loessBandwidth)
+		case aggregate == aggregateRunningAverage:
+			columns[j] = analytics.RunningAverage(column, runningAverageDays)
+		}
+	}
+
+	rows := make([][]any, len(columns[0]))
+	for i := range rows {
+		row := []any{columns[0][i].Day.Format("02-01-2006")}
+		for _, column := range columns {
+			row = append(row, column[i].Value)
+		}
+		rows[i] = row
+	}
+	return rows, nil
+}
+
+// sendSeries answers with res, whose field holds the per-day entries, aggregated with aggregate unless
+// it is empty. The aggregated response names its aggregate.
+func sendSeries(c *fiber.Ctx, res any, field string, entries any, aggregate string) error {
+	if aggregate == "" {
+		return c.JSON(res)
+	}
+
+	body, err := aggregatedBody(res, field, entries, aggregate)
+	if err != nil {
+		log.Printf("Error aggregating %s with %s: %v", field, aggregate, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Could not aggregate " + field})
+	}
+	return c.JSON(body)
+}
+
+func aggregatedBody(res any, field string, entries any, aggregate string) (map[string]json.RawMessage, error) {
+	rows, err := aggregateRows(entries, aggregate)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+
+	if body[field], err = json.Marshal(rows); err != nil {
+		return nil, err
+	}
+	body["aggregate"], _ = json.Marshal(aggregate)
+	return body, nil
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/cache"
+	"github.com/emanuelef/gh-repo-stats-server/inflight"
+	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/emanuelef/gh-repo-stats-server/types"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregateRows(t *testing.T) {
+	// Mon 29 Jan to Sun 11 Feb, as encoded by the per-day issues: [day, opened, closed, totalOpened, totalClosed]
+	var entries [][]any
+	for i := range 14 {
+		day := time.Date(2024, 1, 29, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i)
+		entries = append(entries, []any{day.Format("02-01-2006"), i, 1, i * (i + 1) / 2, i + 1})
+	}
+
+	rows, err := aggregateRows(entries, aggregateWeekly)
+	require.NoError(t, err)
+	assert.Equal(t, [][]any{
+		{"29-01-2024", 3.0, 1.0, 21.0, 7.0},
+		{"05-02-2024", 10.0, 1.0, 91.0, 14.0},
+	}, rows)
+
+	// The totals are left as they are
+	rows, err = aggregateRows(entries, aggregateRunningAverage)
+	require.NoError(t, err)
+	require.Len(t, rows, 14)
+	assert.Equal(t, []any{"29-01-2024", 6.5, 1.0, 0.0, 1.0}, rows[0])
+
+	_, err = aggregateRows([][]any{{"29-01-2024"}}, aggregateWeekly)
+	assert.Error(t, err)
+}
+
+func TestAllStarsAggregate(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+	globalClientSelector.setRateLimit("PAT", &repostats.RateLimit{Limit: 5000, Remaining: 5000, ResetAt: time.Now().Add(time.Hour)})
+
+	pool, err := tokens.NewPool(
+		func() ([]tokens.Token, error) { return []tokens.Token{{Label: "PAT", Value: "a"}}, nil },
+		func(tokens.Token) *repostats.ClientGQL { return &repostats.ClientGQL{} },
+	)
+	require.NoError(t, err)
+
+	cacheStars := cache.NewStaleCache[types.StarsWithStatsResponse](time.Hour)
+	res := starsHistory(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 100, 1, 2, 3)
+	res.NewLast10Days = 6
+	cacheStars.Set("helm/helm", res, time.Now().Add(time.Hour))
+
+	var onGoing inflight.Group[types.StarsWithStatsResponse]
+	app := fiber.New()
+	app.Get("/allStars", AllStarsHandler(pool, cacheStars, &onGoing, session.NewHub(0), &types.RequestStats{}, context.Background()))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/allStars?repo=helm/helm&aggregate=normalize", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	var body struct {
+		Aggregate     string  `json:"aggregate"`
+		Stars         [][]any `json:"stars"`
+		NewLast10Days int     `json:"newLast10Days"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, aggregateNormalize, body.Aggregate)
+	assert.Equal(t, 6, body.NewLast10Days)
+	require.Len(t, body.Stars, 4)
+	// The first day is clipped at the 98th percentile, its total is left as it is
+	assert.Equal(t, "01-01-2024", body.Stars[0][0])
+	assert.InDelta(t, 94.18, body.Stars[0][1], 1e-9)
+	assert.Equal(t, 100.0, body.Stars[0][2])
+	assert.Equal(t, []any{"02-01-2024", 1.0, 101.0}, body.Stars[1])
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/allStars?repo=helm/helm&aggregate=median", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
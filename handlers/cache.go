@@ -6,34 +6,36 @@ import (
 	"strconv"
 	"strings"
 
-	cache "github.com/Code-Hex/go-generics-cache"
+	"github.com/emanuelef/gh-repo-stats-server/cache"
+	"github.com/emanuelef/gh-repo-stats-server/inflight"
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
 	"github.com/emanuelef/gh-repo-stats-server/types"
 	"github.com/emanuelef/gh-repo-stats-server/utils"
 	"github.com/emanuelef/github-repo-activity-stats/stats"
 	"github.com/gofiber/fiber/v2"
 )
 
-func AllKeysHandler(cacheOverall *cache.Cache[string, *stats.RepoStats]) fiber.Handler {
+func AllKeysHandler(cacheOverall *cache.Cache[*stats.RepoStats]) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		return c.JSON(cacheOverall.Keys())
+		return c.JSON(cacheOverall.GetAllKeys())
 	}
 }
 
-func AllStarsKeysHandler(cacheStars *cache.Cache[string, types.StarsWithStatsResponse]) fiber.Handler {
+func AllStarsKeysHandler(cacheStars *cache.Cache[types.StarsWithStatsResponse]) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		return c.JSON(cacheStars.Keys())
+		return c.JSON(cacheStars.GetAllKeys())
 	}
 }
 
-func AllReleasesKeysHandler(cacheReleases *cache.Cache[string, []stats.ReleaseInfo]) fiber.Handler {
+func AllReleasesKeysHandler(cacheReleases *cache.Cache[[]stats.ReleaseInfo]) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		return c.JSON(cacheReleases.Keys())
+		return c.JSON(cacheReleases.GetAllKeys())
 	}
 }
 
 func CleanAllCacheHandler(
-	cacheOverall *cache.Cache[string, *stats.RepoStats],
-	cacheStars *cache.Cache[string, types.StarsWithStatsResponse],
+	cacheOverall *cache.Cache[*stats.RepoStats],
+	cacheStars *cache.Cache[types.StarsWithStatsResponse],
 ) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		cacheOverall.DeleteExpired()
@@ -42,21 +44,22 @@ func CleanAllCacheHandler(
 	}
 }
 
-func AllStarsCSVHandler(cacheStars *cache.Cache[string, types.StarsWithStatsResponse]) fiber.Handler {
+func AllStarsCSVHandler(cacheStars *cache.Cache[types.StarsWithStatsResponse]) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		param := c.Query("repo")
 		repo, err := url.QueryUnescape(param)
 		if err != nil {
 			return err
 		}
+		repo = repoid.Normalize(repo)
 
-		if res, hit := cacheStars.Get(repo); hit {
+		if res, _, hit := cacheStars.GetStale(repo); hit {
 			csvData, err := utils.GenerateCSVData(repo, res.Stars)
 			if err != nil {
 				return c.Status(500).SendString("Internal Server Error")
 			}
 
-			c.Set("Content-Disposition", `attachment; filename="stars_history.csv"`)
+			c.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, csvFileName(repo, "stars_history")))
 			c.Set("Content-Type", "text/csv")
 
 			return c.SendString(csvData)
@@ -66,9 +69,18 @@ func AllStarsCSVHandler(cacheStars *cache.Cache[string, types.StarsWithStatsResp
 	}
 }
 
+// csvFileName names the CSV export of a repo, prefixed with the host for repos not on github.com
+// so that exports of same-named repos on different hosts don't overwrite each other
+func csvFileName(repo, name string) string {
+	if host := repoid.Host(repo); host != repoid.PublicHost {
+		name = strings.NewReplacer(".", "_", ":", "_").Replace(host) + "_" + name
+	}
+	return name + ".csv"
+}
+
 func StatusHandler(
-	cacheStars *cache.Cache[string, types.StarsWithStatsResponse],
-	onGoingStars map[string]bool,
+	cacheStars *cache.Cache[types.StarsWithStatsResponse],
+	onGoingStars *inflight.Group[types.StarsWithStatsResponse],
 ) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		param := c.Query("repo")
@@ -76,12 +88,14 @@ func StatusHandler(
 		if err != nil {
 			return err
 		}
+		repo = repoid.Normalize(repo)
 
-		_, cached := cacheStars.Get(repo)
-		_, onGoing := onGoingStars[repo]
+		_, stale, cached := cacheStars.GetStale(repo)
+		onGoing := onGoingStars.InFlight(repo)
 
 		data := map[string]any{
 			"cached":  cached,
+			"stale":   stale,
 			"onGoing": onGoing,
 		}
 
@@ -89,7 +103,7 @@ func StatusHandler(
 	}
 }
 
-func DeleteRecentStarsCacheHandler(cacheStars *cache.Cache[string, types.StarsWithStatsResponse]) fiber.Handler {
+func DeleteRecentStarsCacheHandler(cacheStars *cache.Cache[types.StarsWithStatsResponse]) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		param := c.Query("repo")
 		nStr := c.Query("days", "0")
@@ -104,10 +118,10 @@ func DeleteRecentStarsCacheHandler(cacheStars *cache.Cache[string, types.StarsWi
 		if err != nil {
 			return err
 		}
-		repo = strings.ToLower(repo)
+		repo = repoid.Normalize(repo)
 		repo = strings.Clone(repo) // Fiber's c.Query returns unsafe strings backed by a reusable buffer
 
-		cached, found := cacheStars.Get(repo)
+		cached, expiration, found := cacheStars.GetWithExpiration(repo)
 		if !found {
 			return c.Status(404).SendString("No cache for this repo")
 		}
@@ -118,7 +132,7 @@ func DeleteRecentStarsCacheHandler(cacheStars *cache.Cache[string, types.StarsWi
 			cached.Stars = cached.Stars[:len(cached.Stars)-n]
 		}
 
-		cacheStars.Set(repo, cached)
+		cacheStars.Set(repo, cached, expiration)
 
 		return c.SendString(fmt.Sprintf("Removed last %d days from cache for repo %s.", n, repo))
 	}
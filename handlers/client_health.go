@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/gherr"
+)
+
+// Circuit breaker states of a client
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// breakerPolicy says after how many consecutive failures of a kind a client is taken out of
+// rotation, and for how long at first. Each failed probe doubles the cool-down up to maxCoolDown.
+// Only these kinds of errors point at a problem with the token rather than with the request.
+var breakerPolicy = map[gherr.Kind]struct {
+	threshold int
+	coolDown  time.Duration
+}{
+	gherr.KindUnauthorized:   {threshold: 1, coolDown: 30 * time.Minute},
+	gherr.KindSecondaryLimit: {threshold: 1, coolDown: 2 * time.Minute},
+	gherr.KindUpstream:       {threshold: 3, coolDown: 30 * time.Second},
+}
+
+const maxCoolDown = 2 * time.Hour
+
+// ClientHealth is the circuit breaker state of a client
+type ClientHealth struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastFailure         gherr.Kind `json:"lastFailure,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+	LastFailureAt       time.Time  `json:"lastFailureAt,omitempty"`
+	// OpenUntil is when an open client gets a half-open probe
+	OpenUntil time.Time `json:"openUntil,omitempty"`
+	// Trips counts how many times the breaker opened since the client last succeeded
+	Trips int `json:"trips"`
+
+	probing bool
+}
+
+// classifyClientFailure reports whether err means the token itself is failing, and how
+func classifyClientFailure(err error) (*gherr.Error, bool) {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return nil, false
+	}
+
+	ghErr := gherr.Classify(err)
+	_, failed := breakerPolicy[ghErr.Kind]
+	return ghErr, failed
+}
+
+// ReportClientResult records the outcome of a GitHub call made with the client key.
+// Errors not caused by the token (e.g. a missing repo) count as a success.
+func ReportClientResult(key string, err error) {
+	globalClientSelector.reportResult(key, err, time.Now())
+}
+
+func (cs *ClientSelector) reportResult(key string, err error, now time.Time) {
+	if errors.Is(err, context.Canceled) {
+		// Says nothing about the token, but frees the half-open probe
+		cs.mu.Lock()
+		if h, ok := cs.health[key]; ok {
+			h.probing = false
+		}
+		cs.mu.Unlock()
+		return
+	}
+
+	ghErr, failed := classifyClientFailure(err)
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	h, ok := cs.health[key]
+	if !ok {
+		if !failed {
+			return
+		}
+		h = &ClientHealth{State: BreakerClosed}
+		cs.health[key] = h
+	}
+	h.probing = false
+
+	if !failed {
+		if h.State != BreakerClosed {
+			log.Printf("Client %s recovered, closing its circuit breaker", key)
+		}
+		delete(cs.health, key)
+		return
+	}
+
+	if h.LastFailure != ghErr.Kind {
+		h.ConsecutiveFailures = 0
+	}
+	h.ConsecutiveFailures++
+	h.LastFailure = ghErr.Kind
+	h.LastError = err.Error()
+	h.LastFailureAt = now
+
+	policy := breakerPolicy[ghErr.Kind]
+	if h.State == BreakerHalfOpen || h.ConsecutiveFailures >= policy.threshold {
+		h.Trips++
+		coolDown := policy.coolDown << min(h.Trips-1, 10)
+		if coolDown > maxCoolDown {
+			coolDown = maxCoolDown
+		}
+		// GitHub may ask for a longer wait after a secondary rate limit
+		if ghErr.RetryAfter > coolDown {
+			coolDown = ghErr.RetryAfter
+		}
+		h.State = BreakerOpen
+		h.OpenUntil = now.Add(coolDown)
+		log.Printf("Client %s taken out of rotation for %v after %d %s failures", key, coolDown, h.ConsecutiveFailures, ghErr.Kind)
+	}
+}
+
+// available reports whether a client can be handed out, moving it to half-open once its cool-down is over.
+// Only one half-open probe runs at a time. cs.mu must be held for writing.
+func (cs *ClientSelector) available(key string, now time.Time) bool {
+	h, ok := cs.health[key]
+	if !ok {
+		return true
+	}
+
+	switch h.State {
+	case BreakerOpen:
+		if now.Before(h.OpenUntil) {
+			return false
+		}
+		h.State = BreakerHalfOpen
+		log.Printf("Client %s half-open, probing with the next request", key)
+		return true
+	case BreakerHalfOpen:
+		return !h.probing
+	}
+	return true
+}
+
+// startClientProbe makes the coming GitHub call with the client key the probe of its half-open breaker.
+// It's done when the call is made rather than when the client is selected, as a request answered from the
+// cache never reports a result that would end the probe.
+func startClientProbe(key string) {
+	globalClientSelector.mu.Lock()
+	defer globalClientSelector.mu.Unlock()
+	globalClientSelector.startProbe(key)
+}
+
+// startProbe marks the half-open client as probing. cs.mu must be held for writing.
+func (cs *ClientSelector) startProbe(key string) {
+	if h, ok := cs.health[key]; ok && h.State == BreakerHalfOpen {
+		h.probing = true
+	}
+}
+
+// GetClientHealth returns the circuit breaker state of every client that failed recently
+func (cs *ClientSelector) GetClientHealth() map[string]ClientHealth {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	now := time.Now()
+	result := make(map[string]ClientHealth, len(cs.health))
+	for key, h := range cs.health {
+		health := *h
+		if health.State == BreakerOpen && !now.Before(health.OpenUntil) {
+			health.State = BreakerHalfOpen
+		}
+		result[key] = health
+	}
+	return result
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/gherr"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyClientFailure(t *testing.T) {
+	tests := []struct {
+		err    error
+		failed bool
+	}{
+		{errors.New("non-200 OK status code: 401 Unauthorized body: Bad credentials"), true},
+		{errors.New("You have exceeded a secondary rate limit."), true},
+		{errors.New("non-200 OK status code: 502 Bad Gateway"), true},
+		{errors.New("API rate limit exceeded for user ID 123."), false},
+		{errors.New("Could not resolve to a Repository with the name 'acme/http-401'"), false},
+		{fmt.Errorf("fetch: %w", context.Canceled), false},
+	}
+
+	for _, tt := range tests {
+		_, failed := classifyClientFailure(tt.err)
+		assert.Equal(t, tt.failed, failed, tt.err.Error())
+	}
+}
+
+func TestBreakerOpensAndProbes(t *testing.T) {
+	cs := NewClientSelector()
+	now := time.Now()
+	serverErr := errors.New("non-200 OK status code: 503 Service Unavailable")
+
+	// Server errors open the breaker after three in a row
+	cs.reportResult("PAT", serverErr, now)
+	cs.reportResult("PAT", serverErr, now)
+	assert.True(t, cs.available("PAT", now))
+	cs.reportResult("PAT", serverErr, now)
+	assert.False(t, cs.available("PAT", now))
+	assert.Equal(t, BreakerOpen, cs.GetClientHealth()["PAT"].State)
+
+	// After the cool-down a single probe is let through
+	later := now.Add(breakerPolicy[gherr.KindUpstream].coolDown + time.Second)
+	assert.True(t, cs.available("PAT", later))
+	cs.startProbe("PAT")
+	assert.False(t, cs.available("PAT", later))
+
+	// A failed probe reopens it for twice as long
+	cs.reportResult("PAT", serverErr, later)
+	h := cs.GetClientHealth()["PAT"]
+	assert.Equal(t, BreakerOpen, h.State)
+	assert.Equal(t, 2, h.Trips)
+	assert.Equal(t, later.Add(2*breakerPolicy[gherr.KindUpstream].coolDown), h.OpenUntil)
+
+	// A successful probe closes it
+	muchLater := h.OpenUntil.Add(time.Second)
+	assert.True(t, cs.available("PAT", muchLater))
+	cs.reportResult("PAT", nil, muchLater)
+	assert.Empty(t, cs.GetClientHealth())
+}
+
+func TestSelectBestClientSkipsOpenBreaker(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+	clients := map[string]*repostats.ClientGQL{"PAT": {}, "PAT2": {}}
+
+	for _, key := range []string{"PAT", "PAT2"} {
+		globalClientSelector.rateLimits[key] = &ClientRateLimitInfo{
+			Remaining: 5000,
+			Limit:     5000,
+			ResetAt:   time.Now().Add(time.Hour),
+			UpdatedAt: time.Now(),
+		}
+	}
+	globalClientSelector.rateLimits["PAT2"].Remaining = 4000
+
+	ReportClientResult("PAT", errors.New("non-200 OK status code: 401 Unauthorized body: Bad credentials"))
+
+	key, _ := SelectBestClient(context.Background(), clients, "")
+	assert.Equal(t, "PAT2", key)
+
+	// An explicitly requested client is still used
+	key, _ = SelectBestClient(context.Background(), clients, "PAT")
+	assert.Equal(t, "PAT", key)
+
+	// With every breaker open the one reopening first is used
+	ReportClientResult("PAT2", errors.New("You have exceeded a secondary rate limit."))
+	key, client := SelectBestClient(context.Background(), clients, "")
+	assert.Equal(t, "PAT2", key)
+	assert.NotNil(t, client)
+}
+
+func TestProbeClientAnsweringFromCache(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+	globalClientSelector.setRateLimit("PAT", &repostats.RateLimit{Remaining: 5000, Limit: 5000, ResetAt: time.Now().Add(time.Hour)})
+	globalClientSelector.setRateLimit("PAT2", &repostats.RateLimit{Remaining: 4000, Limit: 5000, ResetAt: time.Now().Add(time.Hour)})
+
+	pool, err := tokens.NewPool(
+		func() ([]tokens.Token, error) {
+			return []tokens.Token{{Label: "PAT", Value: "a"}, {Label: "PAT2", Value: "b"}}, nil
+		},
+		func(tokens.Token) *repostats.ClientGQL { return &repostats.ClientGQL{} },
+	)
+	require.NoError(t, err)
+
+	// Half-open: the cool-down of its breaker is over
+	ReportClientResult("PAT", errors.New("non-200 OK status code: 401 Unauthorized"))
+	globalClientSelector.health["PAT"].OpenUntil = time.Now().Add(-time.Second)
+
+	app := fiber.New()
+	app.Get("/fetch", withGitHubClient(context.Background(), pool, func(c *fiber.Ctx, calls *githubCalls) error {
+		if c.Query("cached") == "true" {
+			return c.SendString(calls.Key())
+		}
+		_, err := callGitHub(c.UserContext(), calls, func(ctx context.Context, client *repostats.ClientGQL) (int, error) {
+			// The probe is running, no other request gets the client meanwhile
+			globalClientSelector.mu.Lock()
+			defer globalClientSelector.mu.Unlock()
+			assert.False(t, globalClientSelector.available("PAT", time.Now()))
+			return 0, nil
+		})
+		if err != nil {
+			return err
+		}
+		return c.SendString(calls.Key())
+	}))
+
+	// Requests answered from the cache make no call, the client stays available for the probe
+	for range 3 {
+		resp, err := app.Test(httptest.NewRequest("GET", "/fetch?cached=true", nil))
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "PAT", string(body))
+		assert.Equal(t, BreakerHalfOpen, globalClientSelector.GetClientHealth()["PAT"].State)
+	}
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/fetch", nil))
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "PAT", string(body))
+	assert.Empty(t, globalClientSelector.GetClientHealth())
+}
@@ -21,7 +21,9 @@ type ClientRateLimitInfo struct {
 type ClientSelector struct {
 	mu           sync.RWMutex
 	rateLimits   map[string]*ClientRateLimitInfo
-	busyClients  map[string]string // key -> repo being processed
+	busyClients  map[string]string        // key -> repo being processed
+	busyCount    map[string]int           // key -> number of operations running with the client
+	health       map[string]*ClientHealth // circuit breakers of the clients that failed recently
 	cacheTTL     time.Duration
 	minRemaining int // minimum remaining before refreshing cache
 }
@@ -31,6 +33,8 @@ func NewClientSelector() *ClientSelector {
 	return &ClientSelector{
 		rateLimits:   make(map[string]*ClientRateLimitInfo),
 		busyClients:  make(map[string]string),
+		busyCount:    make(map[string]int),
+		health:       make(map[string]*ClientHealth),
 		cacheTTL:     5 * time.Minute, // Cache rate limits for 5 minutes
 		minRemaining: 100,             // Refresh if remaining < 100
 	}
@@ -40,8 +44,9 @@ func NewClientSelector() *ClientSelector {
 var globalClientSelector = NewClientSelector()
 
 // SelectBestClient chooses the best available client based on:
-// 1. Not currently busy with a long-running operation
-// 2. Most remaining API requests
+// 1. Circuit breaker not open (see ReportClientResult)
+// 2. Not currently busy with a long-running operation
+// 3. Most remaining API requests
 // It uses cached rate limit info to avoid excessive API calls
 func SelectBestClient(
 	ctx context.Context,
@@ -62,14 +67,28 @@ func SelectBestClient(
 		}
 	}
 
-	globalClientSelector.mu.RLock()
+	now := time.Now()
+
+	globalClientSelector.mu.Lock()
 	bestIdleKey := ""
 	bestIdleRemaining := -1
 	bestBusyKey := ""
 	bestBusyRemaining := -1
 	needsRefresh := []string{}
+	usable := []string{}
+	// Client whose breaker closes first, used when every breaker is open
+	soonestKey := ""
+	var soonest time.Time
 
 	for key := range ghStatClients {
+		if !globalClientSelector.available(key, now) {
+			if h := globalClientSelector.health[key]; soonestKey == "" || h.OpenUntil.Before(soonest) {
+				soonestKey, soonest = key, h.OpenUntil
+			}
+			continue
+		}
+		usable = append(usable, key)
+
 		info, exists := globalClientSelector.rateLimits[key]
 		if !exists || time.Since(info.UpdatedAt) > globalClientSelector.cacheTTL {
 			needsRefresh = append(needsRefresh, key)
@@ -99,7 +118,7 @@ func SelectBestClient(
 			}
 		}
 	}
-	globalClientSelector.mu.RUnlock()
+	globalClientSelector.mu.Unlock()
 
 	// Refresh stale entries (but limit to avoid too many API calls)
 	if len(needsRefresh) > 0 {
@@ -127,11 +146,11 @@ func SelectBestClient(
 
 	// If no cached info or all stale, pick first idle client and refresh
 	globalClientSelector.mu.RLock()
-	for key, client := range ghStatClients {
+	for _, key := range usable {
 		if _, isBusy := globalClientSelector.busyClients[key]; !isBusy {
 			globalClientSelector.mu.RUnlock()
-			globalClientSelector.RefreshRateLimit(ctx, key, client)
-			return key, client
+			globalClientSelector.RefreshRateLimit(ctx, key, ghStatClients[key])
+			return key, ghStatClients[key]
 		}
 	}
 	globalClientSelector.mu.RUnlock()
@@ -142,26 +161,39 @@ func SelectBestClient(
 		return bestBusyKey, ghStatClients[bestBusyKey]
 	}
 
-	// Absolute last resort: return any client (no cached info available)
-	log.Printf("WARNING: No rate limit info cached, picking first available client")
-	for k, v := range ghStatClients {
-		return k, v
+	if len(usable) > 0 {
+		// Absolute last resort: return any client (no cached info available)
+		log.Printf("WARNING: No rate limit info cached, picking first available client")
+		return usable[0], ghStatClients[usable[0]]
+	}
+
+	// Every circuit breaker is open, a client that is probably failing beats no client at all
+	if soonestKey != "" {
+		log.Printf("WARNING: All clients out of rotation, using %s whose cool-down ends first", soonestKey)
+		return soonestKey, ghStatClients[soonestKey]
 	}
 	return "", nil
 }
 
-// MarkClientBusy marks a client as busy with a long-running operation
+// MarkClientBusy marks a client as busy with a long-running operation.
+// Every call must be matched by a MarkClientIdle, the client is idle once all its operations are done.
 func MarkClientBusy(key string, repo string) {
 	globalClientSelector.mu.Lock()
 	defer globalClientSelector.mu.Unlock()
 	globalClientSelector.busyClients[key] = repo
+	globalClientSelector.busyCount[key]++
 	log.Printf("Client %s marked busy (processing %s)", key, repo)
 }
 
-// MarkClientIdle marks a client as no longer busy
+// MarkClientIdle marks an operation of the client as done
 func MarkClientIdle(key string) {
 	globalClientSelector.mu.Lock()
 	defer globalClientSelector.mu.Unlock()
+	if globalClientSelector.busyCount[key] > 1 {
+		globalClientSelector.busyCount[key]--
+		return
+	}
+	delete(globalClientSelector.busyCount, key)
 	delete(globalClientSelector.busyClients, key)
 	log.Printf("Client %s marked idle", key)
 }
@@ -177,24 +209,66 @@ func GetBusyClients() map[string]string {
 	return result
 }
 
-// RefreshRateLimit updates the cached rate limit for a client
-func (cs *ClientSelector) RefreshRateLimit(ctx context.Context, key string, client *repostats.ClientGQL) {
+// ForgetClients drops the rate limits, circuit breakers and busy state of the clients with labels, whose
+// token was removed or replaced, see tokens.Pool.OnForget
+func ForgetClients(labels []string) {
+	globalClientSelector.mu.Lock()
+	defer globalClientSelector.mu.Unlock()
+	for _, key := range labels {
+		delete(globalClientSelector.rateLimits, key)
+		delete(globalClientSelector.health, key)
+		delete(globalClientSelector.busyClients, key)
+		delete(globalClientSelector.busyCount, key)
+	}
+}
+
+// RefreshRateLimit updates the cached rate limit for a client, returning the error of the GitHub call
+func (cs *ClientSelector) RefreshRateLimit(ctx context.Context, key string, client *repostats.ClientGQL) error {
 	result, err := client.GetCurrentLimits(ctx)
 	if err != nil {
 		log.Printf("Error getting rate limits for client %s: %v", key, err)
-		return
+		if _, failed := classifyClientFailure(err); failed {
+			cs.reportResult(key, err, time.Now())
+		}
+		return err
 	}
 
+	cs.setRateLimit(key, result)
+
+	log.Printf("Client %s rate limit: %d/%d remaining, resets at %v", key, result.Remaining, result.Limit, result.ResetAt)
+	return nil
+}
+
+// refreshOutdated refreshes the rate limits of the clients not known yet, older than the cache TTL or
+// past their reset. It returns the last refresh that failed, with its client, if any.
+func (cs *ClientSelector) refreshOutdated(ctx context.Context, clients map[string]*repostats.ClientGQL) (string, error) {
+	cached := cs.GetClientStats()
+
+	var failedKey string
+	var lastErr error
+	for key, client := range clients {
+		info, ok := cached[key]
+		if ok && time.Since(info.UpdatedAt) <= cs.cacheTTL && time.Now().Before(info.ResetAt) {
+			continue
+		}
+		if err := cs.RefreshRateLimit(ctx, key, client); err != nil {
+			failedKey, lastErr = key, err
+		}
+	}
+	return failedKey, lastErr
+}
+
+// setRateLimit caches the rate limit just read for a client
+func (cs *ClientSelector) setRateLimit(key string, result *repostats.RateLimit) {
 	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
 	cs.rateLimits[key] = &ClientRateLimitInfo{
 		Remaining: result.Remaining,
 		Limit:     result.Limit,
 		ResetAt:   result.ResetAt,
 		UpdatedAt: time.Now(),
 	}
-	cs.mu.Unlock()
-
-	log.Printf("Client %s rate limit: %d/%d remaining, resets at %v", key, result.Remaining, result.Limit, result.ResetAt)
 }
 
 // UpdateAfterRequest updates the cached remaining count after a request
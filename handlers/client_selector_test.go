@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"errors"
 	"testing"
 	"time"
 
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -66,6 +68,24 @@ func TestGetBusyClientsReturnsCopy(t *testing.T) {
 	assert.Empty(t, busyAgain["NEW"])
 }
 
+func TestForgetClients(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+
+	for _, key := range []string{"PAT", "FILE_cd19452a"} {
+		globalClientSelector.setRateLimit(key, &repostats.RateLimit{Limit: 5000, Remaining: 10, ResetAt: time.Now().Add(time.Hour)})
+		globalClientSelector.reportResult(key, errors.New("non-200 OK status code: 401 Unauthorized body: Bad credentials"), time.Now())
+		MarkClientBusy(key, "owner/repo")
+	}
+
+	ForgetClients([]string{"FILE_cd19452a"})
+
+	assert.Contains(t, globalClientSelector.GetClientStats(), "PAT")
+	assert.NotContains(t, globalClientSelector.GetClientStats(), "FILE_cd19452a")
+	assert.Contains(t, globalClientSelector.GetClientHealth(), "PAT")
+	assert.NotContains(t, globalClientSelector.GetClientHealth(), "FILE_cd19452a")
+	assert.Equal(t, map[string]string{"PAT": "owner/repo"}, GetBusyClients())
+}
+
 func TestClientSelectorCacheRateLimits(t *testing.T) {
 	cs := NewClientSelector()
 
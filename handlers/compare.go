@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/analytics"
+	"github.com/emanuelef/gh-repo-stats-server/cache"
+	"github.com/emanuelef/gh-repo-stats-server/config"
+	"github.com/emanuelef/gh-repo-stats-server/jobs"
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
+	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// alignDate puts the compared series on a common date axis
+	alignDate = "date"
+	// alignCreation puts the compared series on an axis of days since the creation of their repo
+	alignCreation = "creation"
+)
+
+// compareResponse is the combined series of the compared repos on one axis: dates (02-01-2006) when
+// aligned by date, days since creation when aligned by creation
+type compareResponse struct {
+	Metric    string           `json:"metric"`
+	Align     string           `json:"align"`
+	Axis      any              `json:"axis"`
+	Series    []compareSeries  `json:"series"`
+	Summaries []compareSummary `json:"summaries"`
+	// Pending are the jobs fetching the repos that are not cached yet, left out of Series and Summaries
+	Pending []jobs.Status `json:"pending"`
+}
+
+// compareSeries holds the values of one repo on the axis, null where the repo has no data
+type compareSeries struct {
+	Repo  string     `json:"repo"`
+	Daily []*float64 `json:"daily"`
+	Total []*float64 `json:"total"`
+}
+
+type compareSummary struct {
+	Repo string `json:"repo"`
+	// CreatedAt is missing when the creation of the repo couldn't be read
+	CreatedAt  *time.Time `json:"createdAt,omitempty"`
+	FirstDay   string     `json:"firstDay"`
+	LastDay    string     `json:"lastDay"`
+	Days       int        `json:"days"`
+	Total      float64    `json:"total"`
+	MeanPerDay float64    `json:"meanPerDay"`
+	PeakDay    string     `json:"peakDay"`
+	Peak       float64    `json:"peak"`
+	Last30Days float64    `json:"last30Days"`
+}
+
+// parseCompareRepos returns the normalized repos of the comma separated list, without duplicates
+func parseCompareRepos(list string) ([]string, bool) {
+	var repos []string
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(list, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		repo := strings.Clone(repoid.Normalize(entry))
+		if !strings.Contains(repoid.Path(repo), "/") {
+			return nil, false
+		}
+		if !seen[repo] {
+			seen[repo] = true
+			repos = append(repos, repo)
+		}
+	}
+	return repos, true
+}
+
+// repoCreatedAt returns when repo was created, from cacheCreatedAt or read from GitHub
+func repoCreatedAt(
+	ctx context.Context,
+	clientPool *tokens.Pool,
+	cacheCreatedAt *cache.Cache[time.Time],
+	repo string,
+) (time.Time, error) {
+	if createdAt, hit := cacheCreatedAt.Get(repo); hit {
+		return createdAt, nil
+	}
+
+	ghStatClients := clientPool.ClientsFor(repoid.Host(repo))
+	clientKey, client := SelectBestClient(ctx, ghStatClients, "")
+	if client == nil {
+		return time.Time{}, errNoClient
+	}
+
+	calls := newGitHubCalls(ghStatClients, clientKey, client, nil)
+	createdAt, err := callGitHub(ctx, calls, func(ctx context.Context, client *repostats.ClientGQL) (time.Time, error) {
+		_, created, err := client.GetTotalStars(ctx, repoid.Path(repo))
+		return created, err
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	cacheCreatedAt.Set(repo, createdAt, cacheExpiration())
+	return createdAt, nil
+}
+
+// CompareHandler handles the /compare endpoint putting the daily series of ?metric= (stars by default) of
+// the comma separated ?repos= on a common axis, by date or by days since creation with ?align=creation,
+// with a summary of each repo. Repos not cached yet get a background job filling their cache, like POST /jobs.
+func CompareHandler(
+	ctx context.Context,
+	clientPool *tokens.Pool,
+	manager *jobs.Manager,
+	fetchers map[string]MetricFetcher,
+	cacheCreatedAt *cache.Cache[time.Time],
+) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		list, err := url.QueryUnescape(c.Query("repos"))
+		if err != nil {
+			return err
+		}
+		repos, ok := parseCompareRepos(list)
+		if !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "repos must be in the form owner/name or host/owner/name"})
+		}
+		if len(repos) < 2 || len(repos) > config.CompareMaxRepos {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Compare between 2 and %d repos", config.CompareMaxRepos)})
+		}
+
+		metric := strings.ToLower(c.Query("metric", session.MetricStars))
+		fetcher, ok := fetchers[metric]
+		if !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "Unsupported metric: " + metric})
+		}
+
+		align := strings.ToLower(c.Query("align", alignDate))
+		if align != alignDate && align != alignCreation {
+			return c.Status(400).JSON(fiber.Map{"error": "align must be date or creation"})
+		}
+
+		for _, repo := range repos {
+			if _, ok := clientPool.BaseURL(repoid.Host(repo)); !ok {
+				return c.Status(400).JSON(fiber.Map{"error": "No GitHub client for host " + repoid.Host(repo)})
+			}
+		}
+
+		span := trace.SpanFromContext(c.UserContext())
+		span.SetAttributes(attribute.StringSlice("github.repos", repos), attribute.String("github.metric", metric))
+		callCtx := trace.ContextWithSpan(ctx, span)
+
+		resp := compareResponse{Metric: metric, Align: align, Series: []compareSeries{}, Summaries: []compareSummary{}, Pending: []jobs.Status{}}
+
+		var compared []string
+		var series [][]analytics.Point
+		for _, repo := range repos {
+			points, hit, err := fetcher.Daily(repo)
+			if err != nil {
+				log.Printf("Error reading cached %s of %s: %v", metric, repo, err)
+				return c.Status(500).JSON(fiber.Map{"error": "Could not read cached " + metric + " of " + repo})
+			}
+			if !hit {
+				job, err := startFetchJob(ctx, clientPool, manager, fetcher, repo, metric, "")
+				if err != nil {
+					return c.Status(500).JSON(fiber.Map{"error": "Could not create job"})
+				}
+				resp.Pending = append(resp.Pending, job.Status())
+				continue
+			}
+			compared = append(compared, repo)
+			series = append(series, points)
+		}
+
+		createdAt := make([]time.Time, len(compared))
+		if align == alignCreation {
+			for i, repo := range compared {
+				created, err := repoCreatedAt(callCtx, clientPool, cacheCreatedAt, repo)
+				if err != nil {
+					// Aligned on its first day instead
+					log.Printf("Error getting creation of %s: %v", repo, err)
+					continue
+				}
+				createdAt[i] = created
+			}
+		}
+
+		var daily [][]*float64
+		if align == alignCreation {
+			var offsets []int
+			offsets, daily = analytics.AlignByOffset(series, createdAt)
+			resp.Axis = offsets
+		} else {
+			var days []time.Time
+			days, daily = analytics.AlignByDate(series)
+			axis := make([]string, len(days))
+			for i, day := range days {
+				axis[i] = day.Format("02-01-2006")
+			}
+			resp.Axis = axis
+		}
+
+		for i, repo := range compared {
+			resp.Series = append(resp.Series, compareSeries{Repo: repo, Daily: daily[i], Total: analytics.RunningTotal(daily[i])})
+
+			s := analytics.Summarize(series[i])
+			summary := compareSummary{
+				Repo:       repo,
+				Days:       s.Days,
+				Total:      s.Total,
+				MeanPerDay: s.Mean,
+				Peak:       s.Peak.Value,
+				Last30Days: s.Last30Days,
+			}
+			if s.Days > 0 {
+				summary.FirstDay = s.First.Format("02-01-2006")
+				summary.LastDay = s.Last.Format("02-01-2006")
+				summary.PeakDay = s.Peak.Day.Format("02-01-2006")
+			}
+			if !createdAt[i].IsZero() {
+				summary.CreatedAt = &createdAt[i]
+			}
+			resp.Summaries = append(resp.Summaries, summary)
+		}
+
+		if len(resp.Pending) > 0 {
+			return c.Status(202).JSON(resp)
+		}
+		return c.JSON(resp)
+	}
+}
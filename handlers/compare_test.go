@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/cache"
+	"github.com/emanuelef/gh-repo-stats-server/inflight"
+	"github.com/emanuelef/gh-repo-stats-server/jobs"
+	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/emanuelef/gh-repo-stats-server/types"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"github.com/emanuelef/github-repo-activity-stats/stats"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func starsHistory(from time.Time, daily ...int) types.StarsWithStatsResponse {
+	var res types.StarsWithStatsResponse
+	total := 0
+	for i, stars := range daily {
+		total += stars
+		res.Stars = append(res.Stars, stats.StarsPerDay{Day: stats.JSONDay(from.AddDate(0, 0, i)), Stars: stars, TotalStars: total})
+	}
+	return res
+}
+
+func TestCompareHandler(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+	globalClientSelector.setRateLimit("PAT", &repostats.RateLimit{Limit: 5000, Remaining: 5000, ResetAt: time.Now().Add(time.Hour)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cacheStars := cache.NewCache[types.StarsWithStatsResponse]()
+	cacheStars.Set("helm/helm", starsHistory(day, 1, 2, 3), time.Now().Add(time.Hour))
+	cacheStars.Set("cilium/cilium", starsHistory(day.AddDate(0, 0, 1), 5, 0, 7), time.Now().Add(time.Hour))
+
+	var onGoing inflight.Group[types.StarsWithStatsResponse]
+	fetcher := newMetricFetcher(session.MetricStars, session.NewHub(0), cacheStars, &onGoing,
+		func(ctx context.Context, calls *githubCalls, repo string, c *cache.Cache[types.StarsWithStatsResponse]) (types.StarsWithStatsResponse, error) {
+			res := starsHistory(day, 4)
+			c.Set(repo, res, time.Now().Add(time.Hour))
+			return res, nil
+		},
+		func(res types.StarsWithStatsResponse) any { return res.Stars })
+
+	pool, err := tokens.NewPool(
+		func() ([]tokens.Token, error) { return []tokens.Token{{Label: "PAT", Value: "a"}}, nil },
+		func(tokens.Token) *repostats.ClientGQL { return &repostats.ClientGQL{} },
+	)
+	require.NoError(t, err)
+
+	cacheCreatedAt := cache.NewCache[time.Time]()
+	cacheCreatedAt.Set("helm/helm", day.AddDate(0, 0, -2), time.Now().Add(time.Hour))
+	cacheCreatedAt.Set("cilium/cilium", day.AddDate(0, 0, 1), time.Now().Add(time.Hour))
+
+	manager := jobs.NewManager(ctx)
+	app := fiber.New()
+	app.Get("/compare", CompareHandler(ctx, pool, manager, map[string]MetricFetcher{session.MetricStars: fetcher}, cacheCreatedAt))
+
+	get := func(url string) (int, compareResponse) {
+		resp, err := app.Test(httptest.NewRequest("GET", url, nil))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var body compareResponse
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	// argoproj/argo-cd is not cached: compared once its job filled the cache
+	code, body := get("/compare?repos=Helm/Helm,cilium/cilium,argoproj/argo-cd,helm/helm")
+	assert.Equal(t, 202, code)
+	require.Len(t, body.Pending, 1)
+	assert.Equal(t, "argoproj/argo-cd", body.Pending[0].Repo)
+	job, ok := manager.Get(body.Pending[0].ID)
+	require.True(t, ok)
+	require.Eventually(t, job.Done, time.Second, time.Millisecond)
+
+	assert.Equal(t, []any{"01-01-2024", "02-01-2024", "03-01-2024", "04-01-2024"}, body.Axis)
+	require.Len(t, body.Series, 2)
+	assert.Equal(t, "helm/helm", body.Series[0].Repo)
+	assert.Equal(t, []any{1.0, 2.0, 3.0, nil}, values(body.Series[0].Daily))
+	assert.Equal(t, []any{1.0, 3.0, 6.0, nil}, values(body.Series[0].Total))
+	assert.Equal(t, []any{nil, 5.0, 0.0, 7.0}, values(body.Series[1].Daily))
+	assert.Equal(t, []any{nil, 5.0, 5.0, 12.0}, values(body.Series[1].Total))
+
+	require.Len(t, body.Summaries, 2)
+	assert.Equal(t, 12.0, body.Summaries[1].Total)
+	assert.Equal(t, 7.0, body.Summaries[1].Peak)
+	assert.Equal(t, "04-01-2024", body.Summaries[1].PeakDay)
+	assert.Equal(t, 4.0, body.Summaries[1].MeanPerDay)
+	assert.Nil(t, body.Summaries[1].CreatedAt)
+
+	// helm/helm had no stars for two days after its creation
+	code, body = get("/compare?repos=helm/helm,cilium/cilium,argoproj/argo-cd&align=creation")
+	assert.Equal(t, 200, code)
+	assert.Empty(t, body.Pending)
+	assert.Equal(t, []any{0.0, 1.0, 2.0, 3.0, 4.0}, body.Axis)
+	require.Len(t, body.Series, 3)
+	assert.Equal(t, []any{0.0, 0.0, 1.0, 3.0, 6.0}, values(body.Series[0].Total))
+	assert.Equal(t, []any{5.0, 5.0, 12.0, nil, nil}, values(body.Series[1].Total))
+	assert.Equal(t, []any{4.0, nil, nil, nil, nil}, values(body.Series[2].Daily))
+	require.NotNil(t, body.Summaries[0].CreatedAt)
+	assert.True(t, day.AddDate(0, 0, -2).Equal(*body.Summaries[0].CreatedAt))
+
+	for _, url := range []string{
+		"/compare?repos=helm/helm",
+		"/compare?repos=helm/helm,helm",
+		"/compare?repos=helm/helm,cilium/cilium&metric=watchers",
+		"/compare?repos=helm/helm,cilium/cilium&align=name",
+		"/compare?repos=helm/helm,ghe.corp/team/repo",
+	} {
+		code, _ := get(url)
+		assert.Equal(t, 400, code, url)
+	}
+}
+
+func values(vs []*float64) []any {
+	res := make([]any, len(vs))
+	for i, v := range vs {
+		if v != nil {
+			res[i] = *v
+		}
+	}
+	return res
+}
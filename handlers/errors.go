@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/gherr"
+	"github.com/gofiber/fiber/v2"
+)
+
+// sendGitHubError answers with the JSON error body matching an error returned by GitHub through
+// the client clientKey, setting Retry-After when the caller should wait before retrying
+func sendGitHubError(c *fiber.Ctx, clientKey string, err error) error {
+	ghErr := *gherr.Classify(err)
+
+	if ghErr.Kind == gherr.KindRateLimited && ghErr.ResetAt.IsZero() {
+		if info, ok := globalClientSelector.GetClientStats()[clientKey]; ok {
+			ghErr.ResetAt = info.ResetAt
+		}
+	}
+
+	now := time.Now()
+	if retryAfter := ghErr.RetryAfterSeconds(now); retryAfter > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	}
+
+	log.Printf("GitHub error (%s) with client %s: %v", ghErr.Kind, clientKey, err)
+	return c.Status(ghErr.Status()).JSON(ghErr.Body(now))
+}
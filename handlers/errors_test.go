@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/gherr"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendGitHubError(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+	globalClientSelector.rateLimits["PAT"] = &ClientRateLimitInfo{
+		ResetAt:   time.Now().Add(10 * time.Minute),
+		UpdatedAt: time.Now(),
+	}
+
+	app := fiber.New()
+	app.Get("/rate", func(c *fiber.Ctx) error {
+		return sendGitHubError(c, "PAT", errors.New("API rate limit exceeded"))
+	})
+	app.Get("/missing", func(c *fiber.Ctx) error {
+		return sendGitHubError(c, "PAT", errors.New("Could not resolve to a Repository"))
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/rate", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 429, resp.StatusCode)
+	// The reset time of the rate limited client tells when to retry
+	retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	require.NoError(t, err)
+	assert.InDelta(t, 600, retryAfter, 2)
+
+	var body gherr.Body
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, gherr.KindRateLimited, body.Kind)
+	assert.NotNil(t, body.ResetAt)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/missing", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Retry-After"))
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/config"
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
+	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// metricSize says how to size the fetch of a metric: how many items it pages through, read with one
+// GraphQL call, and how many pages the library fetches at once
+type metricSize struct {
+	total   func(ctx context.Context, client *repostats.ClientGQL, path string) (int, error)
+	streams int
+}
+
+// metricSizes are the metrics an estimate can be made for: stars and forks. GetAllStats only counts the
+// open issues, not the closed ones the issues history pages through too, and doesn't count the PRs, so
+// their totals are only known after the very fetch the estimate is for.
+var metricSizes = map[string]metricSize{
+	session.MetricStars: {
+		total: func(ctx context.Context, client *repostats.ClientGQL, path string) (int, error) {
+			stars, _, err := client.GetTotalStars(ctx, path)
+			return stars, err
+		},
+		// GetAllStarsHistoryTwoWays pages from both ends
+		streams: 2,
+	},
+	session.MetricForks: {
+		total: func(ctx context.Context, client *repostats.ClientGQL, path string) (int, error) {
+			repoStats, err := client.GetAllStats(ctx, path)
+			if err != nil {
+				return 0, err
+			}
+			return repoStats.Forks, nil
+		},
+		// GetAllForksHistory pages through the forks one page at a time
+		streams: 1,
+	},
+}
+
+// fetchEstimate is what fetching a metric of a repo would take
+type fetchEstimate struct {
+	Repo   string `json:"repo"`
+	Metric string `json:"metric"`
+	// Total is the number of items to page through, e.g. the stars of the repo
+	Total int `json:"total"`
+	// Cached is true when the metric is already cached, the endpoint then answers without fetching
+	Cached bool `json:"cached"`
+	// Requests counts the GraphQL calls of the fetch, one per page plus the one reading the total
+	Requests int `json:"requests"`
+	// Cost is the rate limit points the fetch spends
+	Cost            int     `json:"cost"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	// Client is the client the fetch would start with
+	Client string `json:"client"`
+	// RateLimit of Client, missing when it couldn't be read
+	RateLimit *estimateRateLimit `json:"rateLimit,omitempty"`
+	// CompletesBeforeReset is true when the remaining quota of Client covers the cost and the fetch ends
+	// before its rate limit window resets
+	CompletesBeforeReset bool `json:"completesBeforeReset"`
+}
+
+type estimateRateLimit struct {
+	Remaining int       `json:"remaining"`
+	Limit     int       `json:"limit"`
+	ResetAt   time.Time `json:"resetAt"`
+}
+
+// estimateFetch sizes the fetch of total items with size, for the client with rateLimit
+func estimateFetch(total int, size metricSize, rateLimit *estimateRateLimit, now time.Time) fetchEstimate {
+	pages := (total + config.GitHubPageSize - 1) / config.GitHubPageSize
+	rounds := (pages + size.streams - 1) / size.streams
+	duration := time.Duration(rounds) * config.GitHubPageDuration
+
+	estimate := fetchEstimate{
+		Total:           total,
+		Requests:        pages + 1,
+		Cost:            pages + 1,
+		DurationSeconds: duration.Seconds(),
+		RateLimit:       rateLimit,
+	}
+	if rateLimit != nil {
+		estimate.CompletesBeforeReset = estimate.Cost <= rateLimit.Remaining && now.Add(duration).Before(rateLimit.ResetAt)
+	}
+	return estimate
+}
+
+// hourlyPages is how many pages a fetch sized by size gets through in an hour
+func hourlyPages(size metricSize) int {
+	return int(time.Hour/config.GitHubPageDuration) * size.streams
+}
+
+// currentRateLimit returns the rate limit of the client of calls, as cached by the ClientSelector or read
+// from GitHub when missing or outdated. It is nil when it can't be read.
+func currentRateLimit(ctx context.Context, calls *githubCalls) *estimateRateLimit {
+	if !calls.ephemeral {
+		globalClientSelector.refreshOutdated(ctx, map[string]*repostats.ClientGQL{calls.Key(): calls.client})
+		if info, ok := globalClientSelector.GetClientStats()[calls.Key()]; ok {
+			return &estimateRateLimit{Remaining: info.Remaining, Limit: info.Limit, ResetAt: info.ResetAt}
+		}
+		return nil
+	}
+
+	result, err := calls.client.GetCurrentLimits(ctx)
+	if err != nil || result == nil {
+		log.Printf("Error getting rate limits for client %s: %v", calls.Key(), err)
+		return nil
+	}
+	return &estimateRateLimit{Remaining: result.Remaining, Limit: result.Limit, ResetAt: result.ResetAt}
+}
+
+// EstimateHandler handles the /estimate endpoint predicting the API calls, quota and time a fetch of
+// ?metric= for ?repo= would take, and the client it would use. Only stars, the default, and forks are
+// supported, see metricSizes: any other metric is answered with a 400.
+func EstimateHandler(
+	ctx context.Context,
+	clientPool *tokens.Pool,
+	fetchers map[string]MetricFetcher,
+) fiber.Handler {
+	return withGitHubClient(ctx, clientPool, func(c *fiber.Ctx, calls *githubCalls) error {
+		repo, err := url.QueryUnescape(c.Query("repo"))
+		if err != nil {
+			return err
+		}
+		repo = strings.Clone(repoid.Normalize(repo))
+		if !strings.Contains(repoid.Path(repo), "/") {
+			return c.Status(400).JSON(fiber.Map{"error": "repo must be in the form owner/name or host/owner/name"})
+		}
+
+		metric := strings.ToLower(c.Query("metric", session.MetricStars))
+		size, ok := metricSizes[metric]
+		if !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "Estimates are only available for stars and forks, not for metric: " + metric})
+		}
+
+		span := trace.SpanFromContext(c.UserContext())
+		span.SetAttributes(attribute.String("github.repo", repo), attribute.String("github.metric", metric))
+		callCtx := trace.ContextWithSpan(ctx, span)
+
+		total, err := callGitHub(callCtx, calls, func(ctx context.Context, client *repostats.ClientGQL) (int, error) {
+			return size.total(ctx, client, repoid.Path(repo))
+		})
+		if err != nil {
+			return sendGitHubError(c, calls.Key(), err)
+		}
+
+		estimate := estimateFetch(total, size, currentRateLimit(callCtx, calls), time.Now())
+		estimate.Repo, estimate.Metric, estimate.Client = repo, metric, calls.Key()
+		if fetcher, ok := fetchers[metric]; ok {
+			estimate.Cached = fetcher.Cached(repo)
+		}
+
+		return c.JSON(estimate)
+	})
+}
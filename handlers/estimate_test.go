@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateFetch(t *testing.T) {
+	now := time.Now()
+	size := metricSize{streams: 2}
+
+	// 300k stars: 3000 pages fetched two at a time, plus the call reading the total
+	estimate := estimateFetch(300_000, size, &estimateRateLimit{Remaining: 5000, Limit: 5000, ResetAt: now.Add(time.Hour)}, now)
+	assert.Equal(t, 3001, estimate.Requests)
+	assert.Equal(t, 3001, estimate.Cost)
+	assert.InDelta(t, 1500*0.35, estimate.DurationSeconds, 0.001)
+	assert.True(t, estimate.CompletesBeforeReset)
+
+	// Not enough quota left
+	estimate = estimateFetch(300_000, size, &estimateRateLimit{Remaining: 2000, Limit: 5000, ResetAt: now.Add(time.Hour)}, now)
+	assert.False(t, estimate.CompletesBeforeReset)
+
+	// The window resets before the fetch is done
+	estimate = estimateFetch(300_000, size, &estimateRateLimit{Remaining: 5000, Limit: 5000, ResetAt: now.Add(time.Minute)}, now)
+	assert.False(t, estimate.CompletesBeforeReset)
+
+	estimate = estimateFetch(150, size, nil, now)
+	assert.Equal(t, 3, estimate.Requests)
+	assert.False(t, estimate.CompletesBeforeReset)
+}
+
+func TestEstimateHandler(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+
+	saved, savedForks := metricSizes[session.MetricStars], metricSizes[session.MetricForks]
+	metricSizes[session.MetricStars] = metricSize{
+		total:   func(ctx context.Context, client *repostats.ClientGQL, path string) (int, error) { return 12_345, nil },
+		streams: 2,
+	}
+	metricSizes[session.MetricForks] = metricSize{
+		total:   func(ctx context.Context, client *repostats.ClientGQL, path string) (int, error) { return 250, nil },
+		streams: 1,
+	}
+	t.Cleanup(func() { metricSizes[session.MetricStars], metricSizes[session.MetricForks] = saved, savedForks })
+
+	pool, err := tokens.NewPool(
+		func() ([]tokens.Token, error) { return []tokens.Token{{Label: "PAT", Value: "a"}}, nil },
+		func(tokens.Token) *repostats.ClientGQL { return &repostats.ClientGQL{} },
+	)
+	require.NoError(t, err)
+	globalClientSelector.setRateLimit("PAT", &repostats.RateLimit{Limit: 5000, Remaining: 4000, ResetAt: time.Now().Add(time.Hour)})
+
+	app := fiber.New()
+	app.Get("/estimate", EstimateHandler(context.Background(), pool, nil))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/estimate?repo=Helm/Helm", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	var estimate fetchEstimate
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&estimate))
+	assert.Equal(t, "helm/helm", estimate.Repo)
+	assert.Equal(t, session.MetricStars, estimate.Metric)
+	assert.Equal(t, 12_345, estimate.Total)
+	assert.Equal(t, 125, estimate.Cost)
+	assert.Equal(t, "PAT", estimate.Client)
+	require.NotNil(t, estimate.RateLimit)
+	assert.Equal(t, 4000, estimate.RateLimit.Remaining)
+	assert.True(t, estimate.CompletesBeforeReset)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/estimate?repo=helm/helm&metric=forks", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&estimate))
+	assert.Equal(t, session.MetricForks, estimate.Metric)
+	assert.Equal(t, 250, estimate.Total)
+	assert.Equal(t, 4, estimate.Requests)
+	assert.InDelta(t, 3*0.35, estimate.DurationSeconds, 0.001)
+
+	// Only stars and forks can be estimated, even for the metrics that can be fetched
+	for _, metric := range []string{"watchers", "issues", "prs"} {
+		resp, err = app.Test(httptest.NewRequest("GET", "/estimate?repo=helm/helm&metric="+metric, nil))
+		require.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode, metric)
+	}
+}
@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/analytics"
+	"github.com/emanuelef/gh-repo-stats-server/cache"
+	"github.com/emanuelef/gh-repo-stats-server/config"
+	"github.com/emanuelef/gh-repo-stats-server/gherr"
+	"github.com/emanuelef/gh-repo-stats-server/inflight"
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
+	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/types"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"github.com/emanuelef/github-repo-activity-stats/stats"
+	"golang.org/x/sync/errgroup"
+)
+
+// The fetchers below run the long GitHub history downloads shared by the HTTP
+// handlers and the background jobs. Each one makes its GitHub calls through calls,
+// reports its progress to the SSE sessions and stores its result in the given cache.
+
+// runWithProgress runs fetch with the clients of calls, reporting every page count it sends on its update channel
+// and whether it completed or failed. A failed attempt retried by calls starts over with a new update channel.
+// Errors are returned as *gherr.Error.
+func runWithProgress[T any](
+	ctx context.Context,
+	calls *githubCalls,
+	fetch func(ctx context.Context, client *repostats.ClientGQL, updateChannel chan int) (T, error),
+) (T, error) {
+	progress := calls.progress
+	progress.Started()
+
+	result, err := callGitHub(ctx, calls, func(ctx context.Context, client *repostats.ClientGQL) (T, error) {
+		updateChannel := make(chan int)
+		var result T
+
+		eg, localCtx := errgroup.WithContext(ctx)
+
+		eg.Go(func() error {
+			var err error
+			result, err = fetch(localCtx, client, updateChannel)
+			return err
+		})
+
+		for pagesDone := range updateChannel {
+			progress.Progress(pagesDone)
+		}
+
+		return result, eg.Wait()
+	})
+	if err != nil {
+		ghErr := gherr.Classify(err)
+		progress.Finished(ghErr)
+		var zero T
+		return zero, ghErr
+	}
+
+	progress.Finished(nil)
+	return result, nil
+}
+
+// cacheExpiration returns when freshly fetched GitHub data should expire
+func cacheExpiration() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour).Add(config.DayCached * 24 * time.Hour)
+}
+
+func fetchAllStars(
+	ctx context.Context,
+	calls *githubCalls,
+	repo string,
+	cacheStars *cache.Cache[types.StarsWithStatsResponse],
+) (types.StarsWithStatsResponse, error) {
+	if calls.progress != nil {
+		totalStars, err := callGitHub(ctx, calls, func(ctx context.Context, client *repostats.ClientGQL) (int, error) {
+			totalStars, _, err := client.GetTotalStars(ctx, repoid.Path(repo))
+			return totalStars, err
+		})
+		if err == nil {
+			calls.progress.SetTotal(totalStars)
+		} else {
+			log.Printf("Error getting total stars for %s: %v", repo, err)
+		}
+	}
+
+	allStars, err := runWithProgress(ctx, calls, func(ctx context.Context, client *repostats.ClientGQL, updateChannel chan int) ([]stats.StarsPerDay, error) {
+		return client.GetAllStarsHistoryTwoWays(ctx, repoid.Path(repo), updateChannel)
+	})
+	if err != nil {
+		return types.StarsWithStatsResponse{}, err
+	}
+
+	// Remove incomplete (today's) day before creating response and caching in /allStars ---
+	if len(allStars) > 0 {
+		todayStr := time.Now().Format("02-01-2006")
+		lastDayStr := time.Time(allStars[len(allStars)-1].Day).Format("02-01-2006")
+		if lastDayStr == todayStr {
+			allStars = allStars[:len(allStars)-1] // remove incomplete day
+		}
+	}
+
+	res, err := newStarsResponse(allStars)
+	if err != nil {
+		return types.StarsWithStatsResponse{}, err
+	}
+
+	cacheStars.Set(repo, res, cacheExpiration())
+
+	return res, nil
+}
+
+// refreshStars brings an expired stars history up to date by fetching only the days
+// since its last cached day and merging them in, falling back to a full fetch when
+// there is nothing to merge into
+func refreshStars(
+	ctx context.Context,
+	calls *githubCalls,
+	repo string,
+	cached types.StarsWithStatsResponse,
+	cacheStars *cache.Cache[types.StarsWithStatsResponse],
+) (types.StarsWithStatsResponse, error) {
+	if len(cached.Stars) == 0 {
+		return fetchAllStars(ctx, calls, repo, cacheStars)
+	}
+
+	// Refetch the last cached day too, it may have been cached before the day was over
+	lastDay := time.Time(cached.Stars[len(cached.Stars)-1].Day)
+	days := int(time.Since(lastDay).Hours()/24) + 2
+
+	recentStars, err := callGitHub(ctx, calls, func(ctx context.Context, client *repostats.ClientGQL) ([]stats.StarsPerDay, error) {
+		return client.GetRecentStarsHistoryTwoWays(ctx, repoid.Path(repo), days, nil)
+	})
+	if err != nil {
+		return types.StarsWithStatsResponse{}, err
+	}
+
+	mergedStars, _ := mergeStars(cached.Stars, recentStars)
+
+	res, err := newStarsResponse(mergedStars)
+	if err != nil {
+		return types.StarsWithStatsResponse{}, err
+	}
+
+	cacheStars.Set(repo, res, cacheExpiration())
+
+	return res, nil
+}
+
+func fetchAllIssues(
+	ctx context.Context,
+	calls *githubCalls,
+	repo string,
+	cacheIssues *cache.Cache[types.IssuesWithStatsResponse],
+) (types.IssuesWithStatsResponse, error) {
+	allIssues, err := runWithProgress(ctx, calls, func(ctx context.Context, client *repostats.ClientGQL, updateChannel chan int) ([]stats.IssuesPerDay, error) {
+		return client.GetAllIssuesHistory(ctx, repoid.Path(repo), updateChannel)
+	})
+	if err != nil {
+		return types.IssuesWithStatsResponse{}, err
+	}
+
+	res := types.IssuesWithStatsResponse{
+		Issues: allIssues,
+	}
+
+	cacheIssues.Set(repo, res, cacheExpiration())
+
+	return res, nil
+}
+
+func fetchAllForks(
+	ctx context.Context,
+	calls *githubCalls,
+	repo string,
+	cacheForks *cache.Cache[types.ForksWithStatsResponse],
+) (types.ForksWithStatsResponse, error) {
+	allForks, err := runWithProgress(ctx, calls, func(ctx context.Context, client *repostats.ClientGQL, updateChannel chan int) ([]stats.ForksPerDay, error) {
+		return client.GetAllForksHistory(ctx, repoid.Path(repo), updateChannel)
+	})
+	if err != nil {
+		return types.ForksWithStatsResponse{}, err
+	}
+
+	res := types.ForksWithStatsResponse{
+		Forks: allForks,
+	}
+
+	cacheForks.Set(repo, res, cacheExpiration())
+
+	return res, nil
+}
+
+func fetchAllPRs(
+	ctx context.Context,
+	calls *githubCalls,
+	repo string,
+	cachePRs *cache.Cache[types.PRsWithStatsResponse],
+) (types.PRsWithStatsResponse, error) {
+	allPRs, err := runWithProgress(ctx, calls, func(ctx context.Context, client *repostats.ClientGQL, updateChannel chan int) ([]stats.PRsPerDay, error) {
+		return client.GetAllPRsHistory(ctx, repoid.Path(repo), updateChannel)
+	})
+	if err != nil {
+		return types.PRsWithStatsResponse{}, err
+	}
+
+	res := types.PRsWithStatsResponse{
+		PRs: allPRs,
+	}
+
+	cachePRs.Set(repo, res, cacheExpiration())
+
+	return res, nil
+}
+
+func fetchAllCommits(
+	ctx context.Context,
+	calls *githubCalls,
+	repo string,
+	cacheCommits *cache.Cache[types.CommitsWithStatsResponse],
+) (types.CommitsWithStatsResponse, error) {
+	res, err := runWithProgress(ctx, calls, func(ctx context.Context, client *repostats.ClientGQL, updateChannel chan int) (types.CommitsWithStatsResponse, error) {
+		allCommits, defaultBranch, err := client.GetAllCommitsHistory(ctx, repoid.Path(repo), updateChannel)
+		return types.CommitsWithStatsResponse{
+			Commits:       allCommits,
+			DefaultBranch: defaultBranch,
+		}, err
+	})
+	if err != nil {
+		return types.CommitsWithStatsResponse{}, err
+	}
+
+	cacheCommits.Set(repo, res, cacheExpiration())
+
+	return res, nil
+}
+
+func fetchAllContributors(
+	ctx context.Context,
+	calls *githubCalls,
+	repo string,
+	cacheContributors *cache.Cache[types.ContributorsWithStatsResponse],
+) (types.ContributorsWithStatsResponse, error) {
+	allContributors, err := runWithProgress(ctx, calls, func(ctx context.Context, client *repostats.ClientGQL, updateChannel chan int) ([]stats.NewContributorsPerDay, error) {
+		return client.GetNewContributorsHistory(ctx, repoid.Path(repo), updateChannel)
+	})
+	if err != nil {
+		return types.ContributorsWithStatsResponse{}, err
+	}
+
+	res := types.ContributorsWithStatsResponse{
+		Contributors: allContributors,
+	}
+
+	cacheContributors.Set(repo, res, cacheExpiration())
+
+	return res, nil
+}
+
+// MetricFetcher downloads one metric for a repo into its cache
+type MetricFetcher struct {
+	cached func(repo string) bool
+	daily  func(repo string) ([]analytics.Point, bool, error)
+	totals func(repo string) ([]analytics.Point, bool, error)
+	fetch  func(ctx context.Context, clients map[string]*repostats.ClientGQL, clientKey string, client *repostats.ClientGQL, repo string, onEvent func(session.Event)) error
+}
+
+// Cached reports whether the metric for repo is cached and not expired
+func (f MetricFetcher) Cached(repo string) bool {
+	return f.cached(repo)
+}
+
+// Daily returns the daily counts of the metric for repo from the cache, e.g. the new stars of each day,
+// and false when it is not cached
+func (f MetricFetcher) Daily(repo string) ([]analytics.Point, bool, error) {
+	return f.daily(repo)
+}
+
+// Totals returns the running totals of the metric for repo from the cache, e.g. the total stars at the end
+// of each day, and false when it is not cached
+func (f MetricFetcher) Totals(repo string) ([]analytics.Point, bool, error) {
+	return f.totals(repo)
+}
+
+// Fetch downloads the metric for repo unless it is already cached, reporting progress to the SSE sessions
+// and to onEvent when it is not nil. The fetch starts with client and may fail over to the other clients.
+// A fetch already running for the same repo is joined rather than started again, and is only stopped when
+// ctx is done once no other caller waits on it. onEvent follows the fetch actually running either way.
+func (f MetricFetcher) Fetch(ctx context.Context, clients map[string]*repostats.ClientGQL, clientKey string, client *repostats.ClientGQL, repo string, onEvent func(session.Event)) error {
+	return f.fetch(ctx, clients, clientKey, client, repo, onEvent)
+}
+
+func newMetricFetcher[T any](
+	metric string,
+	progressHub *session.Hub,
+	cacheX *cache.Cache[T],
+	onGoingX *inflight.Group[T],
+	fetch func(ctx context.Context, calls *githubCalls, repo string, cacheX *cache.Cache[T]) (T, error),
+	days func(res T) any,
+) MetricFetcher {
+	return newRefreshingFetcher(metric, progressHub, cacheX, onGoingX, fetch, nil, days)
+}
+
+// newRefreshingFetcher is newMetricFetcher for a metric whose expired history, still kept for stale serving,
+// is brought up to date with refresh rather than downloaded again with fetch
+func newRefreshingFetcher[T any](
+	metric string,
+	progressHub *session.Hub,
+	cacheX *cache.Cache[T],
+	onGoingX *inflight.Group[T],
+	fetch func(ctx context.Context, calls *githubCalls, repo string, cacheX *cache.Cache[T]) (T, error),
+	refresh func(ctx context.Context, calls *githubCalls, repo string, stale T, cacheX *cache.Cache[T]) (T, error),
+	days func(res T) any,
+) MetricFetcher {
+	cached := func(repo string) bool {
+		_, hit := cacheX.Get(repo)
+		return hit
+	}
+
+	return MetricFetcher{
+		cached: cached,
+		daily: func(repo string) ([]analytics.Point, bool, error) {
+			res, hit := cacheX.Get(repo)
+			if !hit {
+				return nil, false, nil
+			}
+			points, err := dailyPoints(days(res))
+			return points, true, err
+		},
+		totals: func(repo string) ([]analytics.Point, bool, error) {
+			res, hit := cacheX.Get(repo)
+			if !hit {
+				return nil, false, nil
+			}
+			points, err := dailyTotals(days(res))
+			return points, true, err
+		},
+		fetch: func(ctx context.Context, clients map[string]*repostats.ClientGQL, clientKey string, client *repostats.ClientGQL, repo string, onEvent func(session.Event)) error {
+			if cached(repo) {
+				return nil
+			}
+
+			if onEvent != nil {
+				stop := followFetch(progressHub, session.Topic(metric, repo), onGoingX.InFlight(repo), onEvent)
+				defer stop()
+			}
+
+			_, err, _ := onGoingX.DoContext(ctx, repo, func(ctx context.Context) (T, error) {
+				progress := newProgressReporter(progressHub, metric, repo, clientKey)
+
+				calls := newGitHubCalls(clients, clientKey, client, progress)
+				calls.markBusy(repo)
+				defer calls.release()
+
+				if res, stale, found := cacheX.GetStale(repo); found && stale && refresh != nil {
+					return refresh(ctx, calls, repo, res, cacheX)
+				}
+				return fetch(ctx, calls, repo, cacheX)
+			})
+			return err
+		},
+	}
+}
+
+// followFetch calls onEvent with the events of the fetch published on topic, until stop is called once the fetch
+// is over. When joining a fetch already running, its last event is replayed first so that its progress and client
+// are known before the next page.
+func followFetch(progressHub *session.Hub, topic string, joining bool, onEvent func(session.Event)) (stop func()) {
+	sub := progressHub.Subscribe(topic)
+
+	var lastID uint64
+	if ev, ok := progressHub.Last(topic); joining && ok && ev.Type != session.EventCompleted && ev.Type != session.EventFailed {
+		lastID = ev.ID
+		onEvent(ev)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ev := range sub.Events() {
+			// Already replayed
+			if ev.ID <= lastID {
+				continue
+			}
+			onEvent(ev)
+		}
+	}()
+
+	return func() {
+		progressHub.Unsubscribe(sub)
+		<-done
+	}
+}
+
+// StarsFetcher fetches the full stars history into the cache used by the /allStars endpoint, or only the days
+// missing from an expired one
+func StarsFetcher(progressHub *session.Hub, c *cache.Cache[types.StarsWithStatsResponse], g *inflight.Group[types.StarsWithStatsResponse]) MetricFetcher {
+	return newRefreshingFetcher(session.MetricStars, progressHub, c, g, fetchAllStars, refreshStars, func(res types.StarsWithStatsResponse) any {
+		return res.Stars
+	})
+}
+
+// IssuesFetcher fetches the full issues history into the cache used by the /allIssues endpoint
+func IssuesFetcher(progressHub *session.Hub, c *cache.Cache[types.IssuesWithStatsResponse], g *inflight.Group[types.IssuesWithStatsResponse]) MetricFetcher {
+	return newMetricFetcher(session.MetricIssues, progressHub, c, g, fetchAllIssues, func(res types.IssuesWithStatsResponse) any {
+		return res.Issues
+	})
+}
+
+// ForksFetcher fetches the full forks history into the cache used by the /allForks endpoint
+func ForksFetcher(progressHub *session.Hub, c *cache.Cache[types.ForksWithStatsResponse], g *inflight.Group[types.ForksWithStatsResponse]) MetricFetcher {
+	return newMetricFetcher(session.MetricForks, progressHub, c, g, fetchAllForks, func(res types.ForksWithStatsResponse) any {
+		return res.Forks
+	})
+}
+
+// PRsFetcher fetches the full pull requests history into the cache used by the /allPRs endpoint
+func PRsFetcher(progressHub *session.Hub, c *cache.Cache[types.PRsWithStatsResponse], g *inflight.Group[types.PRsWithStatsResponse]) MetricFetcher {
+	return newMetricFetcher(session.MetricPRs, progressHub, c, g, fetchAllPRs, func(res types.PRsWithStatsResponse) any {
+		return res.PRs
+	})
+}
+
+// CommitsFetcher fetches the full commits history into the cache used by the /allCommits endpoint
+func CommitsFetcher(progressHub *session.Hub, c *cache.Cache[types.CommitsWithStatsResponse], g *inflight.Group[types.CommitsWithStatsResponse]) MetricFetcher {
+	return newMetricFetcher(session.MetricCommits, progressHub, c, g, fetchAllCommits, func(res types.CommitsWithStatsResponse) any {
+		return res.Commits
+	})
+}
+
+// ContributorsFetcher fetches the full new contributors history into the cache used by the /allContributors endpoint
+func ContributorsFetcher(progressHub *session.Hub, c *cache.Cache[types.ContributorsWithStatsResponse], g *inflight.Group[types.ContributorsWithStatsResponse]) MetricFetcher {
+	return newMetricFetcher(session.MetricContributors, progressHub, c, g, fetchAllContributors, func(res types.ContributorsWithStatsResponse) any {
+		return res.Contributors
+	})
+}
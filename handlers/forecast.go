@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/emanuelef/gh-repo-stats-server/analytics"
+	"github.com/emanuelef/gh-repo-stats-server/config"
+	"github.com/emanuelef/gh-repo-stats-server/jobs"
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
+	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// forecastResponse is the prediction of the daily stars of a repo, see analytics.ForecastDaily
+type forecastResponse struct {
+	Repo   string                    `json:"repo"`
+	Method string                    `json:"method"`
+	Params analytics.SmoothingParams `json:"params"`
+	// Sigma is the standard deviation of the one-step-ahead errors of the model over the history
+	Sigma float64 `json:"sigma"`
+	// HistoryDays is how many days of history the model was fitted on, up to LastDay
+	HistoryDays int           `json:"historyDays"`
+	LastDay     string        `json:"lastDay"`
+	TotalStars  int           `json:"totalStars"`
+	Forecast    []forecastDay `json:"forecast"`
+}
+
+// forecastDay is the prediction of one day with its 95% confidence band, and the total stars they lead to
+type forecastDay struct {
+	Day        string  `json:"day"`
+	Stars      float64 `json:"stars"`
+	Lower      float64 `json:"lower"`
+	Upper      float64 `json:"upper"`
+	TotalStars float64 `json:"totalStars"`
+	// TotalLower and TotalUpper add up the daily bounds, a band wider than the one of the total
+	TotalLower float64 `json:"totalLower"`
+	TotalUpper float64 `json:"totalUpper"`
+}
+
+// ForecastHandler handles the /forecast endpoint predicting the daily and total stars of ?repo= for the
+// next ?days= days (30 by default) from its cached stars history. A repo that is not cached gets a
+// background job filling its cache, like POST /jobs, and a 202 with the job.
+func ForecastHandler(
+	ctx context.Context,
+	clientPool *tokens.Pool,
+	manager *jobs.Manager,
+	fetchers map[string]MetricFetcher,
+) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		repo, err := url.QueryUnescape(c.Query("repo"))
+		if err != nil {
+			return err
+		}
+		repo = strings.Clone(repoid.Normalize(repo))
+		if !strings.Contains(repoid.Path(repo), "/") {
+			return c.Status(400).JSON(fiber.Map{"error": "repo must be in the form owner/name or host/owner/name"})
+		}
+		if _, ok := clientPool.BaseURL(repoid.Host(repo)); !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "No GitHub client for host " + repoid.Host(repo)})
+		}
+
+		days, err := strconv.Atoi(c.Query("days", "30"))
+		if err != nil || days < 1 || days > config.ForecastMaxDays {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("days must be between 1 and %d", config.ForecastMaxDays)})
+		}
+
+		span := trace.SpanFromContext(c.UserContext())
+		span.SetAttributes(attribute.String("github.repo", repo), attribute.Int("forecast.days", days))
+
+		fetcher := fetchers[session.MetricStars]
+		points, hit, err := fetcher.Daily(repo)
+		if err != nil {
+			log.Printf("Error reading cached stars of %s: %v", repo, err)
+			return c.Status(500).JSON(fiber.Map{"error": "Could not read cached stars of " + repo})
+		}
+		if !hit {
+			job, err := startFetchJob(ctx, clientPool, manager, fetcher, repo, session.MetricStars, "")
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "Could not create job"})
+			}
+			return c.Status(202).JSON(job.Status())
+		}
+
+		summary := analytics.Summarize(points)
+		history := points
+		if len(history) > config.ForecastHistoryDays {
+			history = history[len(history)-config.ForecastHistoryDays:]
+		}
+
+		forecast, err := analytics.ForecastDaily(history, days)
+		if errors.Is(err, analytics.ErrShortSeries) {
+			return c.Status(422).JSON(fiber.Map{"error": "Not enough stars history to forecast " + repo})
+		}
+		if err != nil {
+			return err
+		}
+
+		resp := forecastResponse{
+			Repo:        repo,
+			Method:      forecast.Method,
+			Params:      forecast.Params,
+			Sigma:       forecast.Sigma,
+			HistoryDays: len(history),
+			LastDay:     summary.Last.Format("02-01-2006"),
+			TotalStars:  int(summary.Total),
+			Forecast:    []forecastDay{},
+		}
+		total, lower, upper := summary.Total, summary.Total, summary.Total
+		for _, p := range forecast.Points {
+			total += p.Value
+			lower += p.Lower
+			upper += p.Upper
+			resp.Forecast = append(resp.Forecast, forecastDay{
+				Day:        p.Day.Format("02-01-2006"),
+				Stars:      p.Value,
+				Lower:      p.Lower,
+				Upper:      p.Upper,
+				TotalStars: total,
+				TotalLower: lower,
+				TotalUpper: upper,
+			})
+		}
+
+		return c.JSON(resp)
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/analytics"
+	"github.com/emanuelef/gh-repo-stats-server/cache"
+	"github.com/emanuelef/gh-repo-stats-server/inflight"
+	"github.com/emanuelef/gh-repo-stats-server/jobs"
+	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/emanuelef/gh-repo-stats-server/types"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForecastHandler(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+	globalClientSelector.setRateLimit("PAT", &repostats.RateLimit{Limit: 5000, Remaining: 5000, ResetAt: time.Now().Add(time.Hour)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	daily := make([]int, 60)
+	for i := range daily {
+		daily[i] = 5
+	}
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cacheStars := cache.NewCache[types.StarsWithStatsResponse]()
+	cacheStars.Set("helm/helm", starsHistory(day, daily...), time.Now().Add(time.Hour))
+	cacheStars.Set("new/repo", starsHistory(day, 3, 4), time.Now().Add(time.Hour))
+
+	var onGoing inflight.Group[types.StarsWithStatsResponse]
+	fetcher := newMetricFetcher(session.MetricStars, session.NewHub(0), cacheStars, &onGoing,
+		func(ctx context.Context, calls *githubCalls, repo string, c *cache.Cache[types.StarsWithStatsResponse]) (types.StarsWithStatsResponse, error) {
+			res := starsHistory(day, daily...)
+			c.Set(repo, res, time.Now().Add(time.Hour))
+			return res, nil
+		},
+		func(res types.StarsWithStatsResponse) any { return res.Stars })
+
+	pool, err := tokens.NewPool(
+		func() ([]tokens.Token, error) { return []tokens.Token{{Label: "PAT", Value: "a"}}, nil },
+		func(tokens.Token) *repostats.ClientGQL { return &repostats.ClientGQL{} },
+	)
+	require.NoError(t, err)
+
+	manager := jobs.NewManager(ctx)
+	app := fiber.New()
+	app.Get("/forecast", ForecastHandler(ctx, pool, manager, map[string]MetricFetcher{session.MetricStars: fetcher}))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/forecast?repo=Helm/Helm&days=10", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	var body forecastResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "helm/helm", body.Repo)
+	assert.Equal(t, analytics.MethodHoltWinters, body.Method)
+	assert.Equal(t, 60, body.HistoryDays)
+	assert.Equal(t, "29-02-2024", body.LastDay)
+	assert.Equal(t, 300, body.TotalStars)
+	require.Len(t, body.Forecast, 10)
+	assert.Equal(t, "01-03-2024", body.Forecast[0].Day)
+	for _, d := range body.Forecast {
+		assert.InDelta(t, 5, d.Stars, 0.01)
+		assert.LessOrEqual(t, d.Lower, d.Stars)
+		assert.GreaterOrEqual(t, d.Upper, d.Stars)
+	}
+	assert.InDelta(t, 350, body.Forecast[9].TotalStars, 0.1)
+
+	// Not cached: a job fills the cache
+	resp, err = app.Test(httptest.NewRequest("GET", "/forecast?repo=argoproj/argo-cd", nil))
+	require.NoError(t, err)
+	require.Equal(t, 202, resp.StatusCode)
+	var status jobs.Status
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	job, ok := manager.Get(status.ID)
+	require.True(t, ok)
+	require.Eventually(t, job.Done, time.Second, time.Millisecond)
+	_, hit := cacheStars.Get("argoproj/argo-cd")
+	assert.True(t, hit)
+
+	for url, code := range map[string]int{
+		"/forecast?repo=new/repo":           422,
+		"/forecast?repo=helm/helm&days=0":   400,
+		"/forecast?repo=helm/helm&days=400": 400,
+		"/forecast?repo=helm":               400,
+	} {
+		resp, err := app.Test(httptest.NewRequest("GET", url, nil))
+		require.NoError(t, err)
+		assert.Equal(t, code, resp.StatusCode, url)
+	}
+}
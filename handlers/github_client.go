@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/emanuelef/gh-repo-stats-server/utils"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"github.com/gofiber/fiber/v2"
+)
+
+// userClientKey names the client built from the token of a request, in the logs and SSE events.
+// The token itself is never logged, traced or kept past the fetches of the request.
+const userClientKey = "user"
+
+// newUserClient builds the client of a token sent with a request, for the API at baseURL. It observes
+// no rate limits: the quota is the user's, not one of the pool.
+var newUserClient = func(baseURL, token string) *repostats.ClientGQL {
+	return utils.NewClientWithPAT(baseURL, token, nil)
+}
+
+// userToken returns the GitHub token sent in the Authorization header ("Bearer <token>" or
+// "token <token>"), empty when there's none. ok is false when the header is malformed.
+func userToken(c *fiber.Ctx) (token string, ok bool) {
+	header := c.Get(fiber.HeaderAuthorization)
+	if header == "" {
+		return "", true
+	}
+	scheme, token, found := strings.Cut(strings.TrimSpace(header), " ")
+	if !found || (!strings.EqualFold(scheme, "Bearer") && !strings.EqualFold(scheme, "token")) {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", false
+	}
+	// The header buffer is reused by fasthttp once the request is done
+	return strings.Clone(token), true
+}
+
+// withGitHubClient wraps the handler of an endpoint backed by GitHub. It resolves the client of the request
+// with SelectBestClient, or the one named by ?client=, and hands it to handle as the githubCalls to make the
+// GitHub calls with. Only the clients of the host of ?repo= are candidates, github.com without one.
+// The clients the handler marks busy are released once it returns, whatever the outcome.
+// A request carrying its own token in the Authorization header gets an ephemeral client built from it instead
+// of one of the pool.
+func withGitHubClient(
+	ctx context.Context,
+	clientPool *tokens.Pool,
+	handle func(c *fiber.Ctx, calls *githubCalls) error,
+) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token, ok := userToken(c)
+		if !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "Malformed Authorization header, expected Bearer <token>"})
+		}
+
+		repo, _ := url.QueryUnescape(c.Query("repo"))
+		host := repoid.Host(repoid.Normalize(repo))
+		// Only configured hosts are called, never one picked by the caller
+		baseURL, ok := clientPool.BaseURL(host)
+		if !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "No GitHub client for host " + host})
+		}
+
+		overrideClient := strings.Clone(c.Query("client"))
+		if token != "" {
+			if overrideClient != "" {
+				return c.Status(400).JSON(fiber.Map{"error": "client can't be used with an Authorization header"})
+			}
+			calls := newUserCalls(newUserClient(baseURL, token))
+			defer calls.release()
+			return handle(c, calls)
+		}
+
+		ghStatClients := clientPool.ClientsFor(host)
+
+		if _, ok := ghStatClients[overrideClient]; overrideClient != "" && !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "Unknown client: " + overrideClient})
+		}
+
+		clientKey, client := SelectBestClient(ctx, ghStatClients, overrideClient)
+		if client == nil {
+			return c.Status(500).SendString("No GitHub API client available")
+		}
+
+		calls := newGitHubCalls(failoverClients(ghStatClients, overrideClient), clientKey, client, nil)
+		defer calls.release()
+
+		return handle(c, calls)
+	}
+}
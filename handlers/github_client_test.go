@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithGitHubClient(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+
+	pool, err := tokens.NewPool(
+		func() ([]tokens.Token, error) {
+			return []tokens.Token{{Label: "PAT", Value: "a"}, {Label: "PAT2", Value: "b"}}, nil
+		},
+		func(tokens.Token) *repostats.ClientGQL { return &repostats.ClientGQL{} },
+	)
+	require.NoError(t, err)
+
+	var busyDuringCall map[string]string
+	app := fiber.New()
+	app.Get("/fetch", withGitHubClient(context.Background(), pool, func(c *fiber.Ctx, calls *githubCalls) error {
+		calls.markBusy("helm/helm")
+		busyDuringCall = GetBusyClients()
+		if c.Query("fail") == "true" {
+			return errors.New("boom")
+		}
+		return c.SendString(calls.Key())
+	}))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/fetch?client=PAT2", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, map[string]string{"PAT2": "helm/helm"}, busyDuringCall)
+	assert.Empty(t, GetBusyClients())
+
+	// The client is released on errors too
+	resp, err = app.Test(httptest.NewRequest("GET", "/fetch?client=PAT&fail=true", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 500, resp.StatusCode)
+	assert.Contains(t, busyDuringCall, "PAT")
+	assert.Empty(t, GetBusyClients())
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/fetch?client=PAT9", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestMarkClientBusyNested(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+
+	// Two fetches sharing a client, the first one ending must not make it idle
+	MarkClientBusy("PAT", "helm/helm")
+	MarkClientBusy("PAT", "kubernetes/kubernetes")
+	MarkClientIdle("PAT")
+	assert.Contains(t, GetBusyClients(), "PAT")
+	MarkClientIdle("PAT")
+	assert.Empty(t, GetBusyClients())
+}
+
+func TestWithGitHubClientUserToken(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+
+	var tokensSeen []string
+	saved := newUserClient
+	newUserClient = func(baseURL, token string) *repostats.ClientGQL {
+		tokensSeen = append(tokensSeen, token)
+		return &repostats.ClientGQL{}
+	}
+	t.Cleanup(func() { newUserClient = saved })
+
+	// The pool is empty, the user's token is enough
+	pool, err := tokens.NewPool(
+		func() ([]tokens.Token, error) { return nil, nil },
+		func(tokens.Token) *repostats.ClientGQL { return &repostats.ClientGQL{} },
+	)
+	require.NoError(t, err)
+
+	var busyDuringCall map[string]string
+	app := fiber.New()
+	app.Get("/fetch", withGitHubClient(context.Background(), pool, func(c *fiber.Ctx, calls *githubCalls) error {
+		calls.markBusy("helm/helm")
+		busyDuringCall = GetBusyClients()
+		_, err := callGitHub(c.UserContext(), calls, func(ctx context.Context, client *repostats.ClientGQL) (int, error) {
+			return 0, errors.New("non-200 OK status code: 401 Unauthorized")
+		})
+		return sendGitHubError(c, calls.Key(), err)
+	}))
+
+	req := httptest.NewRequest("GET", "/fetch", nil)
+	req.Header.Set("Authorization", "Bearer ghp_secret")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 401, resp.StatusCode)
+	assert.Equal(t, []string{"ghp_secret"}, tokensSeen)
+
+	// The ephemeral client stays out of the pool bookkeeping
+	assert.Empty(t, busyDuringCall)
+	assert.Empty(t, globalClientSelector.GetClientHealth())
+
+	req = httptest.NewRequest("GET", "/fetch", nil)
+	req.Header.Set("Authorization", "token ghp_secret")
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 401, resp.StatusCode)
+	assert.Len(t, tokensSeen, 2)
+
+	for _, header := range []string{"Bearer", "Basic dXNlcjpwYXNz", "ghp_secret"} {
+		req = httptest.NewRequest("GET", "/fetch", nil)
+		req.Header.Set("Authorization", header)
+		resp, err = app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode, header)
+	}
+
+	req = httptest.NewRequest("GET", "/fetch?client=PAT", nil)
+	req.Header.Set("Authorization", "Bearer ghp_secret")
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+	assert.Len(t, tokensSeen, 2)
+}
+
+func TestWithGitHubClientEnterpriseHost(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+
+	var baseURLs []string
+	saved := newUserClient
+	newUserClient = func(baseURL, token string) *repostats.ClientGQL {
+		baseURLs = append(baseURLs, baseURL)
+		return &repostats.ClientGQL{}
+	}
+	t.Cleanup(func() { newUserClient = saved })
+
+	pool, err := tokens.NewPool(
+		func() ([]tokens.Token, error) {
+			return []tokens.Token{{Label: "PAT", Value: "a"}, {Label: "GHE_1", Value: "b", BaseURL: "https://ghe.corp/api/v3"}}, nil
+		},
+		func(tokens.Token) *repostats.ClientGQL { return &repostats.ClientGQL{} },
+	)
+	require.NoError(t, err)
+
+	app := fiber.New()
+	app.Get("/fetch", withGitHubClient(context.Background(), pool, func(c *fiber.Ctx, calls *githubCalls) error {
+		return c.SendString(calls.Key())
+	}))
+
+	get := func(target string, header string) (int, string) {
+		req := httptest.NewRequest("GET", target, nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	status, key := get("/fetch?repo=helm/helm", "")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "PAT", key)
+
+	status, key = get("/fetch?repo=GHE.corp/Team/Repo", "")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "GHE_1", key)
+
+	// A client of another host can't be asked for
+	status, _ = get("/fetch?repo=helm/helm&client=GHE_1", "")
+	assert.Equal(t, 400, status)
+
+	// Nor a host without clients, even with the user's token
+	status, _ = get("/fetch?repo=other.corp/team/repo", "Bearer ghp_secret")
+	assert.Equal(t, 400, status)
+	assert.Empty(t, baseURLs)
+
+	status, key = get("/fetch?repo=ghe.corp/team/repo", "Bearer ghp_secret")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, userClientKey, key)
+	assert.Equal(t, []string{"https://ghe.corp/api/v3"}, baseURLs)
+}
@@ -3,74 +3,36 @@ package handlers
 import (
 	"context"
 	"log"
-	"math/rand"
 	"net/url"
 	"strings"
 	"time"
 
-	cache "github.com/Code-Hex/go-generics-cache"
+	"github.com/emanuelef/gh-repo-stats-server/cache"
 	"github.com/emanuelef/gh-repo-stats-server/config"
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
 	"github.com/emanuelef/github-repo-activity-stats/repostats"
 	"github.com/emanuelef/github-repo-activity-stats/stats"
 	"github.com/gofiber/fiber/v2"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
-	"golang.org/x/exp/maps"
 )
 
-// classifyGitHubError returns the appropriate HTTP status code and message based on the error
-func classifyGitHubError(err error) (int, string) {
-	errStr := strings.ToLower(err.Error())
-
-	// Check for rate limit errors
-	if strings.Contains(errStr, "rate limit") ||
-		strings.Contains(errStr, "ratelimit") ||
-		strings.Contains(errStr, "api rate") ||
-		strings.Contains(errStr, "secondary rate") ||
-		strings.Contains(errStr, "exceeded") {
-		return 429, "GitHub API rate limit exceeded. Please try again later."
-	}
-
-	// Check for not found errors
-	if strings.Contains(errStr, "not found") ||
-		strings.Contains(errStr, "could not resolve") ||
-		strings.Contains(errStr, "does not exist") {
-		return 404, "Repository not found on GitHub"
-	}
-
-	// Check for authorization errors
-	if strings.Contains(errStr, "unauthorized") ||
-		strings.Contains(errStr, "bad credentials") ||
-		strings.Contains(errStr, "401") {
-		return 401, "GitHub API authorization error"
-	}
-
-	// Default to 500 for unknown errors
-	return 500, "Internal server error while fetching GitHub data"
-}
-
 func AllReleasesHandler(
 	ctx context.Context,
-	ghStatClients map[string]*repostats.ClientGQL,
-	cacheReleases *cache.Cache[string, []stats.ReleaseInfo],
+	clientPool *tokens.Pool,
+	cacheReleases *cache.Cache[[]stats.ReleaseInfo],
 ) fiber.Handler {
-	return func(c *fiber.Ctx) error {
+	return withGitHubClient(ctx, clientPool, func(c *fiber.Ctx, calls *githubCalls) error {
 		param := c.Query("repo")
-		randomIndex := rand.Intn(len(maps.Keys(ghStatClients)))
-		clientKey := c.Query("client", maps.Keys(ghStatClients)[randomIndex])
 		forceRefetch := c.Query("forceRefetch", "false") == "true"
 
-		client, ok := ghStatClients[clientKey]
-		if !ok {
-			return c.Status(404).SendString("Resource not found")
-		}
-
 		repo, err := url.QueryUnescape(param)
 		if err != nil {
 			return err
 		}
 
-		repo = strings.ToLower(repo)
+		repo = repoid.Normalize(repo)
 
 		ip := c.Get("X-Forwarded-For")
 		if ip == "" {
@@ -94,49 +56,56 @@ func AllReleasesHandler(
 			cacheReleases.Delete(cacheKey)
 		}
 
-		if res, hit := cacheReleases.Get(cacheKey); hit {
-			return c.JSON(res)
-		}
-
-		releases, err := client.GetAllReleasesFeed(ctx, repo)
+		releases, err := repoReleases(trace.ContextWithSpan(ctx, span), calls, cacheReleases, repo)
 		if err != nil {
-			log.Printf("Error fetching releases for %s: %v", repo, err)
-			status, message := classifyGitHubError(err)
-			return c.Status(status).SendString(message)
+			return sendGitHubError(c, calls.Key(), err)
 		}
 
-		now := time.Now()
-		nextDay := now.UTC().Truncate(24 * time.Hour).Add(config.DayCached * 24 * time.Hour)
-		durationUntilEndOfDay := nextDay.Sub(now)
+		return c.JSON(releases)
+	})
+}
 
-		cacheReleases.Set(cacheKey, releases, cache.WithExpiration(durationUntilEndOfDay))
+// repoReleases returns the releases of repo, cached for config.DayCached days
+func repoReleases(
+	ctx context.Context,
+	calls *githubCalls,
+	cacheReleases *cache.Cache[[]stats.ReleaseInfo],
+	repo string,
+) ([]stats.ReleaseInfo, error) {
+	cacheKey := repo + "_releases"
+	if res, hit := cacheReleases.Get(cacheKey); hit {
+		return res, nil
+	}
 
-		return c.JSON(releases)
+	calls.markBusy(repo)
+	releases, err := callGitHub(ctx, calls, func(ctx context.Context, client *repostats.ClientGQL) ([]stats.ReleaseInfo, error) {
+		return client.GetAllReleasesFeed(ctx, repoid.Path(repo))
+	})
+	if err != nil {
+		return nil, err
 	}
+
+	nextDay := time.Now().UTC().Truncate(24 * time.Hour).Add(config.DayCached * 24 * time.Hour)
+
+	cacheReleases.Set(cacheKey, releases, nextDay)
+
+	return releases, nil
 }
 
 func StatsHandler(
 	ctx context.Context,
-	ghStatClients map[string]*repostats.ClientGQL,
-	cacheOverall *cache.Cache[string, *stats.RepoStats],
+	clientPool *tokens.Pool,
+	cacheOverall *cache.Cache[*stats.RepoStats],
 ) fiber.Handler {
-	return func(c *fiber.Ctx) error {
+	return withGitHubClient(ctx, clientPool, func(c *fiber.Ctx, calls *githubCalls) error {
 		param := c.Query("repo")
-
-		randomIndex := rand.Intn(len(maps.Keys(ghStatClients)))
-		clientKey := c.Query("client", maps.Keys(ghStatClients)[randomIndex])
 		forceRefetch := c.Query("forceRefetch", "false") == "true"
 
-		client, ok := ghStatClients[clientKey]
-
-		if !ok {
-			return c.Status(404).SendString("Resource not found")
-		}
-
 		repo, err := url.QueryUnescape(param)
 		if err != nil {
 			return err
 		}
+		repo = strings.Clone(repoid.Normalize(repo))
 
 		span := trace.SpanFromContext(c.UserContext())
 		span.SetAttributes(attribute.String("github.repo", repo))
@@ -149,44 +118,44 @@ func StatsHandler(
 			return c.JSON(res)
 		}
 
-		result, err := client.GetAllStats(ctx, repo)
+		calls.markBusy(repo)
+		result, err := callGitHub(trace.ContextWithSpan(ctx, span), calls, func(ctx context.Context, client *repostats.ClientGQL) (*stats.RepoStats, error) {
+			return client.GetAllStats(ctx, repoid.Path(repo))
+		})
 		if err != nil {
-			log.Printf("Error getting all stats %v", err)
-			return c.Status(404).SendString("Custom 404 Error: Resource not found")
+			return sendGitHubError(c, calls.Key(), err)
 		}
 
-		now := time.Now()
-		nextDay := now.UTC().Truncate(24 * time.Hour).Add(config.DayCached * 24 * time.Hour)
-		durationUntilEndOfDay := nextDay.Sub(now)
+		nextDay := time.Now().UTC().Truncate(24 * time.Hour).Add(config.DayCached * 24 * time.Hour)
 
-		cacheOverall.Set(repo, result, cache.WithExpiration(durationUntilEndOfDay))
+		cacheOverall.Set(repo, result, nextDay)
 		return c.JSON(result)
-	}
+	})
 }
 
 func TotalStarsHandler(
 	ctx context.Context,
-	ghStatClients map[string]*repostats.ClientGQL,
+	clientPool *tokens.Pool,
 ) fiber.Handler {
-	return func(c *fiber.Ctx) error {
+	return withGitHubClient(ctx, clientPool, func(c *fiber.Ctx, calls *githubCalls) error {
 		param := c.Query("repo")
-		overrideClient := c.Query("client", "")
-		clientKey, client := SelectBestClient(ctx, ghStatClients, overrideClient)
-		if client == nil {
-			return c.Status(500).SendString("No GitHub API client available")
-		}
-		log.Printf("Using client: %s", clientKey)
+		log.Printf("Using client: %s", calls.Key())
 
 		repo, err := url.QueryUnescape(param)
 		if err != nil {
 			return err
 		}
+		repo = repoid.Normalize(repo)
 
-		stars, createdAt, err := client.GetTotalStars(ctx, repo)
+		var createdAt time.Time
+		calls.markBusy(repo)
+		stars, err := callGitHub(trace.ContextWithSpan(ctx, trace.SpanFromContext(c.UserContext())), calls, func(ctx context.Context, client *repostats.ClientGQL) (int, error) {
+			stars, created, err := client.GetTotalStars(ctx, repoid.Path(repo))
+			createdAt = created
+			return stars, err
+		})
 		if err != nil {
-			log.Printf("Error getting total stars for %s: %v", repo, err)
-			status, message := classifyGitHubError(err)
-			return c.Status(status).SendString(message)
+			return sendGitHubError(c, calls.Key(), err)
 		}
 
 		data := map[string]any{
@@ -195,5 +164,5 @@ func TotalStarsHandler(
 		}
 
 		return c.JSON(data)
-	}
+	})
 }
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/emanuelef/gh-repo-stats-server/jobs"
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
+	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/gofiber/fiber/v2"
+)
+
+var errNoClient = errors.New("no GitHub API client available")
+
+type createJobRequest struct {
+	Repo   string `json:"repo"`
+	Metric string `json:"metric"`
+	Client string `json:"client"`
+}
+
+// CreateJobHandler handles POST /jobs, starting a background fetch of one metric for a repo
+func CreateJobHandler(
+	ctx context.Context,
+	clientPool *tokens.Pool,
+	manager *jobs.Manager,
+	fetchers map[string]MetricFetcher,
+) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req createJobRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		repo := repoid.Normalize(req.Repo)
+		metric := strings.ToLower(strings.TrimSpace(req.Metric))
+		if metric == "" {
+			metric = session.MetricStars
+		}
+
+		if !strings.Contains(repoid.Path(repo), "/") {
+			return c.Status(400).JSON(fiber.Map{"error": "repo must be in the form owner/name or host/owner/name"})
+		}
+
+		if _, ok := clientPool.BaseURL(repoid.Host(repo)); !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "No GitHub client for host " + repoid.Host(repo)})
+		}
+
+		fetcher, ok := fetchers[metric]
+		if !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "Unsupported metric: " + metric})
+		}
+
+		job, err := startFetchJob(ctx, clientPool, manager, fetcher, repo, metric, req.Client)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Could not create job"})
+		}
+
+		return c.Status(202).JSON(job.Status())
+	}
+}
+
+// startFetchJob starts a background job fetching metric for repo with fetcher, starting with the client
+// overrideKey when not empty, or returns the job already doing it
+func startFetchJob(
+	ctx context.Context,
+	clientPool *tokens.Pool,
+	manager *jobs.Manager,
+	fetcher MetricFetcher,
+	repo, metric, overrideKey string,
+) (*jobs.Job, error) {
+	job, created, err := manager.Start(repo, metric, func(jobCtx context.Context, job *jobs.Job) error {
+		ghStatClients := clientPool.ClientsFor(repoid.Host(repo))
+		clientKey, client := SelectBestClient(ctx, ghStatClients, overrideKey)
+		if client == nil {
+			return errNoClient
+		}
+		job.SetClient(clientKey)
+		log.Printf("Job %s (%s %s) using client: %s", job.ID, metric, repo, clientKey)
+
+		// The job follows the fetch it started or joined, and the client it fails over to
+		err := fetcher.Fetch(jobCtx, failoverClients(ghStatClients, overrideKey), clientKey, client, repo, func(ev session.Event) {
+			job.SetProgress(ev.PagesDone)
+			if ev.Client != "" {
+				job.SetClient(ev.Client)
+			}
+		})
+		if err != nil {
+			log.Printf("Job %s (%s %s) ended: %v", job.ID, metric, repo, err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if created {
+		log.Printf("Job %s created for %s %s", job.ID, metric, repo)
+	}
+	return job, nil
+}
+
+// GetJobHandler handles GET /jobs/:id
+func GetJobHandler(manager *jobs.Manager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		job, ok := manager.Get(c.Params("id"))
+		if !ok {
+			return c.Status(404).JSON(fiber.Map{"error": "Job not found"})
+		}
+
+		return c.JSON(job.Status())
+	}
+}
+
+// CancelJobHandler handles DELETE /jobs/:id, cancelling the underlying GitHub fetch
+func CancelJobHandler(manager *jobs.Manager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		job, ok := manager.Cancel(c.Params("id"))
+		if !ok {
+			return c.Status(404).JSON(fiber.Map{"error": "Job not found"})
+		}
+
+		return c.JSON(job.Status())
+	}
+}
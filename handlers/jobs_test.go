@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/cache"
+	"github.com/emanuelef/gh-repo-stats-server/inflight"
+	"github.com/emanuelef/gh-repo-stats-server/jobs"
+	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newJobsApp(t *testing.T, fetchers map[string]MetricFetcher) (*fiber.App, *jobs.Manager) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	manager := jobs.NewManager(ctx)
+	clients, err := tokens.NewPool(
+		func() ([]tokens.Token, error) { return []tokens.Token{{Label: "PAT"}}, nil },
+		func(tokens.Token) *repostats.ClientGQL { return &repostats.ClientGQL{} },
+	)
+	require.NoError(t, err)
+
+	app := fiber.New()
+	app.Post("/jobs", CreateJobHandler(ctx, clients, manager, fetchers))
+	app.Get("/jobs/:id", GetJobHandler(manager))
+	app.Delete("/jobs/:id", CancelJobHandler(manager))
+
+	return app, manager
+}
+
+func postJob(t *testing.T, app *fiber.App, body string) (int, jobs.Status) {
+	t.Helper()
+
+	req := httptest.NewRequest("POST", "/jobs", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var status jobs.Status
+	_ = json.NewDecoder(resp.Body).Decode(&status)
+	return resp.StatusCode, status
+}
+
+func TestCreateJobFillsCache(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+
+	stars := cache.NewCache[int]()
+	var onGoing inflight.Group[int]
+	fetch := newMetricFetcher("stars", session.NewHub(0), stars, &onGoing, func(ctx context.Context, calls *githubCalls, repo string, c *cache.Cache[int]) (int, error) {
+		calls.progress.Progress(50)
+		c.Set(repo, 1234, time.Now().Add(time.Hour))
+		return 1234, nil
+	}, nil)
+
+	app, manager := newJobsApp(t, map[string]MetricFetcher{"stars": fetch})
+
+	code, status := postJob(t, app, `{"repo":"Helm/Helm","metric":"stars"}`)
+	assert.Equal(t, 202, code)
+	assert.Equal(t, "helm/helm", status.Repo)
+	require.NotEmpty(t, status.ID)
+
+	job, ok := manager.Get(status.ID)
+	require.True(t, ok)
+	require.Eventually(t, job.Done, time.Second, time.Millisecond)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/jobs/"+status.ID, nil))
+	require.NoError(t, err)
+	var got jobs.Status
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	assert.Equal(t, jobs.StateCompleted, got.State)
+	assert.Equal(t, 50, got.Progress)
+	assert.Equal(t, "PAT", got.Client)
+
+	val, hit := stars.Get("helm/helm")
+	assert.True(t, hit)
+	assert.Equal(t, 1234, val)
+	assert.Empty(t, GetBusyClients())
+}
+
+func TestCreateJobValidation(t *testing.T) {
+	app, _ := newJobsApp(t, map[string]MetricFetcher{})
+
+	code, _ := postJob(t, app, `{"repo":"helm","metric":"stars"}`)
+	assert.Equal(t, 400, code)
+
+	code, _ = postJob(t, app, `{"repo":"helm/helm","metric":"watchers"}`)
+	assert.Equal(t, 400, code)
+}
+
+func TestCancelJob(t *testing.T) {
+	var onGoing inflight.Group[int]
+	fetch := newMetricFetcher("forks", session.NewHub(0), cache.NewCache[int](), &onGoing, func(ctx context.Context, calls *githubCalls, repo string, c *cache.Cache[int]) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}, nil)
+
+	app, manager := newJobsApp(t, map[string]MetricFetcher{"forks": fetch})
+
+	_, status := postJob(t, app, `{"repo":"helm/helm","metric":"forks"}`)
+
+	resp, err := app.Test(httptest.NewRequest("DELETE", "/jobs/"+status.ID, nil))
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	job, _ := manager.Get(status.ID)
+	assert.Equal(t, jobs.StateCancelled, job.Status().State)
+	// The cancelled fetch releases its client
+	require.Eventually(t, func() bool { return len(GetBusyClients()) == 0 }, time.Second, time.Millisecond)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/jobs/missing", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+}
+
+func TestCancelJobKeepsJoinedFetch(t *testing.T) {
+	release := make(chan struct{})
+	var onGoing inflight.Group[int]
+	fetch := newMetricFetcher("forks", session.NewHub(0), cache.NewCache[int](), &onGoing, func(ctx context.Context, calls *githubCalls, repo string, c *cache.Cache[int]) (int, error) {
+		select {
+		case <-release:
+			return 1234, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}, nil)
+
+	app, manager := newJobsApp(t, map[string]MetricFetcher{"forks": fetch})
+
+	_, status := postJob(t, app, `{"repo":"helm/helm","metric":"forks"}`)
+	require.Eventually(t, func() bool { return onGoing.InFlight("helm/helm") }, time.Second, time.Millisecond)
+
+	// A request joins the fetch the job started, as /allForks does
+	joined := make(chan error, 1)
+	go func() {
+		_, err, _ := onGoing.Do("helm/helm", func() (int, error) { return 0, nil })
+		joined <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	resp, err := app.Test(httptest.NewRequest("DELETE", "/jobs/"+status.ID, nil))
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	job, _ := manager.Get(status.ID)
+	assert.Equal(t, jobs.StateCancelled, job.Status().State)
+
+	close(release)
+	assert.NoError(t, <-joined)
+}
+
+func TestJobFollowsJoinedFetch(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+
+	started := make(chan struct{})
+	failover := make(chan struct{})
+	release := make(chan struct{})
+	var onGoing inflight.Group[int]
+	fetch := newMetricFetcher("forks", session.NewHub(0), cache.NewCache[int](), &onGoing, func(ctx context.Context, calls *githubCalls, repo string, c *cache.Cache[int]) (int, error) {
+		calls.progress.Started()
+		calls.progress.Progress(7)
+		close(started)
+
+		<-failover
+		calls.progress.FailedOver("PAT2", "PAT", errors.New("API rate limit exceeded for user ID 123."))
+		calls.progress.Progress(9)
+
+		<-release
+		return 1234, nil
+	}, nil)
+
+	// A fetch started by a request, as /allForks does, with another client than the job would pick
+	fetched := make(chan error, 1)
+	go func() {
+		fetched <- fetch.Fetch(context.Background(), nil, "PAT2", &repostats.ClientGQL{}, "helm/helm", nil)
+	}()
+	<-started
+
+	app, manager := newJobsApp(t, map[string]MetricFetcher{"forks": fetch})
+	_, status := postJob(t, app, `{"repo":"helm/helm","metric":"forks"}`)
+	job, ok := manager.Get(status.ID)
+	require.True(t, ok)
+
+	// The job shows the progress and client of the fetch it joined, and follows its failover
+	require.Eventually(t, func() bool {
+		s := job.Status()
+		return s.Progress == 7 && s.Client == "PAT2"
+	}, time.Second, time.Millisecond)
+	close(failover)
+	require.Eventually(t, func() bool {
+		s := job.Status()
+		return s.Progress == 9 && s.Client == "PAT"
+	}, time.Second, time.Millisecond)
+
+	close(release)
+	require.NoError(t, <-fetched)
+	require.Eventually(t, job.Done, time.Second, time.Millisecond)
+	assert.Equal(t, jobs.StateCompleted, job.Status().State)
+}
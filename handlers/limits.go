@@ -2,37 +2,120 @@ package handlers
 
 import (
 	"context"
+	"time"
 
+	"github.com/emanuelef/gh-repo-stats-server/config"
+	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
 	"github.com/emanuelef/github-repo-activity-stats/repostats"
 	"github.com/gofiber/fiber/v2"
 )
 
-// LimitsHandler handles the /limits endpoint to check GitHub API rate limits
+// limitsResponse is the summed rate limit of the pool, as shown by the UI, with the details of each client
+type limitsResponse struct {
+	*repostats.RateLimit
+	Breakers map[string]ClientHealth `json:"breakers"`
+	Clients  []clientLimits          `json:"clients"`
+	// StarsPerHour estimates how many stars the pool can fetch in the next hour, see hourlyStars
+	StarsPerHour int `json:"starsPerHour"`
+}
+
+// clientLimits is the rate limit of one client as known to the ClientSelector
+type clientLimits struct {
+	Label string `json:"label"`
+	Host  string `json:"host"`
+	// Known is false when the rate limit of the client couldn't be read
+	Known     bool      `json:"known"`
+	Remaining int       `json:"remaining"`
+	Limit     int       `json:"limit"`
+	ResetAt   time.Time `json:"resetAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// BusyWith is the repo or range the client is fetching, empty when idle
+	BusyWith     string `json:"busyWith,omitempty"`
+	Breaker      string `json:"breaker"`
+	StarsPerHour int    `json:"starsPerHour"`
+}
+
+// hourlyPoints is how many points the client can spend within the next hour: what is left of the current
+// window, plus a whole window when it resets within the hour. A client out of rotation has none.
+func hourlyPoints(info *ClientRateLimitInfo, health ClientHealth, now time.Time) int {
+	if health.State == BreakerOpen {
+		return 0
+	}
+	if !now.Before(info.ResetAt) {
+		return info.Limit
+	}
+	points := info.Remaining
+	if info.ResetAt.Sub(now) < time.Hour {
+		points += info.Limit
+	}
+	return points
+}
+
+// hourlyStars is how many stars the client can fetch within the next hour: a page per point it can spend,
+// but no more pages than a fetch gets through in an hour, at the pace /estimate assumes
+func hourlyStars(info *ClientRateLimitInfo, health ClientHealth, now time.Time) int {
+	pages := min(hourlyPoints(info, health, now), hourlyPages(metricSizes[session.MetricStars]))
+	return pages * config.GitHubPageSize
+}
+
+// LimitsHandler handles the /limits endpoint to check GitHub API rate limits: the sum over the pool,
+// and the rate limit, busy state and circuit breaker of each client. Outdated rate limits are refreshed first.
 func LimitsHandler(
-	ghStatClients map[string]*repostats.ClientGQL,
+	clientPool *tokens.Pool,
 	ctx context.Context,
 ) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		client, ok := ghStatClients["PAT"]
-		if !ok {
-			return c.Status(404).SendString("Resource not found")
-		}
+		failedKey, err := globalClientSelector.refreshOutdated(ctx, clientPool.Clients())
+
+		rateLimits := globalClientSelector.GetClientStats()
+		busy := GetBusyClients()
+		health := globalClientSelector.GetClientHealth()
+		now := time.Now()
+
+		var total *repostats.RateLimit
+		resp := limitsResponse{Breakers: make(map[string]ClientHealth)}
+		for _, key := range clientPool.Labels() {
+			h, ok := health[key]
+			if !ok {
+				h = ClientHealth{State: BreakerClosed}
+			}
+			resp.Breakers[key] = h
 
-		result, err := client.GetCurrentLimits(ctx)
-		if err != nil {
-			return fiber.NewError(fiber.StatusBadGateway, "error getting PAT limits: "+err.Error())
+			limits := clientLimits{
+				Label:    key,
+				Host:     clientPool.Host(key),
+				BusyWith: busy[key],
+				Breaker:  h.State,
+			}
+			if info, ok := rateLimits[key]; ok {
+				limits.Known = true
+				limits.Remaining, limits.Limit = info.Remaining, info.Limit
+				limits.ResetAt, limits.UpdatedAt = info.ResetAt, info.UpdatedAt
+				limits.StarsPerHour = hourlyStars(info, h, now)
+				resp.StarsPerHour += limits.StarsPerHour
+
+				if total == nil {
+					total = &repostats.RateLimit{Limit: info.Limit, Remaining: info.Remaining, ResetAt: info.ResetAt}
+				} else {
+					total.Remaining += info.Remaining
+					total.Limit += info.Limit
+					if info.ResetAt.Before(total.ResetAt) {
+						total.ResetAt = info.ResetAt
+					}
+				}
+			}
+			resp.Clients = append(resp.Clients, limits)
 		}
 
-		if client, ok = ghStatClients["PAT2"]; ok {
-			tmpResult, err := client.GetCurrentLimits(ctx)
+		if total == nil {
 			if err != nil {
-				return fiber.NewError(fiber.StatusBadGateway, "error getting PAT2 limits: "+err.Error())
+				return sendGitHubError(c, failedKey, err)
 			}
-
-			result.Remaining += tmpResult.Remaining
-			result.Limit += tmpResult.Limit
+			return c.Status(404).SendString("Resource not found")
 		}
 
-		return c.JSON(result)
+		resp.RateLimit = total
+		return c.JSON(resp)
 	}
 }
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitsHandler(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+
+	pool, err := tokens.NewPool(
+		func() ([]tokens.Token, error) {
+			return []tokens.Token{{Label: "PAT", Value: "a"}, {Label: "PAT2", Value: "b"}, {Label: "PAT3", Value: "c"}, {Label: "GHE", Value: "d"}}, nil
+		},
+		func(tokens.Token) *repostats.ClientGQL { return &repostats.ClientGQL{} },
+	)
+	require.NoError(t, err)
+
+	soon := time.Now().Add(30 * time.Minute).Truncate(time.Second)
+	later := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	globalClientSelector.setRateLimit("PAT", &repostats.RateLimit{Limit: 5000, Remaining: 1000, ResetAt: soon})
+	globalClientSelector.setRateLimit("PAT2", &repostats.RateLimit{Limit: 5000, Remaining: 4000, ResetAt: later})
+	globalClientSelector.setRateLimit("PAT3", &repostats.RateLimit{Limit: 5000, Remaining: 5000, ResetAt: later})
+	globalClientSelector.setRateLimit("GHE", &repostats.RateLimit{Limit: 15000, Remaining: 15000, ResetAt: soon})
+	MarkClientBusy("PAT2", "helm/helm")
+	defer MarkClientIdle("PAT2")
+	// A rejected token is taken out of rotation at once
+	ReportClientResult("PAT3", errors.New("non-200 OK status code: 401 Unauthorized"))
+
+	app := fiber.New()
+	app.Get("/limits", LimitsHandler(pool, context.Background()))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/limits", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	var body struct {
+		Limit        int
+		Remaining    int
+		ResetAt      time.Time
+		Breakers     map[string]ClientHealth `json:"breakers"`
+		Clients      []clientLimits          `json:"clients"`
+		StarsPerHour int                     `json:"starsPerHour"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	// The sum the UI shows
+	assert.Equal(t, 30000, body.Limit)
+	assert.Equal(t, 25000, body.Remaining)
+	assert.True(t, soon.Equal(body.ResetAt))
+
+	require.Len(t, body.Clients, 4)
+	ghe, pat, pat2, pat3 := body.Clients[0], body.Clients[1], body.Clients[2], body.Clients[3]
+	assert.Equal(t, "PAT", pat.Label)
+	assert.Equal(t, "github.com", pat.Host)
+	assert.True(t, pat.Known)
+	assert.Equal(t, 1000, pat.Remaining)
+	assert.True(t, soon.Equal(pat.ResetAt))
+	assert.False(t, pat.UpdatedAt.IsZero())
+	assert.Empty(t, pat.BusyWith)
+	assert.Equal(t, "helm/helm", pat2.BusyWith)
+	assert.Equal(t, BreakerOpen, pat3.Breaker)
+	assert.Equal(t, BreakerOpen, body.Breakers["PAT3"].State)
+
+	// PAT resets within the hour and gets a new window, PAT3 is out of rotation
+	assert.Equal(t, 6000*100, pat.StarsPerHour)
+	assert.Equal(t, 4000*100, pat2.StarsPerHour)
+	assert.Zero(t, pat3.StarsPerHour)
+	// GHE could spend 30000 points, but a fetch of 2 streams of 350ms pages only gets through 20570 in an hour
+	assert.Equal(t, 20570*100, ghe.StarsPerHour)
+	assert.Equal(t, (6000+4000+20570)*100, body.StarsPerHour)
+}
+
+func TestHourlyPoints(t *testing.T) {
+	now := time.Now()
+	closed := ClientHealth{State: BreakerClosed}
+
+	assert.Equal(t, 300, hourlyPoints(&ClientRateLimitInfo{Limit: 5000, Remaining: 300, ResetAt: now.Add(2 * time.Hour)}, closed, now))
+	assert.Equal(t, 5300, hourlyPoints(&ClientRateLimitInfo{Limit: 5000, Remaining: 300, ResetAt: now.Add(time.Minute)}, closed, now))
+	// The window already reset
+	assert.Equal(t, 5000, hourlyPoints(&ClientRateLimitInfo{Limit: 5000, Remaining: 0, ResetAt: now.Add(-time.Minute)}, closed, now))
+}
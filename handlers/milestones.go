@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/emanuelef/gh-repo-stats-server/analytics"
+	"github.com/emanuelef/gh-repo-stats-server/config"
+	"github.com/emanuelef/gh-repo-stats-server/jobs"
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
+	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// milestonesResponse lists the round numbers of stars a repo reached and when it should reach the next
+// ones, see analytics.Milestones
+type milestonesResponse struct {
+	Repo       string `json:"repo"`
+	TotalStars int    `json:"totalStars"`
+	LastDay    string `json:"lastDay"`
+	// Velocity is the average stars per day over the last VelocityDays days, the projections assume it holds
+	Velocity     float64              `json:"velocity"`
+	VelocityDays int                  `json:"velocityDays"`
+	Milestones   []milestoneDay       `json:"milestones"`
+	Next         []projectedMilestone `json:"next"`
+}
+
+type milestoneDay struct {
+	Stars int    `json:"stars"`
+	Day   string `json:"day"`
+	// Days is how many days it took since the previous milestone, or the first star
+	Days int `json:"days"`
+}
+
+// projectedMilestone is when a milestone should be reached, none are projected when the repo got no stars
+// lately
+type projectedMilestone struct {
+	Stars int    `json:"stars"`
+	Day   string `json:"day"`
+	// Days is how many days after LastDay it should be reached
+	Days int `json:"days"`
+	// Remaining is how many stars are missing
+	Remaining int `json:"remaining"`
+}
+
+// MilestonesHandler handles the /milestones endpoint listing when ?repo= crossed each round number of
+// stars (10, 20, 50, 100, 200...) in its cached stars history, and projecting the ?next= (3 by default)
+// following ones from its recent velocity. A repo that is not cached gets a background job filling its
+// cache, like POST /jobs, and a 202 with the job.
+func MilestonesHandler(
+	ctx context.Context,
+	clientPool *tokens.Pool,
+	manager *jobs.Manager,
+	fetchers map[string]MetricFetcher,
+) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		repo, err := url.QueryUnescape(c.Query("repo"))
+		if err != nil {
+			return err
+		}
+		repo = strings.Clone(repoid.Normalize(repo))
+		if !strings.Contains(repoid.Path(repo), "/") {
+			return c.Status(400).JSON(fiber.Map{"error": "repo must be in the form owner/name or host/owner/name"})
+		}
+		if _, ok := clientPool.BaseURL(repoid.Host(repo)); !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "No GitHub client for host " + repoid.Host(repo)})
+		}
+
+		next, err := strconv.Atoi(c.Query("next", "3"))
+		if err != nil || next < 0 || next > config.MilestonesMaxNext {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("next must be between 0 and %d", config.MilestonesMaxNext)})
+		}
+
+		span := trace.SpanFromContext(c.UserContext())
+		span.SetAttributes(attribute.String("github.repo", repo))
+
+		fetcher := fetchers[session.MetricStars]
+		totals, hit, err := fetcher.Totals(repo)
+		if err != nil {
+			log.Printf("Error reading cached stars of %s: %v", repo, err)
+			return c.Status(500).JSON(fiber.Map{"error": "Could not read cached stars of " + repo})
+		}
+		if !hit {
+			job, err := startFetchJob(ctx, clientPool, manager, fetcher, repo, session.MetricStars, "")
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "Could not create job"})
+			}
+			return c.Status(202).JSON(job.Status())
+		}
+
+		resp := milestonesResponse{
+			Repo:         repo,
+			VelocityDays: config.MilestonesVelocityDays,
+			Milestones:   []milestoneDay{},
+			Next:         []projectedMilestone{},
+		}
+		if len(totals) == 0 {
+			return c.JSON(resp)
+		}
+
+		for _, m := range analytics.Milestones(totals) {
+			resp.Milestones = append(resp.Milestones, milestoneDay{
+				Stars: int(m.Threshold),
+				Day:   m.Day.Format("02-01-2006"),
+				Days:  m.Days,
+			})
+		}
+
+		last := totals[len(totals)-1]
+		resp.TotalStars = int(last.Value)
+		resp.LastDay = last.Day.Format("02-01-2006")
+		resp.Velocity = analytics.Velocity(totals, config.MilestonesVelocityDays)
+		for _, p := range analytics.ProjectMilestones(last.Value, last.Day, resp.Velocity, next) {
+			resp.Next = append(resp.Next, projectedMilestone{
+				Stars:     int(p.Threshold),
+				Day:       p.Day.Format("02-01-2006"),
+				Days:      p.Days,
+				Remaining: int(p.Threshold - last.Value),
+			})
+		}
+
+		return c.JSON(resp)
+	}
+}
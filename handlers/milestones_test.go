@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/cache"
+	"github.com/emanuelef/gh-repo-stats-server/inflight"
+	"github.com/emanuelef/gh-repo-stats-server/jobs"
+	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/emanuelef/gh-repo-stats-server/types"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMilestonesHandler(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+	globalClientSelector.setRateLimit("PAT", &repostats.RateLimit{Limit: 5000, Remaining: 5000, ResetAt: time.Now().Add(time.Hour)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	daily := make([]int, 100)
+	for i := range daily {
+		daily[i] = 3
+	}
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cacheStars := cache.NewCache[types.StarsWithStatsResponse]()
+	cacheStars.Set("helm/helm", starsHistory(day, daily...), time.Now().Add(time.Hour))
+
+	var onGoing inflight.Group[types.StarsWithStatsResponse]
+	fetcher := newMetricFetcher(session.MetricStars, session.NewHub(0), cacheStars, &onGoing,
+		func(ctx context.Context, calls *githubCalls, repo string, c *cache.Cache[types.StarsWithStatsResponse]) (types.StarsWithStatsResponse, error) {
+			res := starsHistory(day, daily...)
+			c.Set(repo, res, time.Now().Add(time.Hour))
+			return res, nil
+		},
+		func(res types.StarsWithStatsResponse) any { return res.Stars })
+
+	pool, err := tokens.NewPool(
+		func() ([]tokens.Token, error) { return []tokens.Token{{Label: "PAT", Value: "a"}}, nil },
+		func(tokens.Token) *repostats.ClientGQL { return &repostats.ClientGQL{} },
+	)
+	require.NoError(t, err)
+
+	manager := jobs.NewManager(ctx)
+	app := fiber.New()
+	app.Get("/milestones", MilestonesHandler(ctx, pool, manager, map[string]MetricFetcher{session.MetricStars: fetcher}))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/milestones?repo=Helm/Helm", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	var body milestonesResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "helm/helm", body.Repo)
+	assert.Equal(t, 300, body.TotalStars)
+	assert.Equal(t, "09-04-2024", body.LastDay)
+	assert.InDelta(t, 3, body.Velocity, 1e-9)
+	assert.Equal(t, []milestoneDay{
+		{Stars: 10, Day: "04-01-2024", Days: 3},
+		{Stars: 20, Day: "07-01-2024", Days: 3},
+		{Stars: 50, Day: "17-01-2024", Days: 10},
+		{Stars: 100, Day: "03-02-2024", Days: 17},
+		{Stars: 200, Day: "07-03-2024", Days: 33},
+	}, body.Milestones)
+	assert.Equal(t, []projectedMilestone{
+		{Stars: 500, Day: "15-06-2024", Days: 67, Remaining: 200},
+		{Stars: 1000, Day: "29-11-2024", Days: 234, Remaining: 700},
+		{Stars: 2000, Day: "28-10-2025", Days: 567, Remaining: 1700},
+	}, body.Next)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/milestones?repo=helm/helm&next=1", nil))
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Len(t, body.Next, 1)
+
+	// Not cached: a job fills the cache
+	resp, err = app.Test(httptest.NewRequest("GET", "/milestones?repo=argoproj/argo-cd", nil))
+	require.NoError(t, err)
+	require.Equal(t, 202, resp.StatusCode)
+	var status jobs.Status
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	job, ok := manager.Get(status.ID)
+	require.True(t, ok)
+	require.Eventually(t, job.Done, time.Second, time.Millisecond)
+
+	for url, code := range map[string]int{
+		"/milestones?repo=helm/helm&next=11": 400,
+		"/milestones?repo=helm/helm&next=-1": 400,
+		"/milestones?repo=helm":              400,
+	} {
+		resp, err := app.Test(httptest.NewRequest("GET", url, nil))
+		require.NoError(t, err)
+		assert.Equal(t, code, resp.StatusCode, url)
+	}
+}
@@ -4,18 +4,21 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
 	"strconv"
+	"strings"
 	"time"
 
-	cache "github.com/Code-Hex/go-generics-cache"
+	"github.com/emanuelef/gh-repo-stats-server/cache"
 	"github.com/emanuelef/gh-repo-stats-server/news"
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
 	"github.com/emanuelef/gh-repo-stats-server/types"
-	"github.com/emanuelef/gh-repo-stats-server/utils"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
 	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func HackerNewsHandler(cacheHackerNews *cache.Cache[string, []news.Article]) fiber.Handler {
+func HackerNewsHandler(cacheHackerNews *cache.Cache[[]news.Article]) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		query := c.Query("query", "golang")
 
@@ -28,23 +31,36 @@ func HackerNewsHandler(cacheHackerNews *cache.Cache[string, []news.Article]) fib
 			return c.Status(400).SendString("Invalid limit parameter")
 		}
 
-		articles, err := news.FetchHackerNewsArticles(query, limit)
+		articles, err := hackerNewsArticles(cacheHackerNews, query, limit)
 		if err != nil {
 			log.Printf("Error fetching Hacker News articles: %v", err)
 			return c.Status(500).SendString("Internal Server Error")
 		}
 
-		now := time.Now()
-		nextDay := now.UTC().Truncate(24 * time.Hour).Add(1 * 24 * time.Hour)
-		durationUntilEndOfDay := nextDay.Sub(now)
+		return c.JSON(articles)
+	}
+}
 
-		cacheHackerNews.Set(query, articles, cache.WithExpiration(durationUntilEndOfDay))
+// hackerNewsArticles returns the Hacker News articles matching query with minPoints points or more,
+// cached until the next day under query
+func hackerNewsArticles(cacheHackerNews *cache.Cache[[]news.Article], query string, minPoints int) ([]news.Article, error) {
+	if res, hit := cacheHackerNews.Get(query); hit {
+		return res, nil
+	}
 
-		return c.JSON(articles)
+	articles, err := news.FetchHackerNewsArticles(query, minPoints)
+	if err != nil {
+		return nil, err
 	}
+
+	nextDay := time.Now().UTC().Truncate(24 * time.Hour).Add(1 * 24 * time.Hour)
+
+	cacheHackerNews.Set(query, articles, nextDay)
+
+	return articles, nil
 }
 
-func RedditHandler(cacheReddit *cache.Cache[string, []news.ArticleData]) fiber.Handler {
+func RedditHandler(cacheReddit *cache.Cache[[]news.ArticleData]) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		query := c.Query("query", "golang")
 
@@ -58,28 +74,37 @@ func RedditHandler(cacheReddit *cache.Cache[string, []news.ArticleData]) fiber.H
 			strict = strictVal
 		}
 
-		cacheKey := fmt.Sprintf("reddit:%s:%d:%t", query, limit, strict)
-		if res, hit := cacheReddit.Get(cacheKey); hit {
-			return c.JSON(res)
-		}
-
-		articles, err := news.FetchRedditPosts(query, limit, strict)
+		articles, err := redditPosts(cacheReddit, query, limit, strict)
 		if err != nil {
 			log.Printf("Error fetching Reddit articles: %v", err)
 			return c.Status(500).SendString("Internal Server Error")
 		}
 
-		now := time.Now()
-		nextDay := now.UTC().Truncate(24 * time.Hour).Add(1 * 24 * time.Hour)
-		durationUntilEndOfDay := nextDay.Sub(now)
+		return c.JSON(articles)
+	}
+}
 
-		cacheReddit.Set(cacheKey, articles, cache.WithExpiration(durationUntilEndOfDay))
+// redditPosts returns the Reddit posts matching query with minUpvotes upvotes or more, cached until the
+// next day
+func redditPosts(cacheReddit *cache.Cache[[]news.ArticleData], query string, minUpvotes int, strict bool) ([]news.ArticleData, error) {
+	cacheKey := fmt.Sprintf("reddit:%s:%d:%t", query, minUpvotes, strict)
+	if res, hit := cacheReddit.Get(cacheKey); hit {
+		return res, nil
+	}
 
-		return c.JSON(articles)
+	articles, err := news.FetchRedditPosts(query, minUpvotes, strict)
+	if err != nil {
+		return nil, err
 	}
+
+	nextDay := time.Now().UTC().Truncate(24 * time.Hour).Add(1 * 24 * time.Hour)
+
+	cacheReddit.Set(cacheKey, articles, nextDay)
+
+	return articles, nil
 }
 
-func YouTubeHandler(cacheYouTube *cache.Cache[string, []news.YTVideoMetadata]) fiber.Handler {
+func YouTubeHandler(cacheYouTube *cache.Cache[[]news.YTVideoMetadata]) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		query := c.Query("query", "golang")
 
@@ -92,23 +117,35 @@ func YouTubeHandler(cacheYouTube *cache.Cache[string, []news.YTVideoMetadata]) f
 			return c.Status(400).SendString("Invalid limit parameter")
 		}
 
-		articles, err := news.FetchYouTubeVideos(query, limit)
+		articles, err := youTubeVideos(cacheYouTube, query, limit)
 		if err != nil {
 			log.Printf("Error fetching YouTube videos: %v", err)
 			return c.Status(500).SendString("Internal Server Error")
 		}
 
-		now := time.Now()
-		nextDay := now.UTC().Truncate(24 * time.Hour).Add(1 * 24 * time.Hour)
-		durationUntilEndOfDay := nextDay.Sub(now)
+		return c.JSON(articles)
+	}
+}
 
-		cacheYouTube.Set(query, articles, cache.WithExpiration(durationUntilEndOfDay))
+// youTubeVideos returns up to limit YouTube videos matching query, cached until the next day under query
+func youTubeVideos(cacheYouTube *cache.Cache[[]news.YTVideoMetadata], query string, limit int) ([]news.YTVideoMetadata, error) {
+	if res, hit := cacheYouTube.Get(query); hit {
+		return res, nil
+	}
 
-		return c.JSON(articles)
+	videos, err := news.FetchYouTubeVideos(query, limit)
+	if err != nil {
+		return nil, err
 	}
+
+	nextDay := time.Now().UTC().Truncate(24 * time.Hour).Add(1 * 24 * time.Hour)
+
+	cacheYouTube.Set(query, videos, nextDay)
+
+	return videos, nil
 }
 
-func ShowHNHandler(cacheShowHN *cache.Cache[string, []news.ShowHNPost]) fiber.Handler {
+func ShowHNHandler(cacheShowHN *cache.Cache[[]news.ShowHNPost]) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		sortBy := c.Query("sort", "date")
 
@@ -143,7 +180,7 @@ func ShowHNHandler(cacheShowHN *cache.Cache[string, []news.ShowHNPost]) fiber.Ha
 				return fiber.NewError(fiber.StatusInternalServerError, "error fetching Show HN posts: "+err.Error())
 			}
 
-			cacheShowHN.Set(cacheKey, posts, cache.WithExpiration(4*time.Hour))
+			cacheShowHN.Set(cacheKey, posts, time.Now().Add(4*time.Hour))
 		}
 
 		if minPoints > 0 || minComments > 0 {
@@ -160,7 +197,7 @@ func ShowHNHandler(cacheShowHN *cache.Cache[string, []news.ShowHNPost]) fiber.Ha
 	}
 }
 
-func RedditReposHandler(cacheRedditGitHub *cache.Cache[string, []news.RedditGitHubPost]) fiber.Handler {
+func RedditReposHandler(cacheRedditGitHub *cache.Cache[[]news.RedditGitHubPost]) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		sortBy := c.Query("sort", "date")
 
@@ -195,7 +232,7 @@ func RedditReposHandler(cacheRedditGitHub *cache.Cache[string, []news.RedditGitH
 				return fiber.NewError(fiber.StatusInternalServerError, "error fetching Reddit GitHub posts: "+err.Error())
 			}
 
-			cacheRedditGitHub.Set(cacheKey, posts, cache.WithExpiration(4*time.Hour))
+			cacheRedditGitHub.Set(cacheKey, posts, time.Now().Add(4*time.Hour))
 		}
 
 		if minPoints > 0 || minComments > 0 {
@@ -212,9 +249,12 @@ func RedditReposHandler(cacheRedditGitHub *cache.Cache[string, []news.RedditGitH
 	}
 }
 
-func GitHubMentionsHandler(cacheGitHubMentions *cache.Cache[string, types.GitHubMentionsResponse]) fiber.Handler {
+func GitHubMentionsHandler(
+	clientPool *tokens.Pool,
+	cacheGitHubMentions *cache.Cache[types.GitHubMentionsResponse],
+) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		repo := c.Query("repo", "")
+		repo := strings.Clone(repoid.Normalize(c.Query("repo", "")))
 		if repo == "" {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"error": "repo parameter is required (e.g., ?repo=owner/repo)",
@@ -235,37 +275,40 @@ func GitHubMentionsHandler(cacheGitHubMentions *cache.Cache[string, types.GitHub
 			limit = 100
 		}
 
-		// Create GitHub client using PAT
-		pat := os.Getenv("PAT")
-		if pat == "" {
+		ghStatClients := clientPool.ClientsFor(repoid.Host(repo))
+		clientKey, client := SelectBestClient(context.Background(), ghStatClients, "")
+		if client == nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "GitHub PAT not configured",
+				"error": "No GitHub API client available",
 			})
 		}
-
-		client := utils.NewClientWithPAT(pat)
+		log.Printf("GitHub mentions using client: %s", clientKey)
 
 		// Fetch mentions
-		result, err := client.GetRepoMentions(context.Background(), repo, limit)
-		if err != nil {
-			log.Printf("Error fetching GitHub mentions for %s: %v", repo, err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to fetch GitHub mentions",
-			})
-		}
+		calls := newGitHubCalls(ghStatClients, clientKey, client, nil)
+		ctx := trace.ContextWithSpan(context.Background(), trace.SpanFromContext(c.UserContext()))
+		response, err := callGitHub(ctx, calls, func(ctx context.Context, client *repostats.ClientGQL) (types.GitHubMentionsResponse, error) {
+			result, err := client.GetRepoMentions(ctx, repoid.Path(repo), limit)
+			if err != nil {
+				return types.GitHubMentionsResponse{}, err
+			}
 
-		// Convert to response type
-		response := types.GitHubMentionsResponse{
-			TargetRepo:        result.TargetRepo,
-			TotalMentions:     result.TotalMentions,
-			IssuesCount:       result.IssuesCount,
-			PullRequestsCount: result.PullRequestsCount,
-			DiscussionsCount:  result.DiscussionsCount,
-			Mentions:          result.Mentions,
+			// Convert to response type
+			return types.GitHubMentionsResponse{
+				TargetRepo:        result.TargetRepo,
+				TotalMentions:     result.TotalMentions,
+				IssuesCount:       result.IssuesCount,
+				PullRequestsCount: result.PullRequestsCount,
+				DiscussionsCount:  result.DiscussionsCount,
+				Mentions:          result.Mentions,
+			}, nil
+		})
+		if err != nil {
+			return sendGitHubError(c, calls.Key(), err)
 		}
 
 		// Cache for 4 hours
-		cacheGitHubMentions.Set(repo, response, cache.WithExpiration(4*time.Hour))
+		cacheGitHubMentions.Set(repo, response, time.Now().Add(4*time.Hour))
 
 		return c.JSON(response)
 	}
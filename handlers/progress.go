@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/config"
+	"github.com/emanuelef/gh-repo-stats-server/gherr"
+	"github.com/emanuelef/gh-repo-stats-server/session"
+)
+
+// progressReporter turns the page counts sent on a fetch update channel into
+// events published on the progress hub under the topic of metric and key. A nil reporter discards everything.
+type progressReporter struct {
+	progressHub *session.Hub
+	metric      string
+	key         string
+	clientKey   string
+
+	total     int
+	startedAt time.Time
+	pagesDone int
+}
+
+func newProgressReporter(progressHub *session.Hub, metric, key, clientKey string) *progressReporter {
+	return &progressReporter{
+		progressHub: progressHub,
+		metric:      metric,
+		key:         key,
+		clientKey:   clientKey,
+	}
+}
+
+// SetTotal records the expected number of items, used to estimate the pages left
+func (p *progressReporter) SetTotal(total int) {
+	if p == nil {
+		return
+	}
+	p.total = total
+}
+
+func (p *progressReporter) Started() {
+	if p == nil {
+		return
+	}
+	p.startedAt = time.Now()
+	p.send(p.event(session.EventStarted))
+}
+
+func (p *progressReporter) Progress(pagesDone int) {
+	if p == nil {
+		return
+	}
+	p.pagesDone = pagesDone
+	p.send(p.event(session.EventProgress))
+}
+
+func (p *progressReporter) Finished(err error) {
+	if p == nil {
+		return
+	}
+	if err != nil {
+		ev := p.event(session.EventFailed)
+		ev.Error = err.Error()
+		ev.ErrorKind = string(gherr.KindOf(err))
+		p.send(ev)
+		return
+	}
+	p.send(p.event(session.EventCompleted))
+}
+
+// Retrying reports that the fetch failed with err and starts over after delay
+func (p *progressReporter) Retrying(attempt int, delay time.Duration, err error) {
+	if p == nil {
+		return
+	}
+	ev := p.event(session.EventRetrying)
+	ev.Attempt = attempt
+	ev.RetryInSeconds = delay.Seconds()
+	ev.Error = err.Error()
+	ev.ErrorKind = string(gherr.KindOf(err))
+	p.send(ev)
+}
+
+// FailedOver reports that the fetch moved from one client to another after err
+func (p *progressReporter) FailedOver(from, to string, err error) {
+	if p == nil {
+		return
+	}
+	p.clientKey = to
+	ev := p.event(session.EventFailover)
+	ev.PreviousClient = from
+	ev.Error = err.Error()
+	ev.ErrorKind = string(gherr.KindOf(err))
+	p.send(ev)
+}
+
+func (p *progressReporter) event(eventType string) session.Event {
+	ev := session.Event{
+		Topic:     session.Topic(p.metric, p.key),
+		Type:      eventType,
+		Metric:    p.metric,
+		Client:    p.clientKey,
+		Total:     p.total,
+		PagesDone: p.pagesDone,
+	}
+
+	if p.metric != session.MetricNewRepos && p.metric != session.MetricNewPRs {
+		ev.Repo = p.key
+	}
+
+	if p.total > 0 {
+		ev.TotalPages = (p.total + config.GitHubPageSize - 1) / config.GitHubPageSize
+	}
+
+	// Extrapolate the time per page so far to the pages left
+	if eventType == session.EventProgress && ev.TotalPages > p.pagesDone && p.pagesDone > 0 {
+		perPage := time.Since(p.startedAt) / time.Duration(p.pagesDone)
+		ev.ETASeconds = int((perPage * time.Duration(ev.TotalPages-p.pagesDone)).Seconds())
+	}
+
+	return ev
+}
+
+func (p *progressReporter) send(ev session.Event) {
+	p.progressHub.Publish(ev.Topic, ev)
+}
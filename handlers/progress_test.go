@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressReporterEvents(t *testing.T) {
+	hub := session.NewHub(10)
+	watching := hub.Subscribe(session.Topic(session.MetricStars, "helm/helm"))
+	other := hub.Subscribe(session.Topic(session.MetricStars, "other/repo"))
+
+	p := newProgressReporter(hub, "stars", "helm/helm", "PAT")
+	p.SetTotal(1000)
+
+	p.Started()
+	p.startedAt = time.Now().Add(-2 * time.Second)
+	p.Progress(2)
+	p.Finished(errors.New("API rate limit exceeded for user ID 123."))
+
+	started := <-watching.Events()
+	assert.Equal(t, session.EventStarted, started.Type)
+	assert.Equal(t, "stars:helm/helm", started.Topic)
+	assert.Equal(t, "helm/helm", started.Repo)
+	assert.Equal(t, "stars", started.Metric)
+	assert.Equal(t, "PAT", started.Client)
+	assert.Equal(t, 10, started.TotalPages)
+
+	progress := <-watching.Events()
+	assert.Equal(t, session.EventProgress, progress.Type)
+	assert.Equal(t, 2, progress.PagesDone)
+	// 1 second per page so far, 8 pages left
+	assert.InDelta(t, 8, progress.ETASeconds, 1)
+
+	failed := <-watching.Events()
+	assert.Equal(t, session.EventFailed, failed.Type)
+	assert.Equal(t, "API rate limit exceeded for user ID 123.", failed.Error)
+	assert.Equal(t, "rate_limited", failed.ErrorKind)
+
+	assert.Empty(t, other.Events())
+}
+
+func TestProgressReporterCountsPartialPage(t *testing.T) {
+	hub := session.NewHub(10)
+	watching := hub.Subscribe(session.Topic(session.MetricStars, "helm/helm"))
+
+	p := newProgressReporter(hub, "stars", "helm/helm", "PAT")
+	p.SetTotal(250)
+	p.Started()
+
+	// 100 + 100 + 50
+	assert.Equal(t, 3, (<-watching.Events()).TotalPages)
+}
+
+func TestNilProgressReporter(t *testing.T) {
+	var p *progressReporter
+	assert.NotPanics(t, func() {
+		p.SetTotal(10)
+		p.Started()
+		p.Progress(1)
+		p.Finished(nil)
+	})
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// graphQLResource is the quota of the GraphQL API, the one the repostats calls count against
+const graphQLResource = "graphql"
+
+// ObserveRateLimit returns the rate limit observer of the client key, to pass to utils.NewClientWithPAT.
+// Every GitHub response then updates the rate limit the ClientSelector works with, and the rate limit metrics.
+func ObserveRateLimit(key string) func(utils.RateLimitReading) {
+	return func(reading utils.RateLimitReading) {
+		rateLimitMetrics.record(key, reading)
+		if reading.Resource == "" || reading.Resource == graphQLResource {
+			if cost := globalClientSelector.observeRateLimit(key, reading, time.Now()); cost > 0 {
+				rateLimitMetrics.spend(key, cost)
+			}
+		}
+	}
+}
+
+// observeRateLimit caches the rate limit read from a response, unless a response sent later in the same
+// rate limit window was already seen. It returns the points spent since the previous reading.
+func (cs *ClientSelector) observeRateLimit(key string, reading utils.RateLimitReading, now time.Time) int {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cost := 0
+	if info, ok := cs.rateLimits[key]; ok && info.ResetAt.Equal(reading.ResetAt) {
+		// Concurrent responses can arrive out of order, the remaining count only goes down within a window
+		if reading.Remaining > info.Remaining {
+			return 0
+		}
+		cost = info.Remaining - reading.Remaining
+	}
+
+	cs.rateLimits[key] = &ClientRateLimitInfo{
+		Remaining: reading.Remaining,
+		Limit:     reading.Limit,
+		ResetAt:   reading.ResetAt,
+		UpdatedAt: now,
+	}
+	return cost
+}
+
+var meter = otel.Meter("github.com/emanuelef/gh-repo-stats-server/handlers")
+
+// rateLimitMetrics exports the last rate limit read for every client and resource, and the GraphQL points spent
+var rateLimitMetrics = newRateLimitGauges()
+
+type rateLimitKey struct {
+	client   string
+	resource string
+}
+
+type rateLimitGauges struct {
+	mu       sync.Mutex
+	readings map[rateLimitKey]utils.RateLimitReading
+
+	remaining metric.Int64ObservableGauge
+	limit     metric.Int64ObservableGauge
+	used      metric.Int64ObservableGauge
+	reset     metric.Float64ObservableGauge
+	spent     metric.Int64Counter
+}
+
+func newRateLimitGauges() *rateLimitGauges {
+	g := &rateLimitGauges{readings: make(map[rateLimitKey]utils.RateLimitReading)}
+
+	var err error
+	if g.remaining, err = meter.Int64ObservableGauge("github.ratelimit.remaining",
+		metric.WithDescription("Requests or GraphQL points left to the client in the current window"),
+		metric.WithUnit("{point}")); err != nil {
+		log.Printf("Error creating rate limit metrics: %v", err)
+		return g
+	}
+	if g.limit, err = meter.Int64ObservableGauge("github.ratelimit.limit",
+		metric.WithDescription("Requests or GraphQL points allowed to the client per window"),
+		metric.WithUnit("{point}")); err != nil {
+		log.Printf("Error creating rate limit metrics: %v", err)
+		return g
+	}
+	if g.used, err = meter.Int64ObservableGauge("github.ratelimit.used",
+		metric.WithDescription("Requests or GraphQL points used by the client in the current window"),
+		metric.WithUnit("{point}")); err != nil {
+		log.Printf("Error creating rate limit metrics: %v", err)
+		return g
+	}
+	if g.reset, err = meter.Float64ObservableGauge("github.ratelimit.reset",
+		metric.WithDescription("Time until the rate limit window of the client resets"),
+		metric.WithUnit("s")); err != nil {
+		log.Printf("Error creating rate limit metrics: %v", err)
+		return g
+	}
+
+	if g.spent, err = meter.Int64Counter("github.ratelimit.spent",
+		metric.WithDescription("GraphQL points spent by the client, from the remaining count of consecutive responses"),
+		metric.WithUnit("{point}")); err != nil {
+		log.Printf("Error creating rate limit metrics: %v", err)
+		return g
+	}
+
+	if _, err := meter.RegisterCallback(g.observe, g.remaining, g.limit, g.used, g.reset); err != nil {
+		log.Printf("Error registering rate limit metrics: %v", err)
+	}
+	return g
+}
+
+func (g *rateLimitGauges) record(client string, reading utils.RateLimitReading) {
+	resource := reading.Resource
+	if resource == "" {
+		resource = graphQLResource
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := rateLimitKey{client: client, resource: resource}
+	if last, ok := g.readings[key]; ok && last.ResetAt.Equal(reading.ResetAt) && last.Remaining < reading.Remaining {
+		return
+	}
+	g.readings[key] = reading
+}
+
+func (g *rateLimitGauges) spend(client string, points int) {
+	if g.spent == nil {
+		return
+	}
+	g.spent.Add(context.Background(), int64(points), metric.WithAttributes(attribute.String("github.client", client)))
+}
+
+func (g *rateLimitGauges) observe(_ context.Context, o metric.Observer) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	for key, reading := range g.readings {
+		// Nothing is known about a window that reset without any request since, e.g. of a removed token
+		if now.After(reading.ResetAt) {
+			delete(g.readings, key)
+			continue
+		}
+		attrs := metric.WithAttributes(
+			attribute.String("github.client", key.client),
+			attribute.String("github.ratelimit.resource", key.resource),
+		)
+		o.ObserveInt64(g.remaining, int64(reading.Remaining), attrs)
+		o.ObserveInt64(g.limit, int64(reading.Limit), attrs)
+		o.ObserveInt64(g.used, int64(reading.Used), attrs)
+		o.ObserveFloat64(g.reset, max(reading.ResetAt.Sub(now).Seconds(), 0), attrs)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveRateLimit(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+	resetAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	observe := ObserveRateLimit("PAT")
+
+	observe(utils.RateLimitReading{Limit: 5000, Remaining: 4000, ResetAt: resetAt, Resource: "graphql"})
+	assert.Equal(t, 4000, globalClientSelector.GetClientStats()["PAT"].Remaining)
+
+	// A response overtaken by a later one doesn't bring the remaining count back up
+	observe(utils.RateLimitReading{Limit: 5000, Remaining: 4100, ResetAt: resetAt, Resource: "graphql"})
+	assert.Equal(t, 4000, globalClientSelector.GetClientStats()["PAT"].Remaining)
+
+	// The REST quota is tracked apart and doesn't drive the selection
+	observe(utils.RateLimitReading{Limit: 5000, Remaining: 12, ResetAt: resetAt, Resource: "core"})
+	assert.Equal(t, 4000, globalClientSelector.GetClientStats()["PAT"].Remaining)
+
+	// A new window starts over
+	observe(utils.RateLimitReading{Limit: 5000, Remaining: 4999, ResetAt: resetAt.Add(time.Hour), Resource: "graphql"})
+	assert.Equal(t, 4999, globalClientSelector.GetClientStats()["PAT"].Remaining)
+}
+
+func TestObserveRateLimitCost(t *testing.T) {
+	cs := NewClientSelector()
+	resetAt := time.Now().Add(time.Hour)
+	now := time.Now()
+
+	assert.Zero(t, cs.observeRateLimit("PAT", utils.RateLimitReading{Limit: 5000, Remaining: 4000, ResetAt: resetAt}, now))
+	assert.Equal(t, 25, cs.observeRateLimit("PAT", utils.RateLimitReading{Limit: 5000, Remaining: 3975, ResetAt: resetAt}, now))
+	assert.Zero(t, cs.observeRateLimit("PAT", utils.RateLimitReading{Limit: 5000, Remaining: 3990, ResetAt: resetAt}, now))
+}
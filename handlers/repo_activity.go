@@ -4,41 +4,38 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"math/rand"
 	"net/url"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
-	cache "github.com/Code-Hex/go-generics-cache"
-	"github.com/emanuelef/gh-repo-stats-server/config"
+	"github.com/emanuelef/gh-repo-stats-server/cache"
+	"github.com/emanuelef/gh-repo-stats-server/inflight"
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
 	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
 	"github.com/emanuelef/gh-repo-stats-server/types"
 	"github.com/emanuelef/github-repo-activity-stats/repostats"
 	"github.com/emanuelef/github-repo-activity-stats/stats"
 	"github.com/gofiber/fiber/v2"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
-	"golang.org/x/sync/errgroup"
 )
 
 // AllIssuesHandler handles the /allIssues endpoint
 func AllIssuesHandler(
-	ghStatClients map[string]*repostats.ClientGQL,
-	cacheIssues *cache.Cache[string, types.IssuesWithStatsResponse],
-	onGoingIssues map[string]bool,
-	currentSessions *session.SessionsLock,
+	clientPool *tokens.Pool,
+	cacheIssues *cache.Cache[types.IssuesWithStatsResponse],
+	onGoingIssues *inflight.Group[types.IssuesWithStatsResponse],
+	progressHub *session.Hub,
 	ctx context.Context,
 ) fiber.Handler {
-	return func(c *fiber.Ctx) error {
+	return withGitHubClient(ctx, clientPool, func(c *fiber.Ctx, calls *githubCalls) error {
 		param := c.Query("repo")
 		forceRefetch := c.Query("forceRefetch", "false") == "true"
-		overrideClient := c.Query("client", "")
-
-		clientKey, client := SelectBestClient(ctx, ghStatClients, overrideClient)
-		if client == nil {
-			return c.Status(500).SendString("No GitHub API client available")
+		aggregate := c.Query("aggregate")
+		if !validAggregate(aggregate) {
+			return c.Status(400).JSON(fiber.Map{"error": "Unsupported aggregate: " + aggregate})
 		}
 
 		repo, err := url.QueryUnescape(param)
@@ -46,7 +43,7 @@ func AllIssuesHandler(
 			return err
 		}
 
-		repo = strings.ToLower(repo)
+		repo = repoid.Normalize(repo)
 		repo = strings.Clone(repo) // Fiber's c.Query returns unsafe strings backed by a reusable buffer
 
 		ip := c.Get("X-Forwarded-For")
@@ -55,7 +52,7 @@ func AllIssuesHandler(
 		}
 
 		userAgent := c.Get("User-Agent")
-		log.Printf("Issues Request from IP: %s, Repo: %s User-Agent: %s, Client: %s\n", ip, repo, userAgent, clientKey)
+		log.Printf("Issues Request from IP: %s, Repo: %s User-Agent: %s, Client: %s\n", ip, repo, userAgent, calls.Key())
 
 		if strings.Contains(userAgent, "python-requests") {
 			return c.Status(404).SendString("Custom 404 Error: Resource not found")
@@ -70,86 +67,36 @@ func AllIssuesHandler(
 		}
 
 		if res, hit := cacheIssues.Get(repo); hit {
-			return c.JSON(res)
+			return sendSeries(c, res, "issues", res.Issues, aggregate)
 		}
 
-		if _, hit := onGoingIssues[repo]; hit {
-			return c.SendStatus(fiber.StatusNoContent)
-		}
-
-		onGoingIssues[repo] = true
-
-		updateChannel := make(chan int)
-		var allIssues []stats.IssuesPerDay
-
-		eg, localCtx := errgroup.WithContext(ctx)
-
-		eg.Go(func() error {
-			var err error
-			allIssues, err = client.GetAllIssuesHistory(localCtx, repo, updateChannel)
-			if err != nil {
-				return err
-			}
-			return nil
+		res, err, _ := onGoingIssues.Do(calls.fetchKey(repo), func() (types.IssuesWithStatsResponse, error) {
+			calls.withProgress(newProgressReporter(progressHub, session.MetricIssues, repo, calls.Key()))
+			calls.markBusy(repo)
+			return fetchAllIssues(trace.ContextWithSpan(ctx, span), calls, repo, cacheIssues)
 		})
-
-		for progress := range updateChannel {
-			wg := &sync.WaitGroup{}
-
-			for _, s := range currentSessions.Sessions {
-				wg.Add(1)
-				go func(cs *session.Session) {
-					defer wg.Done()
-					if cs.Repo == repo {
-						cs.StateChannel <- progress
-					}
-				}(s)
-			}
-			wg.Wait()
-		}
-
-		if err := eg.Wait(); err != nil {
-			delete(onGoingIssues, repo)
-			return err
-		}
-
-		res := types.IssuesWithStatsResponse{
-			Issues: allIssues,
+		if err != nil {
+			return sendGitHubError(c, calls.Key(), err)
 		}
 
-		now := time.Now()
-		nextDay := now.UTC().Truncate(24 * time.Hour).Add(config.DayCached * 24 * time.Hour)
-		durationUntilEndOfDay := nextDay.Sub(now)
-
-		cacheIssues.Set(repo, res, cache.WithExpiration(durationUntilEndOfDay))
-		delete(onGoingIssues, repo)
-
-		return c.JSON(res)
-	}
+		return sendSeries(c, res, "issues", res.Issues, aggregate)
+	})
 }
 
 // AllForksHandler handles the /allForks endpoint
 func AllForksHandler(
-	ghStatClients map[string]*repostats.ClientGQL,
-	cacheForks *cache.Cache[string, types.ForksWithStatsResponse],
-	onGoingForks map[string]bool,
-	currentSessions *session.SessionsLock,
+	clientPool *tokens.Pool,
+	cacheForks *cache.Cache[types.ForksWithStatsResponse],
+	onGoingForks *inflight.Group[types.ForksWithStatsResponse],
+	progressHub *session.Hub,
 	ctx context.Context,
 ) fiber.Handler {
-	return func(c *fiber.Ctx) error {
+	return withGitHubClient(ctx, clientPool, func(c *fiber.Ctx, calls *githubCalls) error {
 		param := c.Query("repo")
-
-		clientKeys := make([]string, 0, len(ghStatClients))
-		for k := range ghStatClients {
-			clientKeys = append(clientKeys, k)
-		}
-		randomIndex := rand.Intn(len(clientKeys))
-		clientKey := c.Query("client", clientKeys[randomIndex])
 		forceRefetch := c.Query("forceRefetch", "false") == "true"
-
-		client, ok := ghStatClients[clientKey]
-		if !ok {
-			return c.Status(404).SendString("Resource not found")
+		aggregate := c.Query("aggregate")
+		if !validAggregate(aggregate) {
+			return c.Status(400).JSON(fiber.Map{"error": "Unsupported aggregate: " + aggregate})
 		}
 
 		repo, err := url.QueryUnescape(param)
@@ -157,7 +104,7 @@ func AllForksHandler(
 			return err
 		}
 
-		repo = strings.ToLower(repo)
+		repo = repoid.Normalize(repo)
 		repo = strings.Clone(repo) // Fiber's c.Query returns unsafe strings backed by a reusable buffer
 
 		ip := c.Get("X-Forwarded-For")
@@ -181,86 +128,36 @@ func AllForksHandler(
 		}
 
 		if res, hit := cacheForks.Get(repo); hit {
-			return c.JSON(res)
-		}
-
-		if _, hit := onGoingForks[repo]; hit {
-			return c.SendStatus(fiber.StatusNoContent)
+			return sendSeries(c, res, "forks", res.Forks, aggregate)
 		}
 
-		onGoingForks[repo] = true
-
-		updateChannel := make(chan int)
-		var allForks []stats.ForksPerDay
-
-		eg, localCtx := errgroup.WithContext(ctx)
-
-		eg.Go(func() error {
-			var err error
-			allForks, err = client.GetAllForksHistory(localCtx, repo, updateChannel)
-			if err != nil {
-				return err
-			}
-			return nil
+		res, err, _ := onGoingForks.Do(calls.fetchKey(repo), func() (types.ForksWithStatsResponse, error) {
+			calls.withProgress(newProgressReporter(progressHub, session.MetricForks, repo, calls.Key()))
+			calls.markBusy(repo)
+			return fetchAllForks(trace.ContextWithSpan(ctx, span), calls, repo, cacheForks)
 		})
-
-		for progress := range updateChannel {
-			wg := &sync.WaitGroup{}
-
-			for _, s := range currentSessions.Sessions {
-				wg.Add(1)
-				go func(cs *session.Session) {
-					defer wg.Done()
-					if cs.Repo == repo {
-						cs.StateChannel <- progress
-					}
-				}(s)
-			}
-			wg.Wait()
-		}
-
-		if err := eg.Wait(); err != nil {
-			delete(onGoingForks, repo)
-			return err
-		}
-
-		res := types.ForksWithStatsResponse{
-			Forks: allForks,
+		if err != nil {
+			return sendGitHubError(c, calls.Key(), err)
 		}
 
-		now := time.Now()
-		nextDay := now.UTC().Truncate(24 * time.Hour).Add(config.DayCached * 24 * time.Hour)
-		durationUntilEndOfDay := nextDay.Sub(now)
-
-		cacheForks.Set(repo, res, cache.WithExpiration(durationUntilEndOfDay))
-		delete(onGoingForks, repo)
-
-		return c.JSON(res)
-	}
+		return sendSeries(c, res, "forks", res.Forks, aggregate)
+	})
 }
 
 // AllPRsHandler handles the /allPRs endpoint
 func AllPRsHandler(
-	ghStatClients map[string]*repostats.ClientGQL,
-	cachePRs *cache.Cache[string, types.PRsWithStatsResponse],
-	onGoingPRs map[string]bool,
-	currentSessions *session.SessionsLock,
+	clientPool *tokens.Pool,
+	cachePRs *cache.Cache[types.PRsWithStatsResponse],
+	onGoingPRs *inflight.Group[types.PRsWithStatsResponse],
+	progressHub *session.Hub,
 	ctx context.Context,
 ) fiber.Handler {
-	return func(c *fiber.Ctx) error {
+	return withGitHubClient(ctx, clientPool, func(c *fiber.Ctx, calls *githubCalls) error {
 		param := c.Query("repo")
-
-		clientKeys := make([]string, 0, len(ghStatClients))
-		for k := range ghStatClients {
-			clientKeys = append(clientKeys, k)
-		}
-		randomIndex := rand.Intn(len(clientKeys))
-		clientKey := c.Query("client", clientKeys[randomIndex])
 		forceRefetch := c.Query("forceRefetch", "false") == "true"
-
-		client, ok := ghStatClients[clientKey]
-		if !ok {
-			return c.Status(404).SendString("Resource not found")
+		aggregate := c.Query("aggregate")
+		if !validAggregate(aggregate) {
+			return c.Status(400).JSON(fiber.Map{"error": "Unsupported aggregate: " + aggregate})
 		}
 
 		repo, err := url.QueryUnescape(param)
@@ -268,7 +165,7 @@ func AllPRsHandler(
 			return err
 		}
 
-		repo = strings.ToLower(repo)
+		repo = repoid.Normalize(repo)
 		repo = strings.Clone(repo) // Fiber's c.Query returns unsafe strings backed by a reusable buffer
 
 		ip := c.Get("X-Forwarded-For")
@@ -292,86 +189,36 @@ func AllPRsHandler(
 		}
 
 		if res, hit := cachePRs.Get(repo); hit {
-			return c.JSON(res)
-		}
-
-		if _, hit := onGoingPRs[repo]; hit {
-			return c.SendStatus(fiber.StatusNoContent)
+			return sendSeries(c, res, "prs", res.PRs, aggregate)
 		}
 
-		onGoingPRs[repo] = true
-
-		updateChannel := make(chan int)
-		var allPRs []stats.PRsPerDay
-
-		eg, localCtx := errgroup.WithContext(ctx)
-
-		eg.Go(func() error {
-			var err error
-			allPRs, err = client.GetAllPRsHistory(localCtx, repo, updateChannel)
-			if err != nil {
-				return err
-			}
-			return nil
+		res, err, _ := onGoingPRs.Do(calls.fetchKey(repo), func() (types.PRsWithStatsResponse, error) {
+			calls.withProgress(newProgressReporter(progressHub, session.MetricPRs, repo, calls.Key()))
+			calls.markBusy(repo)
+			return fetchAllPRs(trace.ContextWithSpan(ctx, span), calls, repo, cachePRs)
 		})
-
-		for progress := range updateChannel {
-			wg := &sync.WaitGroup{}
-
-			for _, s := range currentSessions.Sessions {
-				wg.Add(1)
-				go func(cs *session.Session) {
-					defer wg.Done()
-					if cs.Repo == repo {
-						cs.StateChannel <- progress
-					}
-				}(s)
-			}
-			wg.Wait()
-		}
-
-		if err := eg.Wait(); err != nil {
-			delete(onGoingPRs, repo)
-			return err
-		}
-
-		res := types.PRsWithStatsResponse{
-			PRs: allPRs,
+		if err != nil {
+			return sendGitHubError(c, calls.Key(), err)
 		}
 
-		now := time.Now()
-		nextDay := now.UTC().Truncate(24 * time.Hour).Add(config.DayCached * 24 * time.Hour)
-		durationUntilEndOfDay := nextDay.Sub(now)
-
-		cachePRs.Set(repo, res, cache.WithExpiration(durationUntilEndOfDay))
-		delete(onGoingPRs, repo)
-
-		return c.JSON(res)
-	}
+		return sendSeries(c, res, "prs", res.PRs, aggregate)
+	})
 }
 
 // AllCommitsHandler handles the /allCommits endpoint
 func AllCommitsHandler(
-	ghStatClients map[string]*repostats.ClientGQL,
-	cacheCommits *cache.Cache[string, types.CommitsWithStatsResponse],
-	onGoingCommits map[string]bool,
-	currentSessions *session.SessionsLock,
+	clientPool *tokens.Pool,
+	cacheCommits *cache.Cache[types.CommitsWithStatsResponse],
+	onGoingCommits *inflight.Group[types.CommitsWithStatsResponse],
+	progressHub *session.Hub,
 	ctx context.Context,
 ) fiber.Handler {
-	return func(c *fiber.Ctx) error {
+	return withGitHubClient(ctx, clientPool, func(c *fiber.Ctx, calls *githubCalls) error {
 		param := c.Query("repo")
-
-		clientKeys := make([]string, 0, len(ghStatClients))
-		for k := range ghStatClients {
-			clientKeys = append(clientKeys, k)
-		}
-		randomIndex := rand.Intn(len(clientKeys))
-		clientKey := c.Query("client", clientKeys[randomIndex])
 		forceRefetch := c.Query("forceRefetch", "false") == "true"
-
-		client, ok := ghStatClients[clientKey]
-		if !ok {
-			return c.Status(404).SendString("Resource not found")
+		aggregate := c.Query("aggregate")
+		if !validAggregate(aggregate) {
+			return c.Status(400).JSON(fiber.Map{"error": "Unsupported aggregate: " + aggregate})
 		}
 
 		repo, err := url.QueryUnescape(param)
@@ -379,7 +226,7 @@ func AllCommitsHandler(
 			return err
 		}
 
-		repo = strings.ToLower(repo)
+		repo = repoid.Normalize(repo)
 		repo = strings.Clone(repo) // Fiber's c.Query returns unsafe strings backed by a reusable buffer
 
 		ip := c.Get("X-Forwarded-For")
@@ -403,88 +250,36 @@ func AllCommitsHandler(
 		}
 
 		if res, hit := cacheCommits.Get(repo); hit {
-			return c.JSON(res)
+			return sendSeries(c, res, "commits", res.Commits, aggregate)
 		}
 
-		if _, hit := onGoingCommits[repo]; hit {
-			return c.SendStatus(fiber.StatusNoContent)
-		}
-
-		onGoingCommits[repo] = true
-
-		updateChannel := make(chan int)
-		var allCommits []stats.CommitsPerDay
-		var defaultBranch string
-
-		eg, localCtx := errgroup.WithContext(ctx)
-
-		eg.Go(func() error {
-			var err error
-			allCommits, defaultBranch, err = client.GetAllCommitsHistory(localCtx, repo, updateChannel)
-			if err != nil {
-				return err
-			}
-			return nil
+		res, err, _ := onGoingCommits.Do(calls.fetchKey(repo), func() (types.CommitsWithStatsResponse, error) {
+			calls.withProgress(newProgressReporter(progressHub, session.MetricCommits, repo, calls.Key()))
+			calls.markBusy(repo)
+			return fetchAllCommits(trace.ContextWithSpan(ctx, span), calls, repo, cacheCommits)
 		})
-
-		for progress := range updateChannel {
-			wg := &sync.WaitGroup{}
-
-			for _, s := range currentSessions.Sessions {
-				wg.Add(1)
-				go func(cs *session.Session) {
-					defer wg.Done()
-					if cs.Repo == repo {
-						cs.StateChannel <- progress
-					}
-				}(s)
-			}
-			wg.Wait()
-		}
-
-		if err := eg.Wait(); err != nil {
-			delete(onGoingCommits, repo)
-			return err
-		}
-
-		res := types.CommitsWithStatsResponse{
-			Commits:       allCommits,
-			DefaultBranch: defaultBranch,
+		if err != nil {
+			return sendGitHubError(c, calls.Key(), err)
 		}
 
-		now := time.Now()
-		nextDay := now.UTC().Truncate(24 * time.Hour).Add(config.DayCached * 24 * time.Hour)
-		durationUntilEndOfDay := nextDay.Sub(now)
-
-		cacheCommits.Set(repo, res, cache.WithExpiration(durationUntilEndOfDay))
-		delete(onGoingCommits, repo)
-
-		return c.JSON(res)
-	}
+		return sendSeries(c, res, "commits", res.Commits, aggregate)
+	})
 }
 
 // AllContributorsHandler handles the /allContributors endpoint
 func AllContributorsHandler(
-	ghStatClients map[string]*repostats.ClientGQL,
-	cacheContributors *cache.Cache[string, types.ContributorsWithStatsResponse],
-	onGoingContributors map[string]bool,
-	currentSessions *session.SessionsLock,
+	clientPool *tokens.Pool,
+	cacheContributors *cache.Cache[types.ContributorsWithStatsResponse],
+	onGoingContributors *inflight.Group[types.ContributorsWithStatsResponse],
+	progressHub *session.Hub,
 	ctx context.Context,
 ) fiber.Handler {
-	return func(c *fiber.Ctx) error {
+	return withGitHubClient(ctx, clientPool, func(c *fiber.Ctx, calls *githubCalls) error {
 		param := c.Query("repo")
-
-		clientKeys := make([]string, 0, len(ghStatClients))
-		for k := range ghStatClients {
-			clientKeys = append(clientKeys, k)
-		}
-		randomIndex := rand.Intn(len(clientKeys))
-		clientKey := c.Query("client", clientKeys[randomIndex])
 		forceRefetch := c.Query("forceRefetch", "false") == "true"
-
-		client, ok := ghStatClients[clientKey]
-		if !ok {
-			return c.Status(404).SendString("Resource not found")
+		aggregate := c.Query("aggregate")
+		if !validAggregate(aggregate) {
+			return c.Status(400).JSON(fiber.Map{"error": "Unsupported aggregate: " + aggregate})
 		}
 
 		repo, err := url.QueryUnescape(param)
@@ -492,7 +287,7 @@ func AllContributorsHandler(
 			return err
 		}
 
-		repo = strings.ToLower(repo)
+		repo = repoid.Normalize(repo)
 		repo = strings.Clone(repo) // Fiber's c.Query returns unsafe strings backed by a reusable buffer
 
 		ip := c.Get("X-Forwarded-For")
@@ -516,90 +311,36 @@ func AllContributorsHandler(
 		}
 
 		if res, hit := cacheContributors.Get(repo); hit {
-			return c.JSON(res)
+			return sendSeries(c, res, "contributors", res.Contributors, aggregate)
 		}
 
-		if _, hit := onGoingContributors[repo]; hit {
-			return c.SendStatus(fiber.StatusNoContent)
-		}
-
-		onGoingContributors[repo] = true
-
-		updateChannel := make(chan int)
-		var allContributors []stats.NewContributorsPerDay
-
-		eg, localCtx := errgroup.WithContext(ctx)
-
-		eg.Go(func() error {
-			var err error
-			allContributors, err = client.GetNewContributorsHistory(localCtx, repo, updateChannel)
-			if err != nil {
-				return err
-			}
-			return nil
+		res, err, _ := onGoingContributors.Do(calls.fetchKey(repo), func() (types.ContributorsWithStatsResponse, error) {
+			calls.withProgress(newProgressReporter(progressHub, session.MetricContributors, repo, calls.Key()))
+			calls.markBusy(repo)
+			return fetchAllContributors(trace.ContextWithSpan(ctx, span), calls, repo, cacheContributors)
 		})
-
-		for progress := range updateChannel {
-			wg := &sync.WaitGroup{}
-
-			for _, s := range currentSessions.Sessions {
-				wg.Add(1)
-				go func(cs *session.Session) {
-					defer wg.Done()
-					if cs.Repo == repo {
-						cs.StateChannel <- progress
-					}
-				}(s)
-			}
-			wg.Wait()
-		}
-
-		if err := eg.Wait(); err != nil {
-			delete(onGoingContributors, repo)
-			return err
-		}
-
-		res := types.ContributorsWithStatsResponse{
-			Contributors: allContributors,
+		if err != nil {
+			return sendGitHubError(c, calls.Key(), err)
 		}
 
-		now := time.Now()
-		nextDay := now.UTC().Truncate(24 * time.Hour).Add(config.DayCached * 24 * time.Hour)
-		durationUntilEndOfDay := nextDay.Sub(now)
-
-		cacheContributors.Set(repo, res, cache.WithExpiration(durationUntilEndOfDay))
-		delete(onGoingContributors, repo)
-
-		return c.JSON(res)
-	}
+		return sendSeries(c, res, "contributors", res.Contributors, aggregate)
+	})
 }
 
 // NewReposHandler handles the /newRepos endpoint
 func NewReposHandler(
-	ghStatClients map[string]*repostats.ClientGQL,
-	cacheNewRepos *cache.Cache[string, types.NewReposWithStatsResponse],
-	onGoingNewRepos map[string]bool,
-	currentSessions *session.SessionsLock,
+	clientPool *tokens.Pool,
+	cacheNewRepos *cache.Cache[types.NewReposWithStatsResponse],
+	onGoingNewRepos *inflight.Group[types.NewReposWithStatsResponse],
+	progressHub *session.Hub,
 	ctx context.Context,
 ) fiber.Handler {
-	return func(c *fiber.Ctx) error {
+	return withGitHubClient(ctx, clientPool, func(c *fiber.Ctx, calls *githubCalls) error {
 		startDate := c.Query("startDate")
 		endDate := c.Query("endDate")
 		includeForksStr := c.Query("includeForks", "false")
-
-		clientKeys := make([]string, 0, len(ghStatClients))
-		for k := range ghStatClients {
-			clientKeys = append(clientKeys, k)
-		}
-		randomIndex := rand.Intn(len(clientKeys))
-		clientKey := c.Query("client", clientKeys[randomIndex])
 		forceRefetch := c.Query("forceRefetch", "false") == "true"
 
-		client, ok := ghStatClients[clientKey]
-		if !ok {
-			return c.Status(404).SendString("Resource not found")
-		}
-
 		parsedStartDate, err := time.Parse("2006-01-02", startDate)
 		if err != nil {
 			return c.Status(400).SendString("Invalid start date format")
@@ -617,6 +358,12 @@ func NewReposHandler(
 
 		cacheKey := fmt.Sprintf("%s_%s_%t", startDate, endDate, includeForks)
 
+		// Progress is published on newrepos:<start>_<end>, or newrepos:<start>_<end>_forks when forks are included
+		topicKey := fmt.Sprintf("%s_%s", startDate, endDate)
+		if includeForks {
+			topicKey += "_forks"
+		}
+
 		ip := c.Get("X-Forwarded-For")
 		if ip == "" {
 			ip = c.IP()
@@ -643,86 +390,45 @@ func NewReposHandler(
 			return c.JSON(res)
 		}
 
-		if _, hit := onGoingNewRepos[cacheKey]; hit {
-			return c.SendStatus(fiber.StatusNoContent)
-		}
-
-		onGoingNewRepos[cacheKey] = true
-
-		updateChannel := make(chan int)
-		var newRepos []stats.NewReposPerDay
-
-		eg, localCtx := errgroup.WithContext(ctx)
-
-		eg.Go(func() error {
-			var err error
-			newRepos, err = client.GetNewReposCountHistory(localCtx, parsedStartDate, parsedEndDate, includeForks, updateChannel)
+		res, err, _ := onGoingNewRepos.Do(calls.fetchKey(cacheKey), func() (types.NewReposWithStatsResponse, error) {
+			calls.withProgress(newProgressReporter(progressHub, session.MetricNewRepos, topicKey, calls.Key()))
+			calls.markBusy(cacheKey)
+			newRepos, err := runWithProgress(trace.ContextWithSpan(ctx, span), calls, func(ctx context.Context, client *repostats.ClientGQL, updateChannel chan int) ([]stats.NewReposPerDay, error) {
+				return client.GetNewReposCountHistory(ctx, parsedStartDate, parsedEndDate, includeForks, updateChannel)
+			})
 			if err != nil {
-				return err
+				return types.NewReposWithStatsResponse{}, err
 			}
-			return nil
-		})
 
-		for progress := range updateChannel {
-			wg := &sync.WaitGroup{}
-
-			for _, s := range currentSessions.Sessions {
-				wg.Add(1)
-				go func(cs *session.Session) {
-					defer wg.Done()
-					if cs.Repo == cacheKey {
-						cs.StateChannel <- progress
-					}
-				}(s)
+			res := types.NewReposWithStatsResponse{
+				NewRepos: newRepos,
 			}
-			wg.Wait()
-		}
 
-		if err := eg.Wait(); err != nil {
-			delete(onGoingNewRepos, cacheKey)
-			return err
-		}
+			cacheNewRepos.Set(cacheKey, res, cacheExpiration())
 
-		res := types.NewReposWithStatsResponse{
-			NewRepos: newRepos,
+			return res, nil
+		})
+		if err != nil {
+			return sendGitHubError(c, calls.Key(), err)
 		}
 
-		now := time.Now()
-		nextDay := now.UTC().Truncate(24 * time.Hour).Add(config.DayCached * 24 * time.Hour)
-		durationUntilEndOfDay := nextDay.Sub(now)
-
-		cacheNewRepos.Set(cacheKey, res, cache.WithExpiration(durationUntilEndOfDay))
-		delete(onGoingNewRepos, cacheKey)
-
 		return c.JSON(res)
-	}
+	})
 }
 
 // NewPRsHandler handles the /newPRs endpoint
 func NewPRsHandler(
-	ghStatClients map[string]*repostats.ClientGQL,
-	cacheNewPRs *cache.Cache[string, types.NewPRsWithStatsResponse],
-	onGoingNewPRs map[string]bool,
-	currentSessions *session.SessionsLock,
+	clientPool *tokens.Pool,
+	cacheNewPRs *cache.Cache[types.NewPRsWithStatsResponse],
+	onGoingNewPRs *inflight.Group[types.NewPRsWithStatsResponse],
+	progressHub *session.Hub,
 	ctx context.Context,
 ) fiber.Handler {
-	return func(c *fiber.Ctx) error {
+	return withGitHubClient(ctx, clientPool, func(c *fiber.Ctx, calls *githubCalls) error {
 		startDate := c.Query("startDate")
 		endDate := c.Query("endDate")
-
-		clientKeys := make([]string, 0, len(ghStatClients))
-		for k := range ghStatClients {
-			clientKeys = append(clientKeys, k)
-		}
-		randomIndex := rand.Intn(len(clientKeys))
-		clientKey := c.Query("client", clientKeys[randomIndex])
 		forceRefetch := c.Query("forceRefetch", "false") == "true"
 
-		client, ok := ghStatClients[clientKey]
-		if !ok {
-			return c.Status(404).SendString("Resource not found")
-		}
-
 		parsedStartDate, err := time.Parse("2006-01-02", startDate)
 		if err != nil {
 			return c.Status(400).SendString("Invalid start date format")
@@ -761,57 +467,28 @@ func NewPRsHandler(
 			return c.JSON(res)
 		}
 
-		if _, hit := onGoingNewPRs[cacheKey]; hit {
-			return c.SendStatus(fiber.StatusNoContent)
-		}
-
-		onGoingNewPRs[cacheKey] = true
-
-		updateChannel := make(chan int)
-		var newPRs []stats.NewPRsPerDay
-
-		eg, localCtx := errgroup.WithContext(ctx)
-
-		eg.Go(func() error {
-			var err error
-			newPRs, err = client.GetNewPRsCountHistory(localCtx, parsedStartDate, parsedEndDate, updateChannel)
+		res, err, _ := onGoingNewPRs.Do(calls.fetchKey(cacheKey), func() (types.NewPRsWithStatsResponse, error) {
+			calls.withProgress(newProgressReporter(progressHub, session.MetricNewPRs, fmt.Sprintf("%s_%s", startDate, endDate), calls.Key()))
+			calls.markBusy(cacheKey)
+			newPRs, err := runWithProgress(trace.ContextWithSpan(ctx, span), calls, func(ctx context.Context, client *repostats.ClientGQL, updateChannel chan int) ([]stats.NewPRsPerDay, error) {
+				return client.GetNewPRsCountHistory(ctx, parsedStartDate, parsedEndDate, updateChannel)
+			})
 			if err != nil {
-				return err
+				return types.NewPRsWithStatsResponse{}, err
 			}
-			return nil
-		})
 
-		for progress := range updateChannel {
-			wg := &sync.WaitGroup{}
-
-			for _, s := range currentSessions.Sessions {
-				wg.Add(1)
-				go func(cs *session.Session) {
-					defer wg.Done()
-					if cs.Repo == cacheKey {
-						cs.StateChannel <- progress
-					}
-				}(s)
+			res := types.NewPRsWithStatsResponse{
+				NewPRs: newPRs,
 			}
-			wg.Wait()
-		}
 
-		if err := eg.Wait(); err != nil {
-			delete(onGoingNewPRs, cacheKey)
-			return err
-		}
+			cacheNewPRs.Set(cacheKey, res, cacheExpiration())
 
-		res := types.NewPRsWithStatsResponse{
-			NewPRs: newPRs,
+			return res, nil
+		})
+		if err != nil {
+			return sendGitHubError(c, calls.Key(), err)
 		}
 
-		now := time.Now()
-		nextDay := now.UTC().Truncate(24 * time.Hour).Add(config.DayCached * 24 * time.Hour)
-		durationUntilEndOfDay := nextDay.Sub(now)
-
-		cacheNewPRs.Set(cacheKey, res, cache.WithExpiration(durationUntilEndOfDay))
-		delete(onGoingNewPRs, cacheKey)
-
 		return c.JSON(res)
-	}
+	})
 }
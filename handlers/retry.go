@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/config"
+	"github.com/emanuelef/gh-repo-stats-server/gherr"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// retryPolicy says how often and after how long a failed GitHub call is tried again
+type retryPolicy struct {
+	attempts  int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+// githubRetry is the policy of every GitHub call made by the handlers, tests shorten its delays
+var githubRetry = retryPolicy{
+	attempts:  config.GitHubRetryAttempts,
+	baseDelay: config.GitHubRetryBaseDelay,
+	maxDelay:  config.GitHubRetryMaxDelay,
+}
+
+// delay returns the wait before the retry-th retry: exponential backoff with jitter,
+// never shorter than what GitHub asked for
+func (p retryPolicy) delay(retry int, retryAfter time.Duration) time.Duration {
+	d := p.baseDelay << min(retry-1, 20)
+	if d > p.maxDelay || d <= 0 {
+		d = p.maxDelay
+	}
+	// Jitter over the upper half keeps fetches that failed together from retrying together
+	d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	if retryAfter > d {
+		d = retryAfter
+	}
+	return d
+}
+
+// githubCalls makes the GitHub calls of one fetch. Transient failures (5xx, timeouts, secondary rate limits)
+// are retried with backoff, and a token that runs dry or is rejected is swapped for another client of the pool.
+// Every attempt is reported to the circuit breakers, and retries and failovers to the SSE sessions and the span in ctx.
+type githubCalls struct {
+	// clients are the ones to fail over to, nil when the caller asked for a specific client
+	clients   map[string]*repostats.ClientGQL
+	clientKey string
+	client    *repostats.ClientGQL
+	progress  *progressReporter
+	// ephemeral is set for the client of a user token, which isn't part of the pool:
+	// its results aren't reported to the breakers and it's never marked busy
+	ephemeral bool
+
+	tried map[string]bool
+	// busyWith is what the clients are marked busy with, empty when they aren't
+	busyWith string
+	busy     []string
+}
+
+func newGitHubCalls(
+	clients map[string]*repostats.ClientGQL,
+	clientKey string,
+	client *repostats.ClientGQL,
+	progress *progressReporter,
+) *githubCalls {
+	return &githubCalls{
+		clients:   clients,
+		clientKey: clientKey,
+		client:    client,
+		progress:  progress,
+		tried:     map[string]bool{clientKey: true},
+	}
+}
+
+// newUserCalls returns the calls of a client built from the token of a request, there's nothing to fail over to
+func newUserCalls(client *repostats.ClientGQL) *githubCalls {
+	g := newGitHubCalls(nil, userClientKey, client, nil)
+	g.ephemeral = true
+	return g
+}
+
+// failoverClients returns the clients a fetch may fail over to, none when the caller asked for a specific client
+func failoverClients(ghStatClients map[string]*repostats.ClientGQL, overrideKey string) map[string]*repostats.ClientGQL {
+	if overrideKey != "" {
+		return nil
+	}
+	return ghStatClients
+}
+
+// withProgress makes g report its retries and failovers to progress
+func (g *githubCalls) withProgress(progress *progressReporter) *githubCalls {
+	g.progress = progress
+	return g
+}
+
+// detached returns calls starting with the current client of g, for work that outlives the request.
+// They are released on their own. Never detach the calls of a user token, the token is only used for its request.
+func (g *githubCalls) detached() *githubCalls {
+	return newGitHubCalls(g.clients, g.clientKey, g.client, nil)
+}
+
+// fetchKey returns the key to share the fetch of key under in an inflight group. The fetches of a user token
+// get a key of their own: the token must not serve other callers, nor run a fetch that outlives its request.
+func (g *githubCalls) fetchKey(key string) string {
+	if g.ephemeral {
+		return fmt.Sprintf("%s@%s-%p", key, userClientKey, g)
+	}
+	return key
+}
+
+// Key returns the client currently used, which changes after a failover
+func (g *githubCalls) Key() string {
+	return g.clientKey
+}
+
+// markBusy marks the client busy with a long-running operation on what, and every client failed over to after it.
+// release marks them idle again.
+func (g *githubCalls) markBusy(what string) {
+	if g.ephemeral {
+		return
+	}
+	g.busyWith = what
+	MarkClientBusy(g.clientKey, what)
+	g.busy = append(g.busy, g.clientKey)
+}
+
+func (g *githubCalls) release() {
+	for _, key := range g.busy {
+		MarkClientIdle(key)
+	}
+	g.busy = nil
+}
+
+// callGitHub makes call with the current client of g, retrying and failing over as needed.
+// The error of the last attempt is returned once retrying doesn't help.
+func callGitHub[T any](ctx context.Context, g *githubCalls, call func(ctx context.Context, client *repostats.ClientGQL) (T, error)) (T, error) {
+	retries := 0
+	for {
+		if !g.ephemeral {
+			startClientProbe(g.clientKey)
+		}
+		res, err := call(ctx, g.client)
+		if !g.ephemeral {
+			ReportClientResult(g.clientKey, err)
+		}
+		if err == nil || ctx.Err() != nil {
+			return res, err
+		}
+
+		ghErr := gherr.Classify(err)
+		switch ghErr.Kind {
+		case gherr.KindRateLimited, gherr.KindUnauthorized:
+			// Retrying won't bring the token back any time soon
+			if g.failover(ctx, ghErr) {
+				continue
+			}
+			return res, err
+		case gherr.KindSecondaryLimit:
+			// The token is throttled, another one is quicker than waiting
+			if g.failover(ctx, ghErr) {
+				continue
+			}
+		case gherr.KindUpstream, gherr.KindTimeout:
+		default:
+			return res, err
+		}
+
+		retries++
+		if retries >= githubRetry.attempts {
+			return res, err
+		}
+
+		delay := githubRetry.delay(retries, ghErr.RetryAfter)
+		log.Printf("GitHub call with client %s failed (%s), retry %d in %v: %v", g.clientKey, ghErr.Kind, retries, delay, err)
+		g.progress.Retrying(retries, delay, ghErr)
+		trace.SpanFromContext(ctx).AddEvent("github.retry", trace.WithAttributes(
+			attribute.String("github.client", g.clientKey),
+			attribute.Int("retry.attempt", retries),
+			attribute.Int64("retry.delay_ms", delay.Milliseconds()),
+			attribute.String("error.kind", string(ghErr.Kind)),
+		))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return res, err
+		case <-timer.C:
+		}
+	}
+}
+
+// failover switches to the best client not tried yet, reporting whether there was one
+func (g *githubCalls) failover(ctx context.Context, cause *gherr.Error) bool {
+	candidates := make(map[string]*repostats.ClientGQL, len(g.clients))
+	for key, client := range g.clients {
+		if !g.tried[key] {
+			candidates[key] = client
+		}
+	}
+	if len(candidates) == 0 {
+		return false
+	}
+
+	key, client := SelectBestClient(ctx, candidates, "")
+	if client == nil {
+		return false
+	}
+
+	previous := g.clientKey
+	g.clientKey, g.client = key, client
+	g.tried[key] = true
+	if g.busyWith != "" {
+		MarkClientBusy(key, g.busyWith)
+		g.busy = append(g.busy, key)
+	}
+
+	log.Printf("GitHub call with client %s failed (%s), failing over to %s", previous, cause.Kind, key)
+	g.progress.FailedOver(previous, key, cause)
+	trace.SpanFromContext(ctx).AddEvent("github.failover", trace.WithAttributes(
+		attribute.String("github.client.from", previous),
+		attribute.String("github.client.to", key),
+		attribute.String("error.kind", string(cause.Kind)),
+	))
+	return true
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/inflight"
+	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withoutRetryDelay makes retries immediate for the duration of the test
+func withoutRetryDelay(t *testing.T) {
+	t.Helper()
+	saved := githubRetry
+	githubRetry = retryPolicy{attempts: saved.attempts}
+	t.Cleanup(func() { githubRetry = saved })
+}
+
+// collectEvents returns the events queued for sub so far
+func collectEvents(sub *session.Subscriber) []session.Event {
+	var events []session.Event
+	for {
+		select {
+		case ev := <-sub.Events():
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
+
+func TestCallGitHubRetriesTransientErrors(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+	withoutRetryDelay(t)
+
+	hub := session.NewHub(16)
+	sub := hub.Subscribe(session.Topic(session.MetricStars, "helm/helm"))
+	defer hub.Unsubscribe(sub)
+
+	client := &repostats.ClientGQL{}
+	calls := newGitHubCalls(nil, "PAT", client, newProgressReporter(hub, session.MetricStars, "helm/helm", "PAT"))
+
+	attempts := 0
+	res, err := callGitHub(context.Background(), calls, func(ctx context.Context, c *repostats.ClientGQL) (int, error) {
+		attempts++
+		if attempts < 3 {
+			return 0, errors.New("non-200 OK status code: 502 Bad Gateway")
+		}
+		return 42, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 42, res)
+	assert.Equal(t, 3, attempts)
+
+	events := collectEvents(sub)
+	require.Len(t, events, 2)
+	assert.Equal(t, session.EventRetrying, events[0].Type)
+	assert.Equal(t, 1, events[0].Attempt)
+	assert.Equal(t, "upstream_error", events[0].ErrorKind)
+	assert.Equal(t, 2, events[1].Attempt)
+
+	// The success closed the failure streak
+	assert.Empty(t, globalClientSelector.GetClientHealth())
+}
+
+func TestCallGitHubGivesUp(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+	withoutRetryDelay(t)
+
+	calls := newGitHubCalls(nil, "PAT", &repostats.ClientGQL{}, nil)
+
+	attempts := 0
+	_, err := callGitHub(context.Background(), calls, func(ctx context.Context, c *repostats.ClientGQL) (int, error) {
+		attempts++
+		return 0, errors.New("non-200 OK status code: 503 Service Unavailable")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, githubRetry.attempts, attempts)
+
+	// Errors that won't go away aren't retried
+	attempts = 0
+	_, err = callGitHub(context.Background(), calls, func(ctx context.Context, c *repostats.ClientGQL) (int, error) {
+		attempts++
+		return 0, errors.New("Could not resolve to a Repository with the name 'helm/nope'")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestCallGitHubFailsOver(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+	withoutRetryDelay(t)
+
+	hub := session.NewHub(16)
+	sub := hub.Subscribe(session.Topic(session.MetricStars, "helm/helm"))
+	defer hub.Unsubscribe(sub)
+
+	dry, fresh := &repostats.ClientGQL{}, &repostats.ClientGQL{}
+	clients := map[string]*repostats.ClientGQL{"PAT": dry, "PAT2": fresh}
+	calls := newGitHubCalls(clients, "PAT", dry, newProgressReporter(hub, session.MetricStars, "helm/helm", "PAT"))
+	calls.markBusy("helm/helm")
+
+	res, err := callGitHub(context.Background(), calls, func(ctx context.Context, c *repostats.ClientGQL) (string, error) {
+		if c == dry {
+			return "", errors.New("API rate limit exceeded for user")
+		}
+		return "done", nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "done", res)
+	assert.Equal(t, "PAT2", calls.Key())
+	assert.Contains(t, GetBusyClients(), "PAT2")
+
+	events := collectEvents(sub)
+	require.Len(t, events, 1)
+	assert.Equal(t, session.EventFailover, events[0].Type)
+	assert.Equal(t, "PAT", events[0].PreviousClient)
+	assert.Equal(t, "PAT2", events[0].Client)
+	assert.Equal(t, "rate_limited", events[0].ErrorKind)
+
+	calls.release()
+	assert.Empty(t, GetBusyClients())
+
+	// With every client tried the rate limit is returned
+	_, err = callGitHub(context.Background(), calls, func(ctx context.Context, c *repostats.ClientGQL) (string, error) {
+		return "", errors.New("API rate limit exceeded for user")
+	})
+	assert.ErrorContains(t, err, "rate limit")
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := retryPolicy{attempts: 4, baseDelay: 2 * time.Second, maxDelay: time.Minute}
+
+	for retry, upper := range map[int]time.Duration{1: 2 * time.Second, 2: 4 * time.Second, 3: 8 * time.Second, 10: time.Minute} {
+		d := p.delay(retry, 0)
+		assert.GreaterOrEqual(t, d, upper/2)
+		assert.LessOrEqual(t, d, upper)
+	}
+
+	// GitHub's Retry-After wins over a shorter backoff
+	assert.Equal(t, 90*time.Second, p.delay(1, 90*time.Second))
+}
+
+func TestUserCallsDontShareFetches(t *testing.T) {
+	pool := newGitHubCalls(nil, "PAT", &repostats.ClientGQL{}, nil)
+	user := newUserCalls(&repostats.ClientGQL{})
+	otherUser := newUserCalls(&repostats.ClientGQL{})
+
+	assert.Equal(t, "helm/helm", pool.fetchKey("helm/helm"))
+	assert.NotEqual(t, "helm/helm", user.fetchKey("helm/helm"))
+	assert.NotEqual(t, user.fetchKey("helm/helm"), otherUser.fetchKey("helm/helm"))
+	assert.Equal(t, user.fetchKey("helm/helm"), user.fetchKey("helm/helm"))
+
+	// A fetch with a user token is neither joined by the callers of the pool nor joins theirs
+	var g inflight.Group[string]
+	release := make(chan struct{})
+	userFetch := make(chan string, 1)
+	go func() {
+		v, _, _ := g.Do(user.fetchKey("helm/helm"), func() (string, error) {
+			<-release
+			return "user", nil
+		})
+		userFetch <- v
+	}()
+	require.Eventually(t, func() bool { return g.InFlight(user.fetchKey("helm/helm")) }, time.Second, time.Millisecond)
+
+	v, _, shared := g.Do(pool.fetchKey("helm/helm"), func() (string, error) { return "pool", nil })
+	assert.Equal(t, "pool", v)
+	assert.False(t, shared)
+
+	close(release)
+	assert.Equal(t, "user", <-userFetch)
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/analytics"
+	"github.com/emanuelef/gh-repo-stats-server/cache"
+	"github.com/emanuelef/gh-repo-stats-server/config"
+	"github.com/emanuelef/gh-repo-stats-server/jobs"
+	"github.com/emanuelef/gh-repo-stats-server/news"
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
+	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/emanuelef/github-repo-activity-stats/stats"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The sources of the events /spikes correlates with the spikes
+const (
+	sourceHackerNews = "hackernews"
+	sourceReddit     = "reddit"
+	sourceRelease    = "release"
+	sourceYouTube    = "youtube"
+)
+
+// The news queries of /spikes, those of the UI so that both share the caches of /hackernews, /reddit and /youtube
+const (
+	spikesHackerNewsMinPoints = 10
+	spikesRedditMinUpvotes    = 2
+	spikesYouTubeLimit        = 10
+)
+
+// spikesResponse lists the spikes of the daily stars of a repo, from the most significant, see analytics.DetectSpikes
+type spikesResponse struct {
+	Repo   string          `json:"repo"`
+	Spikes []spikeResponse `json:"spikes"`
+	// Unavailable lists the sources of events that could not be fetched, their events are missing from the causes
+	Unavailable []string `json:"unavailable"`
+}
+
+type spikeResponse struct {
+	Day      string  `json:"day"`
+	Start    string  `json:"start"`
+	End      string  `json:"end"`
+	Stars    int     `json:"stars"`
+	Baseline float64 `json:"baseline"`
+	Score    float64 `json:"score"`
+	// Excess is how many stars the spike brought above the baseline, over all its days
+	Excess float64      `json:"excess"`
+	Causes []spikeCause `json:"causes"`
+}
+
+// spikeCause is an event close to a spike, the causes of a spike being ranked from the most likely
+type spikeCause struct {
+	Source string `json:"source"`
+	Title  string `json:"title"`
+	URL    string `json:"url"`
+	Date   string `json:"date"`
+	// LagDays is how many days before the peak of the spike the event happened, negative when after it
+	LagDays int `json:"lagDays"`
+	// Engagement is the points of a Hacker News article, the upvotes of a Reddit post or the views of a
+	// YouTube video, 0 for a release
+	Engagement float64 `json:"engagement"`
+	Score      float64 `json:"score"`
+}
+
+// SpikesHandler handles the /spikes endpoint detecting the days of ?repo= getting significantly more stars
+// than the weeks before them in its cached stars history. Each spike lists the Hacker News articles, Reddit
+// posts, releases and YouTube videos around it as candidate causes. A repo that is not cached gets a
+// background job filling its cache, like POST /jobs, and a 202 with the job.
+func SpikesHandler(
+	ctx context.Context,
+	clientPool *tokens.Pool,
+	manager *jobs.Manager,
+	fetchers map[string]MetricFetcher,
+	cacheHackerNews *cache.Cache[[]news.Article],
+	cacheReddit *cache.Cache[[]news.ArticleData],
+	cacheYouTube *cache.Cache[[]news.YTVideoMetadata],
+	cacheReleases *cache.Cache[[]stats.ReleaseInfo],
+) fiber.Handler {
+	return withGitHubClient(ctx, clientPool, func(c *fiber.Ctx, calls *githubCalls) error {
+		repo, err := url.QueryUnescape(c.Query("repo"))
+		if err != nil {
+			return err
+		}
+		repo = strings.Clone(repoid.Normalize(repo))
+		if !strings.Contains(repoid.Path(repo), "/") {
+			return c.Status(400).JSON(fiber.Map{"error": "repo must be in the form owner/name or host/owner/name"})
+		}
+
+		span := trace.SpanFromContext(c.UserContext())
+		span.SetAttributes(attribute.String("github.repo", repo))
+
+		fetcher := fetchers[session.MetricStars]
+		points, hit, err := fetcher.Daily(repo)
+		if err != nil {
+			log.Printf("Error reading cached stars of %s: %v", repo, err)
+			return c.Status(500).JSON(fiber.Map{"error": "Could not read cached stars of " + repo})
+		}
+		if !hit {
+			job, err := startFetchJob(ctx, clientPool, manager, fetcher, repo, session.MetricStars, "")
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "Could not create job"})
+			}
+			return c.Status(202).JSON(job.Status())
+		}
+
+		spikes := analytics.DetectSpikes(points, analytics.SpikeOptions{
+			BaselineDays:    config.SpikesBaselineDays,
+			MinBaselineDays: config.SpikesMinBaselineDays,
+			Threshold:       config.SpikesThreshold,
+			MinExcess:       config.SpikesMinExcess,
+		})
+		if len(spikes) > config.SpikesMax {
+			spikes = spikes[:config.SpikesMax]
+		}
+		span.SetAttributes(attribute.Int("spikes.count", len(spikes)))
+
+		resp := spikesResponse{Repo: repo, Spikes: []spikeResponse{}, Unavailable: []string{}}
+		if len(spikes) == 0 {
+			return c.JSON(resp)
+		}
+
+		// Only the events are fetched, the stars are those of the cache
+		var (
+			wg     sync.WaitGroup
+			mu     sync.Mutex
+			events []analytics.Event
+		)
+		collect := func(source string, fetch func() ([]analytics.Event, error)) {
+			defer wg.Done()
+			found, err := fetch()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Printf("Error fetching %s events of %s: %v", source, repo, err)
+				resp.Unavailable = append(resp.Unavailable, source)
+				return
+			}
+			events = append(events, found...)
+		}
+
+		wg.Add(4)
+		go collect(sourceHackerNews, func() ([]analytics.Event, error) {
+			articles, err := hackerNewsArticles(cacheHackerNews, repo, spikesHackerNewsMinPoints)
+			return hackerNewsEvents(articles), err
+		})
+		go collect(sourceReddit, func() ([]analytics.Event, error) {
+			posts, err := redditPosts(cacheReddit, repo, spikesRedditMinUpvotes, true)
+			return redditEvents(posts), err
+		})
+		go collect(sourceYouTube, func() ([]analytics.Event, error) {
+			videos, err := youTubeVideos(cacheYouTube, repo, spikesYouTubeLimit)
+			return youTubeEvents(videos), err
+		})
+		collect(sourceRelease, func() ([]analytics.Event, error) {
+			releases, err := repoReleases(trace.ContextWithSpan(ctx, span), calls, cacheReleases, repo)
+			return releaseEvents(releases), err
+		})
+		wg.Wait()
+		sort.Strings(resp.Unavailable)
+
+		for _, spike := range spikes {
+			s := spikeResponse{
+				Day:      spike.Peak.Day.Format("02-01-2006"),
+				Start:    spike.Start.Format("02-01-2006"),
+				End:      spike.End.Format("02-01-2006"),
+				Stars:    int(spike.Peak.Value),
+				Baseline: spike.Baseline,
+				Score:    spike.Score,
+				Excess:   spike.Excess,
+				Causes:   []spikeCause{},
+			}
+			for _, cause := range analytics.Causes(spike, events, config.SpikesLeadDays) {
+				s.Causes = append(s.Causes, spikeCause{
+					Source:     cause.Source,
+					Title:      cause.Title,
+					URL:        cause.URL,
+					Date:       cause.Time.UTC().Format(time.RFC3339),
+					LagDays:    cause.LagDays,
+					Engagement: cause.Engagement,
+					Score:      cause.Score,
+				})
+			}
+			resp.Spikes = append(resp.Spikes, s)
+		}
+
+		return c.JSON(resp)
+	})
+}
+
+func hackerNewsEvents(articles []news.Article) []analytics.Event {
+	var events []analytics.Event
+	for _, a := range articles {
+		t, err := time.Parse(time.RFC3339, a.CreatedAt)
+		if err != nil {
+			continue
+		}
+		link := a.HNURL
+		if link == "" {
+			link = a.URL
+		}
+		events = append(events, analytics.Event{Source: sourceHackerNews, Title: a.Title, URL: link, Time: t, Engagement: float64(a.Points)})
+	}
+	return events
+}
+
+func redditEvents(posts []news.ArticleData) []analytics.Event {
+	var events []analytics.Event
+	for _, p := range posts {
+		// FetchRedditPosts formats the creation time in the local time zone
+		t, err := time.ParseInLocation("2006-01-02 15:04:05", p.Created, time.Local)
+		if err != nil {
+			continue
+		}
+		events = append(events, analytics.Event{Source: sourceReddit, Title: p.Title, URL: p.Url, Time: t, Engagement: float64(p.Ups)})
+	}
+	return events
+}
+
+func youTubeEvents(videos []news.YTVideoMetadata) []analytics.Event {
+	var events []analytics.Event
+	for _, v := range videos {
+		t, err := time.Parse(time.RFC3339, v.PublishedAt)
+		if err != nil {
+			continue
+		}
+		events = append(events, analytics.Event{Source: sourceYouTube, Title: v.Title, URL: v.VideoURL, Time: t, Engagement: float64(v.ViewCount)})
+	}
+	return events
+}
+
+func releaseEvents(releases []stats.ReleaseInfo) []analytics.Event {
+	var events []analytics.Event
+	for _, r := range releases {
+		title := r.Name
+		if title == "" {
+			title = r.TagName
+		}
+		events = append(events, analytics.Event{Source: sourceRelease, Title: title, URL: r.URL, Time: r.PublishedAt})
+	}
+	return events
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/cache"
+	"github.com/emanuelef/gh-repo-stats-server/inflight"
+	"github.com/emanuelef/gh-repo-stats-server/jobs"
+	"github.com/emanuelef/gh-repo-stats-server/news"
+	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/emanuelef/gh-repo-stats-server/types"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"github.com/emanuelef/github-repo-activity-stats/stats"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpikesHandler(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+	globalClientSelector.setRateLimit("PAT", &repostats.RateLimit{Limit: 5000, Remaining: 5000, ResetAt: time.Now().Add(time.Hour)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	daily := make([]int, 60)
+	for i := range daily {
+		daily[i] = 5 + i%2
+	}
+	daily[40] = 80
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cacheStars := cache.NewCache[types.StarsWithStatsResponse]()
+	cacheStars.Set("helm/helm", starsHistory(day, daily...), time.Now().Add(time.Hour))
+	cacheStars.Set("quiet/repo", starsHistory(day, 5, 6, 5, 6, 5, 6, 5, 6, 5, 6), time.Now().Add(time.Hour))
+
+	var onGoing inflight.Group[types.StarsWithStatsResponse]
+	fetcher := newMetricFetcher(session.MetricStars, session.NewHub(0), cacheStars, &onGoing,
+		func(ctx context.Context, calls *githubCalls, repo string, c *cache.Cache[types.StarsWithStatsResponse]) (types.StarsWithStatsResponse, error) {
+			res := starsHistory(day, daily...)
+			c.Set(repo, res, time.Now().Add(time.Hour))
+			return res, nil
+		},
+		func(res types.StarsWithStatsResponse) any { return res.Stars })
+
+	// 10-02-2024 is the spike, every source is cached so that nothing is fetched
+	expiration := time.Now().Add(time.Hour)
+	cacheHackerNews := cache.NewCache[[]news.Article]()
+	cacheHackerNews.Set("helm/helm", []news.Article{
+		{Title: "Helm on HN", CreatedAt: "2024-02-09T18:30:00.000Z", Points: 300, HNURL: "https://news.ycombinator.com/item?id=1"},
+		{Title: "Old news", CreatedAt: "2024-01-05T10:00:00.000Z", Points: 50},
+	}, expiration)
+	cacheReddit := cache.NewCache[[]news.ArticleData]()
+	cacheReddit.Set("reddit:helm/helm:2:true", []news.ArticleData{
+		{Title: "Helm on Reddit", Created: time.Date(2024, 2, 11, 12, 0, 0, 0, time.UTC).Local().Format("2006-01-02 15:04:05"), Ups: 20, Url: "https://reddit.com/r/kubernetes/1"},
+	}, expiration)
+	cacheYouTube := cache.NewCache[[]news.YTVideoMetadata]()
+	cacheYouTube.Set("helm/helm", []news.YTVideoMetadata{}, expiration)
+	cacheReleases := cache.NewCache[[]stats.ReleaseInfo]()
+	cacheReleases.Set("helm/helm_releases", []stats.ReleaseInfo{
+		{TagName: "v3.0.0", PublishedAt: time.Date(2024, 2, 10, 9, 0, 0, 0, time.UTC), URL: "https://github.com/helm/helm/releases/tag/v3.0.0"},
+	}, expiration)
+
+	pool, err := tokens.NewPool(
+		func() ([]tokens.Token, error) { return []tokens.Token{{Label: "PAT", Value: "a"}}, nil },
+		func(tokens.Token) *repostats.ClientGQL { return &repostats.ClientGQL{} },
+	)
+	require.NoError(t, err)
+
+	manager := jobs.NewManager(ctx)
+	app := fiber.New()
+	app.Get("/spikes", SpikesHandler(ctx, pool, manager, map[string]MetricFetcher{session.MetricStars: fetcher},
+		cacheHackerNews, cacheReddit, cacheYouTube, cacheReleases))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/spikes?repo=Helm/Helm", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	var body spikesResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "helm/helm", body.Repo)
+	assert.Empty(t, body.Unavailable)
+	require.Len(t, body.Spikes, 1)
+	spike := body.Spikes[0]
+	assert.Equal(t, "10-02-2024", spike.Day)
+	assert.Equal(t, spike.Day, spike.Start)
+	assert.Equal(t, spike.Day, spike.End)
+	assert.Equal(t, 80, spike.Stars)
+	assert.InDelta(t, 5.5, spike.Baseline, 0.5)
+
+	require.Len(t, spike.Causes, 3)
+	assert.Equal(t, spikeCause{
+		Source: sourceRelease,
+		Title:  "v3.0.0",
+		URL:    "https://github.com/helm/helm/releases/tag/v3.0.0",
+		Date:   "2024-02-10T09:00:00Z",
+		Score:  1,
+	}, spike.Causes[2])
+	assert.Equal(t, "Helm on HN", spike.Causes[0].Title)
+	assert.Equal(t, 1, spike.Causes[0].LagDays)
+	assert.Equal(t, "https://news.ycombinator.com/item?id=1", spike.Causes[0].URL)
+	assert.Equal(t, sourceReddit, spike.Causes[1].Source)
+	assert.Equal(t, -1, spike.Causes[1].LagDays)
+
+	// No spike, no events fetched
+	resp, err = app.Test(httptest.NewRequest("GET", "/spikes?repo=quiet/repo", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Empty(t, body.Spikes)
+
+	// Not cached: a job fills the cache
+	resp, err = app.Test(httptest.NewRequest("GET", "/spikes?repo=argoproj/argo-cd", nil))
+	require.NoError(t, err)
+	require.Equal(t, 202, resp.StatusCode)
+	var status jobs.Status
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	job, ok := manager.Get(status.ID)
+	require.True(t, ok)
+	require.Eventually(t, job.Done, time.Second, time.Millisecond)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/spikes?repo=helm", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
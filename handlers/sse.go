@@ -2,43 +2,55 @@ package handlers
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
 	"github.com/emanuelef/gh-repo-stats-server/session"
 	"github.com/gofiber/fiber/v2"
 	"github.com/valyala/fasthttp"
 )
 
-// SSEHandler handles Server-Sent Events for real-time progress updates
-func SSEHandler(currentSessions *session.SessionsLock) fiber.Handler {
+// maxSSETopics limits how many topics a single SSE connection can subscribe to
+const maxSSETopics = 20
+
+// SSEHandler handles Server-Sent Events for real-time progress updates.
+// Clients subscribe with one or more topic parameters (e.g. ?topic=stars:helm/helm&topic=issues:helm/helm,
+// or ?topics=stars:helm/helm,issues:helm/helm). The older ?repo=owner/name form subscribes to every
+// metric of that repo and also receives the bare current-value page counts.
+//
+// Each event carries an id made of the last event ID seen on every topic, in subscription order (e.g. 12.4).
+// A client reconnecting with it in Last-Event-ID gets the events it missed, including a completed
+// or failed event published while it was away.
+func SSEHandler(progressHub *session.Hub) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		c.Set("Content-Type", "text/event-stream")
 		c.Set("Cache-Control", "no-cache")
 		c.Set("Connection", "keep-alive")
 		c.Set("Transfer-Encoding", "chunked")
 
-		param := c.Query("repo")
-		repo, err := url.QueryUnescape(param)
+		topics, legacy, err := sseTopics(c)
 		if err != nil {
-			return err
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 		}
-		repo = strings.Clone(repo) // Fiber's c.Query returns unsafe strings backed by a reusable buffer
 
-		log.Printf("New Request %s\n", repo)
+		log.Printf("New Request %v\n", topics)
 
-		stateChan := make(chan int)
+		sub := progressHub.Resume(parseLastEventID(c.Get("Last-Event-ID"), topics), topics...)
 
-		s := session.Session{
-			Repo:         repo,
-			StateChannel: stateChan,
+		positions := make([]uint64, len(topics))
+		topicIndex := make(map[string]int, len(topics))
+		for i, topic := range topics {
+			positions[i] = sub.StartID(topic)
+			topicIndex[topic] = i
 		}
 
-		currentSessions.AddSession(&s)
-
 		notify := c.Context().Done()
 
 		c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
@@ -48,29 +60,39 @@ func SSEHandler(currentSessions *session.SessionsLock) fiber.Handler {
 			go func() {
 				<-notify
 				log.Printf("Stopped Request\n")
-				currentSessions.RemoveSession(&s)
+				progressHub.Unsubscribe(sub)
 				keepAliveTickler.Stop()
 			}()
 
 			for loop := true; loop; {
 				select {
-				case ev := <-stateChan:
-					sseMessage, err := session.FormatSSEMessage("current-value", ev)
-					if err != nil {
-						log.Printf("Error formatting sse message: %v\n", err)
+				case ev, ok := <-sub.Events():
+					if !ok {
+						loop = false
 						continue
 					}
 
-					_, err = fmt.Fprintf(w, "%s", sseMessage)
-					if err != nil {
+					if i, ok := topicIndex[ev.Topic]; ok {
+						positions[i] = ev.ID
+					}
+
+					if err := writeSSEEvent(w, formatEventID(positions), ev.Type, ev); err != nil {
 						log.Printf("Error while writing Data: %v\n", err)
 						continue
 					}
 
-					err = w.Flush()
+					// Older clients only listen to the bare page count
+					if legacy && ev.Type == session.EventProgress {
+						if err := writeSSEEvent(w, "", "current-value", ev.PagesDone); err != nil {
+							log.Printf("Error while writing Data: %v\n", err)
+							continue
+						}
+					}
+
+					err := w.Flush()
 					if err != nil {
 						log.Printf("Error while flushing Data: %v\n", err)
-						currentSessions.RemoveSession(&s)
+						progressHub.Unsubscribe(sub)
 						keepAliveTickler.Stop()
 						loop = false
 					}
@@ -80,7 +102,7 @@ func SSEHandler(currentSessions *session.SessionsLock) fiber.Handler {
 					err := w.Flush()
 					if err != nil {
 						log.Printf("Error while flushing: %v.\n", err)
-						currentSessions.RemoveSession(&s)
+						progressHub.Unsubscribe(sub)
 						keepAliveTickler.Stop()
 						loop = false
 					}
@@ -93,3 +115,94 @@ func SSEHandler(currentSessions *session.SessionsLock) fiber.Handler {
 		return nil
 	}
 }
+
+func writeSSEEvent(w *bufio.Writer, id, eventType string, data any) error {
+	sseMessage, err := session.FormatSSEEvent(id, eventType, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s", sseMessage)
+	return err
+}
+
+// sseTopics returns the topics requested by an SSE client and whether it used the older repo parameter
+func sseTopics(c *fiber.Ctx) ([]string, bool, error) {
+	var requested []string
+	for _, topic := range c.Context().QueryArgs().PeekMulti("topic") {
+		requested = append(requested, string(topic))
+	}
+	if list := c.Query("topics"); list != "" {
+		requested = append(requested, strings.Split(list, ",")...)
+	}
+
+	if len(requested) == 0 {
+		repo, err := url.QueryUnescape(c.Query("repo"))
+		if err != nil {
+			return nil, false, err
+		}
+		repo = repoid.Normalize(repo)
+		if repo == "" {
+			return nil, false, errors.New("missing topic or repo parameter")
+		}
+
+		topics := make([]string, 0, len(session.RepoMetrics))
+		for _, metric := range session.RepoMetrics {
+			topics = append(topics, session.Topic(metric, repo))
+		}
+		return topics, true, nil
+	}
+
+	if len(requested) > maxSSETopics {
+		return nil, false, fmt.Errorf("too many topics, at most %d are allowed", maxSSETopics)
+	}
+
+	topics := make([]string, 0, len(requested))
+	for _, raw := range requested {
+		unescaped, err := url.QueryUnescape(raw)
+		if err != nil {
+			return nil, false, err
+		}
+		topic, ok := session.ParseTopic(unescaped)
+		if !ok {
+			return nil, false, fmt.Errorf("invalid topic %q", raw)
+		}
+		if !slices.Contains(topics, topic) {
+			topics = append(topics, topic)
+		}
+	}
+
+	return topics, false, nil
+}
+
+// formatEventID joins the last event ID of every topic into a single SSE id
+func formatEventID(positions []uint64) string {
+	parts := make([]string, len(positions))
+	for i, id := range positions {
+		parts[i] = strconv.FormatUint(id, 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+// parseLastEventID maps a Last-Event-ID built by formatEventID back to its topics.
+// It returns nil when the header is missing or doesn't match the topics, and the client starts from the next event.
+func parseLastEventID(lastEventID string, topics []string) map[string]uint64 {
+	if lastEventID == "" {
+		return nil
+	}
+
+	parts := strings.Split(lastEventID, ".")
+	if len(parts) != len(topics) {
+		return nil
+	}
+
+	lastIDs := make(map[string]uint64, len(topics))
+	for i, part := range parts {
+		id, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return nil
+		}
+		lastIDs[topics[i]] = id
+	}
+	return lastIDs
+}
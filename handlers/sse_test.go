@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSSETopics(t *testing.T) {
+	type result struct {
+		Topics []string `json:"topics"`
+		Legacy bool     `json:"legacy"`
+		Error  string   `json:"error"`
+	}
+
+	app := fiber.New()
+	app.Get("/sse", func(c *fiber.Ctx) error {
+		topics, legacy, err := sseTopics(c)
+		if err != nil {
+			return c.Status(400).JSON(result{Error: err.Error()})
+		}
+		return c.JSON(result{Topics: topics, Legacy: legacy})
+	})
+
+	tests := []struct {
+		name   string
+		query  string
+		want   []string
+		legacy bool
+		status int
+	}{
+		{"repeated topics", "topic=stars:Helm/Helm&topic=issues:helm/helm&topic=stars:helm/helm", []string{"stars:helm/helm", "issues:helm/helm"}, false, 200},
+		{"topic list", "topics=forks:helm/helm,newrepos:2024-01-01_2024-02-01", []string{"forks:helm/helm", "newrepos:2024-01-01_2024-02-01"}, false, 200},
+		{"escaped topic", "topic=stars%3Ahelm%2Fhelm", []string{"stars:helm/helm"}, false, 200},
+		{"legacy repo", "repo=Helm/Helm", []string{"stars:helm/helm", "issues:helm/helm", "forks:helm/helm", "prs:helm/helm", "commits:helm/helm", "contributors:helm/helm"}, true, 200},
+		{"unknown metric", "topic=watchers:helm/helm", nil, false, 400},
+		{"missing key", "topic=stars:", nil, false, 400},
+		{"nothing requested", "", nil, false, 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", "/sse?"+tt.query, nil))
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+
+			var got result
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+			assert.Equal(t, tt.want, got.Topics)
+			assert.Equal(t, tt.legacy, got.Legacy)
+		})
+	}
+}
+
+func TestLastEventID(t *testing.T) {
+	topics := []string{"stars:helm/helm", "issues:helm/helm"}
+
+	id := formatEventID([]uint64{12, 0})
+	assert.Equal(t, "12.0", id)
+	assert.Equal(t, map[string]uint64{"stars:helm/helm": 12, "issues:helm/helm": 0}, parseLastEventID(id, topics))
+
+	assert.Nil(t, parseLastEventID("", topics))
+	assert.Nil(t, parseLastEventID("12", topics))
+	assert.Nil(t, parseLastEventID("12.x", topics))
+}
@@ -3,52 +3,49 @@ package handlers
 import (
 	"context"
 	"log"
-	"math/rand"
 	"net/url"
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
-	cache "github.com/Code-Hex/go-generics-cache"
-	"github.com/emanuelef/gh-repo-stats-server/config"
+	"github.com/emanuelef/gh-repo-stats-server/cache"
+	"github.com/emanuelef/gh-repo-stats-server/inflight"
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
 	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
 	"github.com/emanuelef/gh-repo-stats-server/types"
 	"github.com/emanuelef/github-repo-activity-stats/repostats"
 	"github.com/emanuelef/github-repo-activity-stats/stats"
 	"github.com/gofiber/fiber/v2"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
-	"golang.org/x/sync/errgroup"
 )
 
 // AllStarsHandler handles the /allStars endpoint
 func AllStarsHandler(
-	ghStatClients map[string]*repostats.ClientGQL,
-	cacheStars *cache.Cache[string, types.StarsWithStatsResponse],
-	onGoingStars map[string]bool,
-	currentSessions *session.SessionsLock,
+	clientPool *tokens.Pool,
+	cacheStars *cache.Cache[types.StarsWithStatsResponse],
+	onGoingStars *inflight.Group[types.StarsWithStatsResponse],
+	progressHub *session.Hub,
 	requestStats *types.RequestStats,
 	ctx context.Context,
 ) fiber.Handler {
-	return func(c *fiber.Ctx) error {
+	return withGitHubClient(ctx, clientPool, func(c *fiber.Ctx, calls *githubCalls) error {
 		param := c.Query("repo")
 		forceRefetch := c.Query("forceRefetch", "false") == "true"
-		overrideClient := c.Query("client", "")
-
-		clientKey, client := SelectBestClient(ctx, ghStatClients, overrideClient)
-		if client == nil {
-			return c.Status(500).SendString("No GitHub API client available")
+		aggregate := c.Query("aggregate")
+		if !validAggregate(aggregate) {
+			return c.Status(400).JSON(fiber.Map{"error": "Unsupported aggregate: " + aggregate})
 		}
-		log.Printf("AllStars using client: %s", clientKey)
+		log.Printf("AllStars using client: %s", calls.Key())
 
 		repo, err := url.QueryUnescape(param)
 		if err != nil {
 			return err
 		}
 
-		repo = strings.ToLower(repo)
+		repo = repoid.Normalize(repo)
 		repo = strings.Clone(repo) // Fiber's c.Query returns unsafe strings backed by a reusable buffer
 
 		ip := c.Get("X-Forwarded-For")
@@ -81,117 +78,113 @@ func AllStarsHandler(
 			cacheStars.Delete(repo)
 		}
 
-		if res, hit := cacheStars.Get(repo); hit {
-			return c.JSON(res)
-		}
+		if res, stale, found := cacheStars.GetStale(repo); found {
+			if stale && calls.ephemeral {
+				// The token of the request isn't used once it's answered, the refresh runs while the caller waits
+				res, err, _ := onGoingStars.Do(calls.fetchKey(repo), func() (types.StarsWithStatsResponse, error) {
+					calls.withProgress(newProgressReporter(progressHub, session.MetricStars, repo, calls.Key()))
+					return refreshStars(trace.ContextWithSpan(ctx, span), calls, repo, res, cacheStars)
+				})
+				if err != nil {
+					return sendGitHubError(c, calls.Key(), err)
+				}
+				span.SetAttributes(attribute.String("cache.status", CacheFresh))
+				c.Set(CacheStatusHeader, CacheFresh)
+				return sendSeries(c, res, "stars", res.Stars, aggregate)
+			}
 
-		// if another request is already getting the data, skip and rely on SSE updates
-		if _, hit := onGoingStars[repo]; hit {
-			return c.SendStatus(fiber.StatusNoContent)
+			status := CacheFresh
+			if stale {
+				bgCalls := calls.detached()
+				bgCalls.withProgress(newProgressReporter(progressHub, session.MetricStars, repo, bgCalls.Key()))
+				status = revalidateStars(ctx, bgCalls, repo, res, cacheStars, onGoingStars)
+			}
+			span.SetAttributes(attribute.String("cache.status", status))
+			c.Set(CacheStatusHeader, status)
+			return sendSeries(c, res, "stars", res.Stars, aggregate)
 		}
 
-		onGoingStars[repo] = true
-
-		// Mark this client as busy during the long-running operation
-		MarkClientBusy(clientKey, repo)
-
-		updateChannel := make(chan int)
-		var allStars []stats.StarsPerDay
+		// if another request is already getting the data, join it and share its result
+		res, err, _ := onGoingStars.Do(calls.fetchKey(repo), func() (types.StarsWithStatsResponse, error) {
+			calls.withProgress(newProgressReporter(progressHub, session.MetricStars, repo, calls.Key()))
 
-		eg, localCtx := errgroup.WithContext(ctx)
+			// Mark the client as busy during the long-running operation
+			calls.markBusy(repo)
 
-		eg.Go(func() error {
-			var err error
-			allStars, err = client.GetAllStarsHistoryTwoWays(localCtx, repo, updateChannel)
-			if err != nil {
-				return err
-			}
-			return nil
+			return fetchAllStars(trace.ContextWithSpan(ctx, span), calls, repo, cacheStars)
 		})
+		if err != nil {
+			return sendGitHubError(c, calls.Key(), err)
+		}
 
-		for progress := range updateChannel {
-			wg := &sync.WaitGroup{}
+		c.Set(CacheStatusHeader, CacheFresh)
+		return sendSeries(c, res, "stars", res.Stars, aggregate)
+	})
+}
 
-			for _, s := range currentSessions.Sessions {
-				wg.Add(1)
-				go func(cs *session.Session) {
-					defer wg.Done()
-					if cs.Repo == repo {
-						cs.StateChannel <- progress
-					}
-				}(s)
-			}
-			wg.Wait()
-		}
+// CacheStatusHeader tells /allStars callers how current the returned history is
+const CacheStatusHeader = "X-Cache-Status"
 
-		if err := eg.Wait(); err != nil {
-			delete(onGoingStars, repo)
-			MarkClientIdle(clientKey) // Mark client as available again
-			log.Printf("Error fetching stars for %s: %v", repo, err)
-			status, message := classifyGitHubError(err)
-			return c.Status(status).SendString(message)
-		}
+const (
+	// CacheFresh means the history is within its cache lifetime or was just fetched
+	CacheFresh = "fresh"
+	// CacheStale means the history expired and this request started refreshing it in the background
+	CacheStale = "stale"
+	// CacheRevalidating means the history expired and a refresh was already running
+	CacheRevalidating = "revalidating"
+)
 
-		defer close(updateChannel)
+// revalidateStars starts a background refresh of an expired stars history with calls unless one is
+// already running, and returns the cache status to report for the stale response
+func revalidateStars(
+	ctx context.Context,
+	calls *githubCalls,
+	repo string,
+	cached types.StarsWithStatsResponse,
+	cacheStars *cache.Cache[types.StarsWithStatsResponse],
+	onGoingStars *inflight.Group[types.StarsWithStatsResponse],
+) string {
+	if onGoingStars.InFlight(repo) {
+		return CacheRevalidating
+	}
 
-		// Remove incomplete (today's) day before creating response and caching in /allStars ---
-		if len(allStars) > 0 {
-			todayStr := time.Now().Format("02-01-2006")
-			lastDayStr := time.Time(allStars[len(allStars)-1].Day).Format("02-01-2006")
-			if lastDayStr == todayStr {
-				allStars = allStars[:len(allStars)-1] // remove incomplete day
+	go func() {
+		_, err, _ := onGoingStars.Do(repo, func() (types.StarsWithStatsResponse, error) {
+			// Another request may have finished refreshing while this one was starting
+			if res, hit := cacheStars.Get(repo); hit {
+				return res, nil
 			}
-		}
-
-		maxPeriods, maxPeaks, err := repostats.FindMaxConsecutivePeriods(allStars, 10)
-		if err != nil {
-			return err
-		}
 
-		newLastNDays := repostats.NewStarsLastDays(allStars, 10)
+			calls.markBusy(repo)
+			defer calls.release()
 
-		res := types.StarsWithStatsResponse{
-			Stars:         allStars,
-			NewLast10Days: newLastNDays,
-			MaxPeriods:    maxPeriods,
-			MaxPeaks:      maxPeaks,
+			return refreshStars(ctx, calls, repo, cached, cacheStars)
+		})
+		if err != nil {
+			log.Printf("Error refreshing stale stars for %s: %v", repo, err)
 		}
+	}()
 
-		now := time.Now()
-		nextDay := now.UTC().Truncate(24 * time.Hour).Add(config.DayCached * 24 * time.Hour)
-		durationUntilEndOfDay := nextDay.Sub(now)
-
-		cacheStars.Set(repo, res, cache.WithExpiration(durationUntilEndOfDay))
-		delete(onGoingStars, repo)
-		MarkClientIdle(clientKey) // Mark client as available after successful completion
-
-		return c.JSON(res)
-	}
+	return CacheStale
 }
 
 // RecentStarsHandler handles the /recentStars endpoint
 func RecentStarsHandler(
-	ghStatClients map[string]*repostats.ClientGQL,
-	cacheStars *cache.Cache[string, types.StarsWithStatsResponse],
+	clientPool *tokens.Pool,
+	cacheStars *cache.Cache[types.StarsWithStatsResponse],
 	ctx context.Context,
 ) fiber.Handler {
-	return func(c *fiber.Ctx) error {
+	return withGitHubClient(ctx, clientPool, func(c *fiber.Ctx, calls *githubCalls) error {
 		param := c.Query("repo")
 		lastDaysStr := c.Query("lastDays", "30") // Default to 30 days if not provided
-		overrideClient := c.Query("client", "")
-
-		clientKey, client := SelectBestClient(ctx, ghStatClients, overrideClient)
-		if client == nil {
-			return c.Status(500).SendString("No GitHub API client available")
-		}
-		log.Printf("RecentStars using client: %s", clientKey)
+		log.Printf("RecentStars using client: %s", calls.Key())
 
 		repo, err := url.QueryUnescape(param)
 		if err != nil {
 			return err
 		}
 
-		repo = strings.ToLower(repo)
+		repo = repoid.Normalize(repo)
 		repo = strings.Clone(repo) // Fiber's c.Query returns unsafe strings backed by a reusable buffer
 
 		lastDays, err := strconv.Atoi(lastDaysStr)
@@ -216,118 +209,61 @@ func RecentStarsHandler(
 		span.SetAttributes(attribute.String("caller.ip", ip))
 
 		// 1. Fetch recent daily stars (no cumulative) for the last N days
-		recentStars, err := client.GetRecentStarsHistoryTwoWays(ctx, repo, lastDays, nil)
+		calls.markBusy(repo)
+		recentStars, err := callGitHub(trace.ContextWithSpan(ctx, span), calls, func(ctx context.Context, client *repostats.ClientGQL) ([]stats.StarsPerDay, error) {
+			return client.GetRecentStarsHistoryTwoWays(ctx, repoid.Path(repo), lastDays, nil)
+		})
 		if err != nil {
-			return err
+			return sendGitHubError(c, calls.Key(), err)
 		}
 
-		// 2. Get cached stars for this repo (if any)
+		// 2. Get cached stars for this repo (if any), an expired history is still a good base to merge into
 		var cachedStars []stats.StarsPerDay
-		var cachedRes types.StarsWithStatsResponse
-		var found bool
-		if cachedRes, found = cacheStars.Get(repo); found {
+		if cachedRes, _, found := cacheStars.GetStale(repo); found {
 			cachedStars = cachedRes.Stars
 		}
 
-		// 3. Create a map to hold all stars data (both cached and recent)
-		mergedMap := make(map[string]stats.StarsPerDay)
-		for _, entry := range cachedStars {
-			dayStr := time.Time(entry.Day).Format("02-01-2006")
-			mergedMap[dayStr] = entry
-		}
+		// 3. Merge recent days into the cached ones and recalculate cumulative totals
+		mergedStars, hasNewEntries := mergeStars(cachedStars, recentStars)
 
-		// Do not add today when merging recentStars
-		todayStr := time.Now().Format("02-01-2006")
-		var hasNewEntries bool
-		for _, entry := range recentStars {
-			entryDayStr := time.Time(entry.Day).Format("02-01-2006")
-			if entryDayStr == todayStr {
-				continue // skip today
-			}
-			if _, exists := mergedMap[entryDayStr]; exists {
-				hasNewEntries = true // treat as new if we update the value
-			}
-			mergedMap[entryDayStr] = entry
-			if !hasNewEntries {
-				if _, exists := mergedMap[entryDayStr]; !exists {
-					hasNewEntries = true
-				}
-			}
-		}
-
-		// 4. Convert map back to slice and sort by date
-		var mergedStars []stats.StarsPerDay
-		for _, entry := range mergedMap {
-			mergedStars = append(mergedStars, entry)
-		}
-
-		// Sort by date
-		sort.Slice(mergedStars, func(i, j int) bool {
-			return time.Time(mergedStars[i].Day).Before(time.Time(mergedStars[j].Day))
-		})
-
-		// 5. Recalculate cumulative totals
-		if len(mergedStars) > 0 {
-			runningTotal := 0
-			for i := range mergedStars {
-				runningTotal += mergedStars[i].Stars
-				mergedStars[i].TotalStars = runningTotal
-			}
-		}
-
-		maxPeriods, maxPeaks, err := repostats.FindMaxConsecutivePeriods(mergedStars, 10)
+		res, err := newStarsResponse(mergedStars)
 		if err != nil {
 			return err
 		}
-		newLastNDays := repostats.NewStarsLastDays(mergedStars, 10)
-
-		res := types.StarsWithStatsResponse{
-			Stars:         mergedStars,
-			NewLast10Days: newLastNDays,
-			MaxPeriods:    maxPeriods,
-			MaxPeaks:      maxPeaks,
-		}
 
 		if hasNewEntries {
 			// Update cache only if there are new days
-			now := time.Now()
-			nextDay := now.UTC().Truncate(24 * time.Hour).Add(config.DayCached * 24 * time.Hour)
-			durationUntilEndOfDay := nextDay.Sub(now)
-			cacheStars.Set(repo, res, cache.WithExpiration(durationUntilEndOfDay))
+			cacheStars.Set(repo, res, cacheExpiration())
 		}
 
 		return c.JSON(res)
-	}
+	})
 }
 
 // RecentStarsByHourHandler handles the /recentStarsByHour endpoint with incremental caching
 func RecentStarsByHourHandler(
-	ghStatClients map[string]*repostats.ClientGQL,
-	cacheRecentStarsByHour *cache.Cache[string, []types.HourlyStars],
+	clientPool *tokens.Pool,
+	cacheRecentStarsByHour *cache.Cache[[]types.HourlyStars],
 ) fiber.Handler {
-	return func(c *fiber.Ctx) error {
+	return withGitHubClient(context.Background(), clientPool, func(c *fiber.Ctx, calls *githubCalls) error {
 		param := c.Query("repo")
 
-		clientKeys := make([]string, 0, len(ghStatClients))
-		for k := range ghStatClients {
-			clientKeys = append(clientKeys, k)
-		}
-		randomIndex := rand.Intn(len(clientKeys))
-		clientKey := c.Query("client", clientKeys[randomIndex])
-
-		client, ok := ghStatClients[clientKey]
-		if !ok {
-			return c.Status(404).SendString("Resource not found")
-		}
-
 		repo, err := url.QueryUnescape(param)
 		if err != nil {
 			return err
 		}
 
-		repo = strings.ToLower(repo)
+		repo = repoid.Normalize(repo)
 		repo = strings.Clone(repo) // Fiber's c.Query returns unsafe strings backed by a reusable buffer
 
+		calls.markBusy(repo)
+		getStarsByHour := func(ctx context.Context, from, to time.Time) ([]stats.StarsPerHour, error) {
+			ctx = trace.ContextWithSpan(ctx, trace.SpanFromContext(c.UserContext()))
+			return callGitHub(ctx, calls, func(ctx context.Context, client *repostats.ClientGQL) ([]stats.StarsPerHour, error) {
+				return client.GetRecentStarsHistoryByHourRange(ctx, repoid.Path(repo), from, to, nil)
+			})
+		}
+
 		// Get lastDays parameter, default to 2
 		lastDays, err := strconv.Atoi(c.Query("lastDays", "2"))
 		if err != nil || lastDays < 1 {
@@ -393,17 +329,14 @@ func RecentStarsByHourHandler(
 		if len(cachedHourly) == 0 {
 			// No cache - fetch full requested range
 			log.Printf("[FETCH FULL] %s: fetching %d days from %v to now", repo, lastDays, requestedStartTime.Format(time.RFC3339))
-			starsPerHour, err = client.GetRecentStarsHistoryByHourRange(
+			starsPerHour, err = getStarsByHour(
 				bgCtx,
-				repo,
 				requestedStartTime,
 				// Workaround: Pass now+1h to ensure the current partial hour is included.
 				time.Now().UTC().Add(time.Hour),
-				nil,
 			)
 			if err != nil {
-				log.Printf("Error getting stars by hour: %v", err)
-				return c.Status(500).SendString("Internal Server Error")
+				return sendGitHubError(c, calls.Key(), err)
 			}
 		} else {
 			// We have cache - determine what's missing
@@ -463,16 +396,14 @@ func RecentStarsByHourHandler(
 				// Fetch older data: from requestedStartTime to oldestCachedTime
 				log.Printf("[FETCH OLDER] %s: from %v to %v",
 					repo, requestedStartTime.Format(time.RFC3339), oldestCachedTime.Format(time.RFC3339))
-				olderData, err = client.GetRecentStarsHistoryByHourRange(
+				olderData, err = getStarsByHour(
 					bgCtx,
-					repo,
 					requestedStartTime,
 					oldestCachedTime,
-					nil,
 				)
 				if err != nil {
 					log.Printf("[ERROR OLDER] %s: %v", repo, err)
-					return c.Status(500).SendString("Internal Server Error")
+					return sendGitHubError(c, calls.Key(), err)
 				}
 			}
 
@@ -487,12 +418,10 @@ func RecentStarsByHourHandler(
 				// Workaround: Pass now+1h to ensure the current partial hour is included.
 				// The library truncates endTime to the hour and uses strict inequality (< truncatedTime),
 				// which excludes stars in the current hour if we just pass 'now'.
-				newerData, err = client.GetRecentStarsHistoryByHourRange(
+				newerData, err = getStarsByHour(
 					bgCtx,
-					repo,
 					fetchFrom,
 					now.Add(time.Hour),
-					nil,
 				)
 				if err != nil {
 					log.Printf("[ERROR NEWER] %s: %v", repo, err)
@@ -580,7 +509,7 @@ func RecentStarsByHourHandler(
 
 		// Cache the full result (including current hour for faster subsequent requests)
 		// The current hour will be overwritten on next fetch with updated data
-		cacheRecentStarsByHour.Set(cacheKey, allHourly, cache.WithExpiration(7*24*time.Hour))
+		cacheRecentStarsByHour.Set(cacheKey, allHourly, time.Now().Add(7*24*time.Hour))
 
 		// Filter to requested period before returning
 		now = time.Now().UTC()
@@ -618,5 +547,63 @@ func RecentStarsByHourHandler(
 		log.Printf("[RESULT] %s: returning %d (excluded: %d before cutoff, %d after now)", repo, len(filtered), beforeCutoff, afterNow)
 
 		return c.JSON(filtered)
+	})
+}
+
+// mergeStars merges recent daily stars into the cached history, skipping today as it is still incomplete,
+// and recalculates the cumulative totals. updated reports whether any day was added or replaced.
+func mergeStars(cachedStars, recentStars []stats.StarsPerDay) (merged []stats.StarsPerDay, updated bool) {
+	// Create a map to hold all stars data (both cached and recent)
+	mergedMap := make(map[string]stats.StarsPerDay)
+	for _, entry := range cachedStars {
+		dayStr := time.Time(entry.Day).Format("02-01-2006")
+		mergedMap[dayStr] = entry
+	}
+
+	// Do not add today when merging recentStars
+	todayStr := time.Now().Format("02-01-2006")
+	for _, entry := range recentStars {
+		entryDayStr := time.Time(entry.Day).Format("02-01-2006")
+		if entryDayStr == todayStr {
+			continue // skip today
+		}
+		mergedMap[entryDayStr] = entry
+		updated = true
+	}
+
+	// Convert map back to slice and sort by date
+	merged = make([]stats.StarsPerDay, 0, len(mergedMap))
+	for _, entry := range mergedMap {
+		merged = append(merged, entry)
 	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		return time.Time(merged[i].Day).Before(time.Time(merged[j].Day))
+	})
+
+	// Recalculate cumulative totals
+	runningTotal := 0
+	for i := range merged {
+		runningTotal += merged[i].Stars
+		merged[i].TotalStars = runningTotal
+	}
+
+	return merged, updated
+}
+
+// newStarsResponse builds the /allStars response for a full daily stars history
+func newStarsResponse(allStars []stats.StarsPerDay) (types.StarsWithStatsResponse, error) {
+	maxPeriods, maxPeaks, err := repostats.FindMaxConsecutivePeriods(allStars, 10)
+	if err != nil {
+		return types.StarsWithStatsResponse{}, err
+	}
+
+	newLastNDays := repostats.NewStarsLastDays(allStars, 10)
+
+	return types.StarsWithStatsResponse{
+		Stars:         allStars,
+		NewLast10Days: newLastNDays,
+		MaxPeriods:    maxPeriods,
+		MaxPeaks:      maxPeaks,
+	}, nil
 }
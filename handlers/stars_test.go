@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emanuelef/github-repo-activity-stats/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func starsDay(daysAgo, stars int) stats.StarsPerDay {
+	now := time.Now()
+	day := time.Date(now.Year(), now.Month(), now.Day()-daysAgo, 0, 0, 0, 0, now.Location())
+	return stats.StarsPerDay{Day: stats.JSONDay(day), Stars: stars}
+}
+
+func TestMergeStars(t *testing.T) {
+	cached := []stats.StarsPerDay{starsDay(5, 10), starsDay(4, 3), starsDay(3, 1)}
+	recent := []stats.StarsPerDay{starsDay(3, 2), starsDay(2, 4), starsDay(1, 5), starsDay(0, 100)}
+
+	merged, updated := mergeStars(cached, recent)
+
+	assert.True(t, updated)
+	// today is incomplete and must not be merged
+	assert.Len(t, merged, 5)
+
+	stars := make([]int, len(merged))
+	totals := make([]int, len(merged))
+	for i, day := range merged {
+		stars[i] = day.Stars
+		totals[i] = day.TotalStars
+	}
+	assert.Equal(t, []int{10, 3, 2, 4, 5}, stars)
+	assert.Equal(t, []int{10, 13, 15, 19, 24}, totals)
+}
+
+func TestMergeStarsNothingNew(t *testing.T) {
+	cached := []stats.StarsPerDay{starsDay(2, 1), starsDay(1, 1)}
+
+	merged, updated := mergeStars(cached, []stats.StarsPerDay{starsDay(0, 3)})
+
+	assert.False(t, updated)
+	assert.Len(t, merged, 2)
+	assert.Equal(t, 2, merged[1].TotalStars)
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/emanuelef/gh-repo-stats-server/warmup"
+	"github.com/gofiber/fiber/v2"
+)
+
+// warmupFetcher runs the cache warmer fetches through the same metric fetchers as the jobs
+type warmupFetcher struct {
+	clientPool *tokens.Pool
+	fetchers   map[string]MetricFetcher
+}
+
+// NewWarmupFetcher adapts the metric fetchers to the cache warmer
+func NewWarmupFetcher(
+	clientPool *tokens.Pool,
+	fetchers map[string]MetricFetcher,
+) warmup.Fetcher {
+	return &warmupFetcher{
+		clientPool: clientPool,
+		fetchers:   fetchers,
+	}
+}
+
+func (w *warmupFetcher) Fresh(repo, metric string) bool {
+	fetcher, ok := w.fetchers[metric]
+	return ok && fetcher.Cached(repo)
+}
+
+func (w *warmupFetcher) Fetch(ctx context.Context, repo, metric string) error {
+	fetcher, ok := w.fetchers[metric]
+	if !ok {
+		return fmt.Errorf("unsupported metric %q", metric)
+	}
+
+	ghStatClients := w.clientPool.ClientsFor(repoid.Host(repo))
+	clientKey, client := SelectBestClient(ctx, ghStatClients, "")
+	if client == nil {
+		return errNoClient
+	}
+
+	return fetcher.Fetch(ctx, ghStatClients, clientKey, client, repo, nil)
+}
+
+// ClientQuota returns the remaining quota of the token of the host of the repo with the most requests left,
+// according to the ClientSelector and ignoring tokens whose circuit breaker is open, and the earliest reset
+// among those tokens. Missing or outdated rate limits are refreshed first.
+func ClientQuota(clientPool *tokens.Pool) warmup.QuotaFunc {
+	return func(ctx context.Context, repo string) (int, time.Time) {
+		ghStatClients := clientPool.ClientsFor(repoid.Host(repo))
+		globalClientSelector.refreshOutdated(ctx, ghStatClients)
+
+		bestRemaining := 0
+		var earliestReset time.Time
+		health := globalClientSelector.GetClientHealth()
+		for key, info := range globalClientSelector.GetClientStats() {
+			if _, ok := ghStatClients[key]; !ok {
+				continue
+			}
+			// A client out of rotation can't be used whatever its quota
+			if h, ok := health[key]; ok && h.State == BreakerOpen {
+				continue
+			}
+			bestRemaining = max(bestRemaining, info.Remaining)
+			if earliestReset.IsZero() || info.ResetAt.Before(earliestReset) {
+				earliestReset = info.ResetAt
+			}
+		}
+
+		return bestRemaining, earliestReset
+	}
+}
+
+// WarmupStatusHandler handles the /admin/warmup endpoint reporting the cache warmer progress
+func WarmupStatusHandler(warmer *warmup.Warmer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if warmer == nil {
+			return c.JSON(fiber.Map{"enabled": false})
+		}
+
+		return c.JSON(fiber.Map{
+			"enabled": true,
+			"status":  warmer.Status(),
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/cache"
+	"github.com/emanuelef/gh-repo-stats-server/inflight"
+	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientQuotaOnlyCountsClientsOfTheRepoHost(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+	reset := time.Now().Add(time.Hour)
+	globalClientSelector.setRateLimit("PAT", &repostats.RateLimit{Limit: 5000, Remaining: 50, ResetAt: reset})
+	globalClientSelector.setRateLimit("GHE_1", &repostats.RateLimit{Limit: 5000, Remaining: 4000, ResetAt: reset})
+
+	pool, err := tokens.NewPool(
+		func() ([]tokens.Token, error) {
+			return []tokens.Token{{Label: "PAT", Value: "a"}, {Label: "GHE_1", Value: "b", BaseURL: "https://ghe.corp/api/v3"}}, nil
+		},
+		func(tokens.Token) *repostats.ClientGQL { return &repostats.ClientGQL{} },
+	)
+	require.NoError(t, err)
+
+	quota := ClientQuota(pool)
+
+	remaining, resetAt := quota(context.Background(), "helm/helm")
+	assert.Equal(t, 50, remaining)
+	assert.True(t, resetAt.Equal(reset))
+
+	remaining, _ = quota(context.Background(), "ghe.corp/team/tool")
+	assert.Equal(t, 4000, remaining)
+}
+
+func TestWarmupFetcherRefreshesStaleHistory(t *testing.T) {
+	globalClientSelector = NewClientSelector()
+
+	pool, err := tokens.NewPool(
+		func() ([]tokens.Token, error) { return []tokens.Token{{Label: "PAT", Value: "a"}}, nil },
+		func(tokens.Token) *repostats.ClientGQL { return &repostats.ClientGQL{} },
+	)
+	require.NoError(t, err)
+
+	stars := cache.NewStaleCache[int](time.Hour)
+	stars.Set("helm/helm", 41, time.Now().Add(-time.Minute))
+
+	var calls []string
+	var onGoing inflight.Group[int]
+	fetcher := newRefreshingFetcher(session.MetricStars, session.NewHub(0), stars, &onGoing,
+		func(ctx context.Context, _ *githubCalls, repo string, c *cache.Cache[int]) (int, error) {
+			calls = append(calls, "fetch "+repo)
+			c.Set(repo, 1, time.Now().Add(time.Hour))
+			return 1, nil
+		},
+		func(ctx context.Context, _ *githubCalls, repo string, stale int, c *cache.Cache[int]) (int, error) {
+			calls = append(calls, "refresh "+repo)
+			c.Set(repo, stale+1, time.Now().Add(time.Hour))
+			return stale + 1, nil
+		},
+		nil)
+	w := NewWarmupFetcher(pool, map[string]MetricFetcher{session.MetricStars: fetcher})
+
+	// The expired history isn't fresh, but only its missing days are fetched
+	assert.False(t, w.Fresh("helm/helm", session.MetricStars))
+	require.NoError(t, w.Fetch(context.Background(), "helm/helm", session.MetricStars))
+	assert.True(t, w.Fresh("helm/helm", session.MetricStars))
+	val, _ := stars.Get("helm/helm")
+	assert.Equal(t, 42, val)
+
+	// A repo that isn't kept at all is downloaded in full
+	require.NoError(t, w.Fetch(context.Background(), "ollama/ollama", session.MetricStars))
+	assert.Equal(t, []string{"refresh helm/helm", "fetch ollama/ollama"}, calls)
+}
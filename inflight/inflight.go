@@ -0,0 +1,138 @@
+package inflight
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Group coordinates concurrent fetches so that only one fetch per key runs at a time.
+// Callers asking for a key that is already being fetched join the running fetch and
+// receive the same result or error. The zero value is ready to use.
+type Group[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[T]
+	// callers counts the callers waiting on each key, and the running fetches themselves
+	callers map[string]int
+}
+
+// call is a running fetch and the callers waiting on it
+type call[T any] struct {
+	done   chan struct{}
+	val    T
+	err    error
+	shared bool
+
+	// waiting is how many callers still wait on the fetch, it is canceled when it drops to 0
+	waiting int
+	cancel  context.CancelFunc
+}
+
+// Do runs fn for key, or waits for the fetch already running for key.
+// shared reports whether the result was delivered to more than one caller.
+func (g *Group[T]) Do(key string, fn func() (T, error)) (v T, err error, shared bool) {
+	return g.DoContext(context.Background(), key, func(context.Context) (T, error) {
+		return fn()
+	})
+}
+
+// DoContext is like Do but stops waiting when ctx is done, returning ctx.Err().
+// fn runs on a context detached from the one of the caller starting it: it keeps running for any other
+// callers waiting on key, and is canceled once every caller gave up on it.
+func (g *Group[T]) DoContext(ctx context.Context, key string, fn func(ctx context.Context) (T, error)) (v T, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call[T])
+	}
+	c, joined := g.calls[key]
+	if joined {
+		c.shared = true
+	} else {
+		fetchCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &call[T]{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+		// Count the fetch itself too, so InFlight stays true until it returns
+		g.trackLocked(key, 1)
+		go g.run(key, c, fetchCtx, fn)
+	}
+	c.waiting++
+	g.trackLocked(key, 1)
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		g.track(key, -1)
+		return c.val, c.err, c.shared
+	case <-ctx.Done():
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.trackLocked(key, -1)
+		if c.waiting--; c.waiting == 0 {
+			c.cancel()
+			// Later callers start a fetch of their own rather than joining the canceled one
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+		}
+		return v, ctx.Err(), false
+	}
+}
+
+// run runs fn for the callers of c. A panic of fn fails the callers rather than the whole process,
+// as nothing up its own goroutine would recover it.
+func (g *Group[T]) run(key string, c *call[T], ctx context.Context, fn func(ctx context.Context) (T, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			var zero T
+			c.val, c.err = zero, fmt.Errorf("inflight %s: panic: %v", key, r)
+		}
+		c.cancel()
+
+		g.mu.Lock()
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+		g.trackLocked(key, -1)
+		g.mu.Unlock()
+
+		close(c.done)
+	}()
+
+	c.val, c.err = fn(ctx)
+}
+
+// InFlight reports whether a fetch for key is currently running.
+func (g *Group[T]) InFlight(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.callers[key] > 0
+}
+
+// Keys returns the keys with a fetch currently running.
+func (g *Group[T]) Keys() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	keys := make([]string, 0, len(g.callers))
+	for key := range g.callers {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (g *Group[T]) track(key string, delta int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.trackLocked(key, delta)
+}
+
+func (g *Group[T]) trackLocked(key string, delta int) {
+	if g.callers == nil {
+		g.callers = make(map[string]int)
+	}
+
+	g.callers[key] += delta
+	if g.callers[key] <= 0 {
+		delete(g.callers, key)
+	}
+}
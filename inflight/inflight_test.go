@@ -0,0 +1,180 @@
+package inflight
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoJoinsRunningFetch(t *testing.T) {
+	var g Group[int]
+	var calls atomic.Int32
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	fetch := func() (int, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return 42, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]int, 5)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0], _, _ = g.Do("helm/helm", fetch)
+	}()
+	<-started
+	assert.True(t, g.InFlight("helm/helm"))
+	assert.Equal(t, []string{"helm/helm"}, g.Keys())
+
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _, _ = g.Do("helm/helm", fetch)
+		}(i)
+	}
+
+	// Wait until every caller has joined before letting the fetch finish
+	for {
+		g.mu.Lock()
+		joined := g.callers["helm/helm"]
+		g.mu.Unlock()
+		// The callers and the fetch itself
+		if joined == len(results)+1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, []int{42, 42, 42, 42, 42}, results)
+	assert.False(t, g.InFlight("helm/helm"))
+	assert.Empty(t, g.Keys())
+}
+
+func TestDoSharesError(t *testing.T) {
+	var g Group[string]
+	errFetch := errors.New("rate limit exceeded")
+
+	v, err, shared := g.Do("helm/helm", func() (string, error) {
+		return "", errFetch
+	})
+
+	assert.Empty(t, v)
+	assert.ErrorIs(t, err, errFetch)
+	assert.False(t, shared)
+	assert.False(t, g.InFlight("helm/helm"))
+}
+
+func TestDoDifferentKeysRunIndependently(t *testing.T) {
+	var g Group[string]
+
+	a, _, _ := g.Do("a/a", func() (string, error) { return "a", nil })
+	b, _, _ := g.Do("b/b", func() (string, error) { return "b", nil })
+
+	assert.Equal(t, "a", a)
+	assert.Equal(t, "b", b)
+}
+
+func TestDoContextCancelsFetchOnceEveryCallerLeft(t *testing.T) {
+	var g Group[int]
+
+	started := make(chan struct{})
+	stopped := make(chan error, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	_, err, _ := g.DoContext(ctx, "helm/helm", func(ctx context.Context) (int, error) {
+		close(started)
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return 0, ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// The only caller left, so the fetch is cancelled and later callers start a new one
+	assert.ErrorIs(t, <-stopped, context.Canceled)
+	v, err, _ := g.DoContext(context.Background(), "helm/helm", func(context.Context) (int, error) {
+		return 7, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, v)
+	assert.Eventually(t, func() bool { return !g.InFlight("helm/helm") }, time.Second, time.Millisecond)
+}
+
+func TestDoContextKeepsFetchForOtherCallers(t *testing.T) {
+	var g Group[int]
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) (int, error) {
+		close(started)
+		select {
+		case <-release:
+			return 42, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	// The caller starting the fetch leaves, one that joined it still gets the result
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err, _ := g.DoContext(ctx, "helm/helm", fetch)
+		errs <- err
+	}()
+	<-started
+
+	results := make(chan int, 1)
+	go func() {
+		v, _, _ := g.Do("helm/helm", func() (int, error) { return 7, nil })
+		results <- v
+	}()
+	assert.Eventually(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.calls["helm/helm"] != nil && g.calls["helm/helm"].waiting == 2
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	close(release)
+	assert.Equal(t, 42, <-results)
+}
+
+func TestDoFailsCallersOnPanic(t *testing.T) {
+	var g Group[int]
+
+	_, err, _ := g.Do("helm/helm", func() (int, error) {
+		var page map[string]int
+		page["stars"]++
+		return 0, nil
+	})
+	assert.ErrorContains(t, err, "inflight helm/helm: panic")
+	assert.False(t, g.InFlight("helm/helm"))
+
+	// The key can be fetched again
+	v, err, _ := g.Do("helm/helm", func() (int, error) { return 42, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, 42, v)
+}
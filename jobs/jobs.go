@@ -0,0 +1,234 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// State is the lifecycle state of a Job
+type State string
+
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateCompleted State = "completed"
+	StateFailed    State = "failed"
+	StateCancelled State = "cancelled"
+)
+
+// Retention is how long finished jobs stay queryable before being pruned
+const Retention = time.Hour
+
+// Job is a background fetch of one metric for one repository
+type Job struct {
+	ID     string
+	Repo   string
+	Metric string
+
+	mu         sync.Mutex
+	state      State
+	progress   int
+	client     string
+	err        string
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+	cancel     context.CancelFunc
+}
+
+// Status is the JSON representation of a Job
+type Status struct {
+	ID         string     `json:"id"`
+	Repo       string     `json:"repo"`
+	Metric     string     `json:"metric"`
+	State      State      `json:"state"`
+	Progress   int        `json:"progress"`
+	Client     string     `json:"client,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// SetProgress records the latest progress value reported by the fetch
+func (j *Job) SetProgress(progress int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.progress = progress
+}
+
+// SetClient records which GitHub client is running the fetch
+func (j *Job) SetClient(client string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.client = client
+}
+
+// Status returns a snapshot of the job
+func (j *Job) Status() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := Status{
+		ID:        j.ID,
+		Repo:      j.Repo,
+		Metric:    j.Metric,
+		State:     j.state,
+		Progress:  j.progress,
+		Client:    j.client,
+		Error:     j.err,
+		CreatedAt: j.createdAt,
+	}
+	if !j.startedAt.IsZero() {
+		startedAt := j.startedAt
+		status.StartedAt = &startedAt
+	}
+	if !j.finishedAt.IsZero() {
+		finishedAt := j.finishedAt
+		status.FinishedAt = &finishedAt
+	}
+
+	return status
+}
+
+// Done reports whether the job reached a final state
+func (j *Job) Done() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.done()
+}
+
+func (j *Job) done() bool {
+	return j.state == StateCompleted || j.state == StateFailed || j.state == StateCancelled
+}
+
+func (j *Job) start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.state == StateQueued {
+		j.state = StateRunning
+		j.startedAt = time.Now()
+	}
+}
+
+func (j *Job) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.done() {
+		return
+	}
+
+	switch {
+	case err == nil:
+		j.state = StateCompleted
+	case errors.Is(err, context.Canceled):
+		j.state = StateCancelled
+	default:
+		j.state = StateFailed
+		j.err = err.Error()
+	}
+	j.finishedAt = time.Now()
+}
+
+// Manager runs jobs in the background and keeps track of them by ID
+type Manager struct {
+	ctx  context.Context
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewManager creates a Manager whose jobs are cancelled when ctx is done
+func NewManager(ctx context.Context) *Manager {
+	return &Manager{
+		ctx:  ctx,
+		jobs: make(map[string]*Job),
+	}
+}
+
+// Start runs fn in the background for repo and metric and returns its job.
+// If a job for the same repo and metric is still active, that job is returned instead
+// and created is false.
+func (m *Manager) Start(repo, metric string, fn func(ctx context.Context, job *Job) error) (job *Job, created bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.prune(time.Now())
+
+	for _, j := range m.jobs {
+		if j.Repo == repo && j.Metric == metric && !j.Done() {
+			return j, false, nil
+		}
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, false, err
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	job = &Job{
+		ID:        id,
+		Repo:      repo,
+		Metric:    metric,
+		state:     StateQueued,
+		createdAt: time.Now(),
+		cancel:    cancel,
+	}
+	m.jobs[id] = job
+
+	go func() {
+		defer cancel()
+		job.start()
+		job.finish(fn(ctx, job))
+	}()
+
+	return job, true, nil
+}
+
+// Get returns the job with the given ID
+func (m *Manager) Get(id string) (*Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+// Cancel stops the job with the given ID. Cancelling a finished job is a no-op.
+func (m *Manager) Cancel(id string) (*Job, bool) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	job.finish(context.Canceled)
+	job.cancel()
+
+	return job, true
+}
+
+// prune drops jobs that finished more than Retention ago. m.mu must be held.
+func (m *Manager) prune(now time.Time) {
+	for id, j := range m.jobs {
+		j.mu.Lock()
+		expired := j.done() && now.Sub(j.finishedAt) > Retention
+		j.mu.Unlock()
+		if expired {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
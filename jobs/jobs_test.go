@@ -0,0 +1,119 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func waitDone(t *testing.T, job *Job) Status {
+	t.Helper()
+	require.Eventually(t, job.Done, time.Second, time.Millisecond)
+	return job.Status()
+}
+
+func TestJobCompletes(t *testing.T) {
+	m := NewManager(context.Background())
+
+	job, created, err := m.Start("helm/helm", "stars", func(ctx context.Context, job *Job) error {
+		job.SetClient("PAT")
+		job.SetProgress(100)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	status := waitDone(t, job)
+	assert.Equal(t, StateCompleted, status.State)
+	assert.Equal(t, 100, status.Progress)
+	assert.Equal(t, "PAT", status.Client)
+	assert.NotNil(t, status.StartedAt)
+	assert.NotNil(t, status.FinishedAt)
+
+	got, ok := m.Get(job.ID)
+	assert.True(t, ok)
+	assert.Same(t, job, got)
+}
+
+func TestJobFails(t *testing.T) {
+	m := NewManager(context.Background())
+
+	job, _, err := m.Start("helm/helm", "issues", func(ctx context.Context, job *Job) error {
+		return errors.New("rate limit exceeded")
+	})
+	require.NoError(t, err)
+
+	status := waitDone(t, job)
+	assert.Equal(t, StateFailed, status.State)
+	assert.Equal(t, "rate limit exceeded", status.Error)
+}
+
+func TestStartReusesActiveJob(t *testing.T) {
+	m := NewManager(context.Background())
+	release := make(chan struct{})
+
+	run := func(ctx context.Context, job *Job) error {
+		<-release
+		return nil
+	}
+
+	first, created, err := m.Start("helm/helm", "stars", run)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	second, created, err := m.Start("helm/helm", "stars", run)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Same(t, first, second)
+
+	other, created, err := m.Start("helm/helm", "forks", run)
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.NotEqual(t, first.ID, other.ID)
+
+	close(release)
+	waitDone(t, first)
+	waitDone(t, other)
+}
+
+func TestCancelStopsJob(t *testing.T) {
+	m := NewManager(context.Background())
+	stopped := make(chan struct{})
+
+	job, _, err := m.Start("helm/helm", "stars", func(ctx context.Context, job *Job) error {
+		<-ctx.Done()
+		close(stopped)
+		return ctx.Err()
+	})
+	require.NoError(t, err)
+
+	_, ok := m.Cancel(job.ID)
+	assert.True(t, ok)
+	<-stopped
+
+	assert.Equal(t, StateCancelled, job.Status().State)
+
+	_, ok = m.Cancel("missing")
+	assert.False(t, ok)
+}
+
+func TestPruneDropsOldJobs(t *testing.T) {
+	m := NewManager(context.Background())
+
+	job, _, err := m.Start("helm/helm", "stars", func(ctx context.Context, job *Job) error {
+		return nil
+	})
+	require.NoError(t, err)
+	waitDone(t, job)
+
+	m.mu.Lock()
+	m.prune(time.Now().Add(Retention + time.Minute))
+	m.mu.Unlock()
+
+	_, ok := m.Get(job.ID)
+	assert.False(t, ok)
+}
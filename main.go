@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
-	cache "github.com/Code-Hex/go-generics-cache"
 	"github.com/gofiber/contrib/otelfiber"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/compress"
@@ -16,71 +18,137 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/pprof"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 
+	"github.com/emanuelef/gh-repo-stats-server/cache"
+	"github.com/emanuelef/gh-repo-stats-server/config"
+	"github.com/emanuelef/gh-repo-stats-server/handlers"
+	"github.com/emanuelef/gh-repo-stats-server/jobs"
 	"github.com/emanuelef/gh-repo-stats-server/news"
 	"github.com/emanuelef/gh-repo-stats-server/otel_instrumentation"
 	"github.com/emanuelef/gh-repo-stats-server/routes"
 	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
 	"github.com/emanuelef/gh-repo-stats-server/types"
 	"github.com/emanuelef/gh-repo-stats-server/utils"
+	"github.com/emanuelef/gh-repo-stats-server/warmup"
 	"github.com/emanuelef/github-repo-activity-stats/repostats"
 	"github.com/emanuelef/github-repo-activity-stats/stats"
 	_ "github.com/joho/godotenv/autoload"
 )
 
 var (
-	currentSessions      session.SessionsLock
+	progressHub          = session.NewHub(session.DefaultBufferSize)
 	allStarsRequestStats types.RequestStats
 )
 
 // getRecentStarsByHourHandler handles API requests for hourly stars
 func main() {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	tp, exp, err := otel_instrumentation.InitializeGlobalTracerProvider(ctx)
 
 	// Handle shutdown to ensure all sub processes are closed correctly and telemetry is exported
 	defer func() {
-		_ = exp.Shutdown(ctx)
-		_ = tp.Shutdown(ctx)
+		_ = exp.Shutdown(context.Background())
+		_ = tp.Shutdown(context.Background())
 	}()
 
 	if err != nil {
 		log.Fatalf("failed to initialize OpenTelemetry: %e", err)
 	}
 
-	cacheOverall := cache.New[string, *stats.RepoStats]()
-	cacheStars := cache.New[string, types.StarsWithStatsResponse]()
-	cacheIssues := cache.New[string, types.IssuesWithStatsResponse]()
-	cacheForks := cache.New[string, types.ForksWithStatsResponse]()
-	cachePRs := cache.New[string, types.PRsWithStatsResponse]()
-	cacheCommits := cache.New[string, types.CommitsWithStatsResponse]()
-	cacheContributors := cache.New[string, types.ContributorsWithStatsResponse]()
-	cacheNewRepos := cache.New[string, types.NewReposWithStatsResponse]()
-	cacheNewPRs := cache.New[string, types.NewPRsWithStatsResponse]()
-
-	cacheHackerNews := cache.New[string, []news.Article]()
-	cacheReddit := cache.New[string, []news.ArticleData]()
-	cacheYouTube := cache.New[string, []news.YTVideoMetadata]()
-	cacheReleases := cache.New[string, []stats.ReleaseInfo]()
-	cacheShowHN := cache.New[string, []news.ShowHNPost]()
-	cacheRedditGitHub := cache.New[string, []news.RedditGitHubPost]()
-	cacheRecentStarsByHour := cache.New[string, []types.HourlyStars]()
-	cacheGitHubMentions := cache.New[string, types.GitHubMentionsResponse]()
-
-	onGoingStars := make(map[string]bool)
-	onGoingIssues := make(map[string]bool)
-	onGoingForks := make(map[string]bool)
-	onGoingPRs := make(map[string]bool)
-	onGoingCommits := make(map[string]bool)
-	onGoingContributors := make(map[string]bool)
-	onGoingNewRepos := make(map[string]bool)
-	onGoingNewPRs := make(map[string]bool)
-
-	ghStatClients := make(map[string]*repostats.ClientGQL)
-
-	ghStatClients["PAT"] = utils.NewClientWithPAT(os.Getenv("PAT"))
-	if pat2 := os.Getenv("PAT2"); pat2 != "" {
-		ghStatClients["PAT2"] = utils.NewClientWithPAT(pat2)
+	mp, err := otel_instrumentation.InitializeGlobalMeterProvider(ctx)
+	if err != nil {
+		log.Fatalf("failed to initialize OpenTelemetry metrics: %e", err)
+	}
+	defer func() {
+		_ = mp.Shutdown(context.Background())
+	}()
+
+	cacheOverall := cache.NewCache[*stats.RepoStats]()
+	cacheStars := cache.NewStaleCache[types.StarsWithStatsResponse](config.DayStaleServed * 24 * time.Hour)
+	cacheIssues := cache.NewCache[types.IssuesWithStatsResponse]()
+	cacheForks := cache.NewCache[types.ForksWithStatsResponse]()
+	cachePRs := cache.NewCache[types.PRsWithStatsResponse]()
+	cacheCommits := cache.NewCache[types.CommitsWithStatsResponse]()
+	cacheContributors := cache.NewCache[types.ContributorsWithStatsResponse]()
+	cacheNewRepos := cache.NewCache[types.NewReposWithStatsResponse]()
+	cacheNewPRs := cache.NewCache[types.NewPRsWithStatsResponse]()
+
+	cacheHackerNews := cache.NewCache[[]news.Article]()
+	cacheReddit := cache.NewCache[[]news.ArticleData]()
+	cacheYouTube := cache.NewCache[[]news.YTVideoMetadata]()
+	cacheReleases := cache.NewCache[[]stats.ReleaseInfo]()
+	cacheShowHN := cache.NewCache[[]news.ShowHNPost]()
+	cacheRedditGitHub := cache.NewCache[[]news.RedditGitHubPost]()
+	cacheRecentStarsByHour := cache.NewCache[[]types.HourlyStars]()
+	cacheGitHubMentions := cache.NewCache[types.GitHubMentionsResponse]()
+	cacheCreatedAt := cache.NewCache[time.Time]()
+
+	// Caches holding data that is expensive to fetch from GitHub are saved to disk
+	// periodically and on shutdown, and reloaded on startup.
+	persisted := map[string]cache.Snapshotter{
+		"stars":             cacheStars,
+		"issues":            cacheIssues,
+		"forks":             cacheForks,
+		"prs":               cachePRs,
+		"commits":           cacheCommits,
+		"contributors":      cacheContributors,
+		"newRepos":          cacheNewRepos,
+		"newPRs":            cacheNewPRs,
+		"releases":          cacheReleases,
+		"recentStarsByHour": cacheRecentStarsByHour,
+	}
+
+	persisterDone := make(chan struct{})
+	if dataDir := utils.GetEnv("CACHE_DIR", "data"); dataDir != "" {
+		persister := cache.NewPersister(cache.DirBackend{Dir: dataDir})
+		for name, c := range persisted {
+			if err := persister.Register(name, c); err != nil {
+				log.Printf("Error restoring %s cache: %v", name, err)
+			}
+		}
+		log.Printf("Cache persistence enabled in %s", dataDir)
+
+		go func() {
+			persister.Run(ctx, config.CacheSnapshotInterval)
+			close(persisterDone)
+		}()
+	} else {
+		close(persisterDone)
+	}
+
+	go cache.RunJanitor(ctx, time.Minute,
+		cacheOverall, cacheStars, cacheIssues, cacheForks, cachePRs, cacheCommits, cacheContributors,
+		cacheNewRepos, cacheNewPRs, cacheHackerNews, cacheReddit, cacheYouTube, cacheReleases,
+		cacheShowHN, cacheRedditGitHub, cacheRecentStarsByHour, cacheGitHubMentions, cacheCreatedAt)
+
+	// GitHub clients, one per token from PAT, PAT2, PAT_1..PAT_n, GITHUB_TOKENS, GITHUB_TOKENS_FILE and
+	// GITHUB_ENTERPRISE_TOKENS, plus one per GitHub App installation. GITHUB_TOKENS_FILE is reloaded periodically
+	// and on SIGHUP, the tokens of the environment need a restart. Every response updates the rate limit of its
+	// client, see handlers.ObserveRateLimit
+	clientPool, err := tokens.NewPool(tokens.FromEnv, func(t tokens.Token) *repostats.ClientGQL {
+		return utils.NewClientWithPAT(t.BaseURL, t.Value, handlers.ObserveRateLimit(t.Label))
+	})
+	if err != nil {
+		log.Fatalf("failed to load GitHub tokens: %v", err)
+	}
+	// Tokens removed or replaced on reload take their rate limits and breakers with them
+	clientPool.OnForget(handlers.ForgetClients)
+
+	// GitHub App installations authenticate with short-lived tokens instead of PATs
+	apps, err := utils.GitHubAppsFromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure GitHub App: %v", err)
+	}
+	for _, app := range apps {
+		clientPool.AddClient(app.Label(), app.BaseURL, utils.NewClientWithApp(app, handlers.ObserveRateLimit(app.Label())))
 	}
+	log.Printf("GitHub token pool: %s", strings.Join(clientPool.Labels(), ", "))
+
+	reloadTokens := make(chan os.Signal, 1)
+	signal.Notify(reloadTokens, syscall.SIGHUP)
+	go clientPool.Watch(ctx, config.TokenReloadInterval, reloadTokens)
 
 	app := fiber.New()
 
@@ -116,6 +184,23 @@ func main() {
 		},
 	})
 
+	// Only creating jobs starts GitHub fetches, polling job status is not limited
+	rateLimiterJobs := limiter.New(limiter.Config{
+		Max:        120,           // Maximum number of requests allowed per hour
+		Expiration: 1 * time.Hour, // Duration for the rate limit window
+		Next: func(c *fiber.Ctx) bool {
+			return c.Method() != fiber.MethodPost
+		},
+		KeyGenerator: func(c *fiber.Ctx) string {
+			ip := c.Get("X-Forwarded-For")
+			// If X-Forwarded-For is empty, fallback to RemoteIP
+			if ip == "" {
+				ip = "unknown"
+			}
+			return ip
+		},
+	})
+
 	app.Use(recover.New())
 	app.Use(cors.New())
 	app.Use(compress.New())
@@ -128,6 +213,12 @@ func main() {
 	app.Use("/hackernews", rateLimiterFeed)
 	app.Use("/ghmentions", rateLimiterFeed)
 	app.Use("/allReleases", rateLimiter)
+	app.Use("/jobs", rateLimiterJobs)
+	app.Use("/estimate", rateLimiter)
+	app.Use("/compare", rateLimiter)
+	app.Use("/forecast", rateLimiter)
+	app.Use("/spikes", rateLimiter)
+	app.Use("/milestones", rateLimiter)
 
 	// Initialize caches struct
 	caches := &routes.Caches{
@@ -148,19 +239,11 @@ func main() {
 		RedditGitHub:      cacheRedditGitHub,
 		RecentStarsByHour: cacheRecentStarsByHour,
 		GitHubMentions:    cacheGitHubMentions,
+		CreatedAt:         cacheCreatedAt,
 	}
 
-	// Initialize ongoing operations map
-	onGoingMaps := &routes.OnGoingMaps{
-		Stars:        onGoingStars,
-		Issues:       onGoingIssues,
-		Forks:        onGoingForks,
-		PRs:          onGoingPRs,
-		Commits:      onGoingCommits,
-		Contributors: onGoingContributors,
-		NewRepos:     onGoingNewRepos,
-		NewPRs:       onGoingNewPRs,
-	}
+	// Initialize ongoing fetch coordination
+	onGoing := &routes.OnGoingFetches{}
 
 	// Register system routes
 	routes.RegisterSystemRoutes(app)
@@ -172,35 +255,99 @@ func main() {
 	app.Static("/daily-stars-explorer/assets", "./website/dist/assets")
 
 	// Register news routes
-	routes.RegisterNewsRoutes(app, caches)
+	routes.RegisterNewsRoutes(app, caches, clientPool)
 
 	// Register GitHub stats routes
-	routes.RegisterGitHubStatsRoutes(app, ctx, ghStatClients, caches)
+	routes.RegisterGitHubStatsRoutes(app, ctx, clientPool, caches)
 
 	// Register cache routes
-	routes.RegisterCacheRoutes(app, caches, onGoingStars)
+	routes.RegisterCacheRoutes(app, caches, onGoing)
 
 	// Register request stats routes
 	routes.RegisterRequestStatsRoutes(app, &allStarsRequestStats)
 
 	// Register stars routes
-	routes.RegisterStarsRoutes(app, ctx, ghStatClients, caches, onGoingStars, &currentSessions, &allStarsRequestStats)
+	routes.RegisterStarsRoutes(app, ctx, clientPool, caches, onGoing, progressHub, &allStarsRequestStats)
 
 	// Register repository activity routes
-	routes.RegisterRepoActivityRoutes(app, ctx, ghStatClients, caches, onGoingMaps, &currentSessions)
+	routes.RegisterRepoActivityRoutes(app, ctx, clientPool, caches, onGoing, progressHub)
 
 	// Register SSE routes
-	routes.RegisterSSERoutes(app, &currentSessions)
+	routes.RegisterSSERoutes(app, progressHub)
+
+	fetchers := routes.MetricFetchers(caches, onGoing, progressHub)
+
+	jobManager := jobs.NewManager(ctx)
+
+	// Register async job routes
+	routes.RegisterJobRoutes(app, ctx, clientPool, fetchers, jobManager)
+
+	// Register repo comparison routes
+	routes.RegisterCompareRoutes(app, ctx, clientPool, caches, fetchers, jobManager)
+
+	// Register stars forecast routes
+	routes.RegisterForecastRoutes(app, ctx, clientPool, fetchers, jobManager)
+
+	// Register stars spikes routes
+	routes.RegisterSpikesRoutes(app, ctx, clientPool, caches, fetchers, jobManager)
+
+	// Register stars milestones routes
+	routes.RegisterMilestonesRoutes(app, ctx, clientPool, fetchers, jobManager)
+
+	// Register fetch estimate routes
+	routes.RegisterEstimateRoutes(app, ctx, clientPool, fetchers)
+
+	// Start the cache warmer, on the shipped repo list unless another one is configured. An empty
+	// WARMUP_REPOS_FILE disables it.
+	var warmer *warmup.Warmer
+	if reposFile := utils.GetEnv("WARMUP_REPOS_FILE", "scripts/preloaded-repositories.txt"); reposFile != "" {
+		repos, err := warmup.ReadRepoList(reposFile)
+		if err != nil {
+			log.Printf("Error reading warmup repo list %s: %v", reposFile, err)
+		} else {
+			var metrics []string
+			for _, metric := range strings.Split(utils.GetEnv("WARMUP_METRICS", "stars"), ",") {
+				metric = strings.ToLower(strings.TrimSpace(metric))
+				if _, ok := fetchers[metric]; !ok {
+					log.Printf("Ignoring unsupported warmup metric %q", metric)
+					continue
+				}
+				metrics = append(metrics, metric)
+			}
+
+			warmer = warmup.New(warmup.Config{
+				Repos:         repos,
+				Metrics:       metrics,
+				Pause:         config.WarmupPause,
+				CycleInterval: config.WarmupCycleInterval,
+				MinRemaining:  config.WarmupMinRemaining,
+			}, handlers.NewWarmupFetcher(clientPool, fetchers), handlers.ClientQuota(clientPool))
+			go warmer.Run(ctx)
+			log.Printf("Cache warmer enabled for %d repos (%s)", len(repos), strings.Join(metrics, ", "))
+		}
+	}
+
+	// Register cache warmer routes
+	routes.RegisterWarmupRoutes(app, warmer)
 
 	// Register limits routes
-	routes.RegisterLimitsRoutes(app, ctx, ghStatClients)
+	routes.RegisterLimitsRoutes(app, ctx, clientPool)
 
 	host := utils.GetEnv("HOST", "0.0.0.0")
 	port := utils.GetEnv("PORT", "8080")
 	hostAddress := fmt.Sprintf("%s:%s", host, port)
 
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down")
+		_ = app.Shutdown()
+	}()
+
 	err = app.Listen(hostAddress)
 	if err != nil {
 		log.Panic(err)
 	}
+
+	// Wait for the final cache snapshot before exiting
+	<-persisterDone
 }
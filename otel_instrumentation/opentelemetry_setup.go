@@ -8,9 +8,11 @@ import (
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
@@ -25,17 +27,7 @@ func InitializeGlobalTracerProvider(ctx context.Context) (*sdktrace.TracerProvid
 		log.Fatalf("failed to initialize exporter: %e", err)
 	}
 
-	resource, rErr := resource.Merge(
-		resource.Default(),
-		resource.NewWithAttributes(
-			semconv.SchemaURL,
-			attribute.String("environment", "test"),
-		),
-	)
-
-	if rErr != nil {
-		panic(rErr)
-	}
+	resource := newResource()
 
 	// Create a new tracer provider with a batch span processor and the otlp exporter
 	tp := sdktrace.NewTracerProvider(
@@ -57,3 +49,40 @@ func InitializeGlobalTracerProvider(ctx context.Context) (*sdktrace.TracerProvid
 
 	return tp, exp, nil
 }
+
+// Used to initialise the global OpenTelemetry meter provider, exporting the metrics of the server
+// (e.g. the rate limits of the GitHub clients) next to the traces
+func InitializeGlobalMeterProvider(ctx context.Context) (*sdkmetric.MeterProvider, error) {
+	// Configured by the same environment variables as the trace exporter
+	exp, err := otlpmetricgrpc.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Shutting the meter provider down flushes and shuts down the exporter too
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp)),
+		sdkmetric.WithResource(newResource()),
+	)
+
+	// Register the global Meter provider, the instruments created before it was set are forwarded to it
+	otel.SetMeterProvider(mp)
+
+	return mp, nil
+}
+
+// newResource describes the server in the exported traces and metrics
+func newResource() *resource.Resource {
+	resource, rErr := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(
+			semconv.SchemaURL,
+			attribute.String("environment", "test"),
+		),
+	)
+
+	if rErr != nil {
+		panic(rErr)
+	}
+	return resource
+}
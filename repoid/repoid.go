@@ -0,0 +1,75 @@
+package repoid
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// PublicHost is the host of the repos on github.com, which are identified without it: owner/name.
+// Repos on a GitHub Enterprise Server instance are identified with its host: ghe.corp/team/repo.
+const PublicHost = "github.com"
+
+// publicAPIHost serves the API of github.com
+const publicAPIHost = "api.github.com"
+
+// Normalize returns the ID repo is known by in the caches, SSE topics and exports: lowercased, without
+// scheme, and without host when on github.com, e.g. https://github.com/Helm/Helm is helm/helm.
+func Normalize(repo string) string {
+	repo = strings.ToLower(strings.TrimSpace(repo))
+	repo = strings.TrimPrefix(repo, "https://")
+	repo = strings.TrimPrefix(repo, "http://")
+	repo = strings.Trim(repo, "/")
+
+	host, path := Split(repo)
+	if host == PublicHost {
+		return path
+	}
+	return host + "/" + path
+}
+
+// Split returns the host of the repo ID and the owner/name path the API of that host knows the repo by
+func Split(repo string) (host, path string) {
+	first, rest, found := strings.Cut(repo, "/")
+	// Owners can't have dots or colons in their names, hosts always have one of them
+	if !found || !strings.Contains(rest, "/") || !strings.ContainsAny(first, ".:") {
+		return PublicHost, repo
+	}
+	if first == "www."+PublicHost {
+		first = PublicHost
+	}
+	return first, rest
+}
+
+// Host returns the host of the repo ID
+func Host(repo string) string {
+	host, _ := Split(repo)
+	return host
+}
+
+// Path returns the owner/name path of the repo ID, as passed to the GitHub API
+func Path(repo string) string {
+	_, path := Split(repo)
+	return path
+}
+
+// BaseURLHost returns the host whose repos are served by the API at baseURL: PublicHost for an empty
+// baseURL or the github.com API, the host of baseURL otherwise, e.g. ghe.corp for https://ghe.corp/api/v3
+func BaseURLHost(baseURL string) (string, error) {
+	if baseURL == "" {
+		return PublicHost, nil
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid GitHub base URL %q: %w", baseURL, err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", fmt.Errorf("invalid GitHub base URL %q: expected http(s)://host[/api/v3]", baseURL)
+	}
+
+	host := strings.ToLower(u.Host)
+	if host == publicAPIHost || host == PublicHost {
+		return PublicHost, nil
+	}
+	return host, nil
+}
@@ -0,0 +1,57 @@
+package repoid
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	for in, want := range map[string]string{
+		"Helm/Helm":                          "helm/helm",
+		" helm/helm/ ":                       "helm/helm",
+		"github.com/helm/helm":               "helm/helm",
+		"https://github.com/Helm/Helm":       "helm/helm",
+		"www.github.com/helm/helm":           "helm/helm",
+		"GHE.corp/Team/Repo":                 "ghe.corp/team/repo",
+		"https://ghe.corp/team/repo":         "ghe.corp/team/repo",
+		"ghe.corp:8443/team/repo":            "ghe.corp:8443/team/repo",
+		"2024-01-01_2024-02-01":              "2024-01-01_2024-02-01",
+		"kubernetes-sigs/cluster-api-addons": "kubernetes-sigs/cluster-api-addons",
+	} {
+		assert.Equal(t, want, Normalize(in), in)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	host, path := Split("helm/helm")
+	assert.Equal(t, PublicHost, host)
+	assert.Equal(t, "helm/helm", path)
+
+	host, path = Split("ghe.corp/team/repo")
+	assert.Equal(t, "ghe.corp", host)
+	assert.Equal(t, "team/repo", path)
+
+	assert.Equal(t, "team/repo", Path("ghe.corp/team/repo"))
+	assert.Equal(t, "ghe.corp", Host("ghe.corp/team/repo"))
+}
+
+func TestBaseURLHost(t *testing.T) {
+	for baseURL, want := range map[string]string{
+		"":                             PublicHost,
+		"https://api.github.com":       PublicHost,
+		"https://ghe.corp/api/v3":      "ghe.corp",
+		"https://GHE.corp":             "ghe.corp",
+		"http://ghe.local:8080/api/v3": "ghe.local:8080",
+	} {
+		host, err := BaseURLHost(baseURL)
+		require.NoError(t, err, baseURL)
+		assert.Equal(t, want, host, baseURL)
+	}
+
+	for _, invalid := range []string{"ghe.corp", "ftp://ghe.corp", "https://"} {
+		_, err := BaseURLHost(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -2,48 +2,53 @@ package routes
 
 import (
 	"context"
+	"time"
 
-	cache "github.com/Code-Hex/go-generics-cache"
+	"github.com/emanuelef/gh-repo-stats-server/cache"
 	"github.com/emanuelef/gh-repo-stats-server/handlers"
+	"github.com/emanuelef/gh-repo-stats-server/inflight"
+	"github.com/emanuelef/gh-repo-stats-server/jobs"
 	"github.com/emanuelef/gh-repo-stats-server/news"
 	"github.com/emanuelef/gh-repo-stats-server/session"
+	"github.com/emanuelef/gh-repo-stats-server/tokens"
 	"github.com/emanuelef/gh-repo-stats-server/types"
-	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"github.com/emanuelef/gh-repo-stats-server/warmup"
 	"github.com/emanuelef/github-repo-activity-stats/stats"
 	"github.com/gofiber/fiber/v2"
 )
 
 // Caches holds all the cache instances used by the application
 type Caches struct {
-	Overall           *cache.Cache[string, *stats.RepoStats]
-	Stars             *cache.Cache[string, types.StarsWithStatsResponse]
-	Issues            *cache.Cache[string, types.IssuesWithStatsResponse]
-	Forks             *cache.Cache[string, types.ForksWithStatsResponse]
-	PRs               *cache.Cache[string, types.PRsWithStatsResponse]
-	Commits           *cache.Cache[string, types.CommitsWithStatsResponse]
-	Contributors      *cache.Cache[string, types.ContributorsWithStatsResponse]
-	NewRepos          *cache.Cache[string, types.NewReposWithStatsResponse]
-	NewPRs            *cache.Cache[string, types.NewPRsWithStatsResponse]
-	HackerNews        *cache.Cache[string, []news.Article]
-	Reddit            *cache.Cache[string, []news.ArticleData]
-	YouTube           *cache.Cache[string, []news.YTVideoMetadata]
-	Releases          *cache.Cache[string, []stats.ReleaseInfo]
-	ShowHN            *cache.Cache[string, []news.ShowHNPost]
-	RedditGitHub      *cache.Cache[string, []news.RedditGitHubPost]
-	RecentStarsByHour *cache.Cache[string, []types.HourlyStars]
-	GitHubMentions    *cache.Cache[string, types.GitHubMentionsResponse]
-}
-
-// OnGoingMaps holds all the ongoing operation tracking maps
-type OnGoingMaps struct {
-	Stars        map[string]bool
-	Issues       map[string]bool
-	Forks        map[string]bool
-	PRs          map[string]bool
-	Commits      map[string]bool
-	Contributors map[string]bool
-	NewRepos     map[string]bool
-	NewPRs       map[string]bool
+	Overall           *cache.Cache[*stats.RepoStats]
+	Stars             *cache.Cache[types.StarsWithStatsResponse]
+	Issues            *cache.Cache[types.IssuesWithStatsResponse]
+	Forks             *cache.Cache[types.ForksWithStatsResponse]
+	PRs               *cache.Cache[types.PRsWithStatsResponse]
+	Commits           *cache.Cache[types.CommitsWithStatsResponse]
+	Contributors      *cache.Cache[types.ContributorsWithStatsResponse]
+	NewRepos          *cache.Cache[types.NewReposWithStatsResponse]
+	NewPRs            *cache.Cache[types.NewPRsWithStatsResponse]
+	HackerNews        *cache.Cache[[]news.Article]
+	Reddit            *cache.Cache[[]news.ArticleData]
+	YouTube           *cache.Cache[[]news.YTVideoMetadata]
+	Releases          *cache.Cache[[]stats.ReleaseInfo]
+	ShowHN            *cache.Cache[[]news.ShowHNPost]
+	RedditGitHub      *cache.Cache[[]news.RedditGitHubPost]
+	RecentStarsByHour *cache.Cache[[]types.HourlyStars]
+	GitHubMentions    *cache.Cache[types.GitHubMentionsResponse]
+	CreatedAt         *cache.Cache[time.Time]
+}
+
+// OnGoingFetches coordinates the long-running fetches so concurrent requests for the same key share one
+type OnGoingFetches struct {
+	Stars        inflight.Group[types.StarsWithStatsResponse]
+	Issues       inflight.Group[types.IssuesWithStatsResponse]
+	Forks        inflight.Group[types.ForksWithStatsResponse]
+	PRs          inflight.Group[types.PRsWithStatsResponse]
+	Commits      inflight.Group[types.CommitsWithStatsResponse]
+	Contributors inflight.Group[types.ContributorsWithStatsResponse]
+	NewRepos     inflight.Group[types.NewReposWithStatsResponse]
+	NewPRs       inflight.Group[types.NewPRsWithStatsResponse]
 }
 
 // RegisterSystemRoutes registers system-related routes
@@ -56,35 +61,35 @@ func RegisterSystemRoutes(app *fiber.App) {
 }
 
 // RegisterNewsRoutes registers news-related routes
-func RegisterNewsRoutes(app *fiber.App, caches *Caches) {
+func RegisterNewsRoutes(app *fiber.App, caches *Caches, clientPool *tokens.Pool) {
 	app.Get("/hackernews", handlers.HackerNewsHandler(caches.HackerNews))
 	app.Get("/reddit", handlers.RedditHandler(caches.Reddit))
 	app.Get("/youtube", handlers.YouTubeHandler(caches.YouTube))
 	app.Get("/showhn", handlers.ShowHNHandler(caches.ShowHN))
 	app.Get("/redditrepos", handlers.RedditReposHandler(caches.RedditGitHub))
-	app.Get("/ghmentions", handlers.GitHubMentionsHandler(caches.GitHubMentions))
+	app.Get("/ghmentions", handlers.GitHubMentionsHandler(clientPool, caches.GitHubMentions))
 }
 
 // RegisterGitHubStatsRoutes registers GitHub statistics routes
 func RegisterGitHubStatsRoutes(
 	app *fiber.App,
 	ctx context.Context,
-	ghStatClients map[string]*repostats.ClientGQL,
+	clientPool *tokens.Pool,
 	caches *Caches,
 ) {
-	app.Get("/stats", handlers.StatsHandler(ctx, ghStatClients, caches.Overall))
-	app.Get("/totalStars", handlers.TotalStarsHandler(ctx, ghStatClients))
-	app.Get("/allReleases", handlers.AllReleasesHandler(ctx, ghStatClients, caches.Releases))
+	app.Get("/stats", handlers.StatsHandler(ctx, clientPool, caches.Overall))
+	app.Get("/totalStars", handlers.TotalStarsHandler(ctx, clientPool))
+	app.Get("/allReleases", handlers.AllReleasesHandler(ctx, clientPool, caches.Releases))
 }
 
 // RegisterCacheRoutes registers cache management routes
-func RegisterCacheRoutes(app *fiber.App, caches *Caches, onGoingStars map[string]bool) {
+func RegisterCacheRoutes(app *fiber.App, caches *Caches, onGoing *OnGoingFetches) {
 	app.Get("/allKeys", handlers.AllKeysHandler(caches.Overall))
 	app.Get("/allStarsKeys", handlers.AllStarsKeysHandler(caches.Stars))
 	app.Get("/allReleasesKeys", handlers.AllReleasesKeysHandler(caches.Releases))
 	app.Post("/cleanAllCache", handlers.CleanAllCacheHandler(caches.Overall, caches.Stars))
 	app.Get("/allStarsCsv", handlers.AllStarsCSVHandler(caches.Stars))
-	app.Get("/status", handlers.StatusHandler(caches.Stars, onGoingStars))
+	app.Get("/status", handlers.StatusHandler(caches.Stars, &onGoing.Stars))
 	app.Get("/deleteRecentStarsCache", handlers.DeleteRecentStarsCacheHandler(caches.Stars))
 }
 
@@ -97,27 +102,27 @@ func RegisterRequestStatsRoutes(app *fiber.App, allStarsRequestStats *types.Requ
 func RegisterStarsRoutes(
 	app *fiber.App,
 	ctx context.Context,
-	ghStatClients map[string]*repostats.ClientGQL,
+	clientPool *tokens.Pool,
 	caches *Caches,
-	onGoingStars map[string]bool,
-	currentSessions *session.SessionsLock,
+	onGoing *OnGoingFetches,
+	progressHub *session.Hub,
 	requestStats *types.RequestStats,
 ) {
 	app.Get("/allStars", handlers.AllStarsHandler(
-		ghStatClients,
+		clientPool,
 		caches.Stars,
-		onGoingStars,
-		currentSessions,
+		&onGoing.Stars,
+		progressHub,
 		requestStats,
 		ctx,
 	))
 	app.Get("/recentStars", handlers.RecentStarsHandler(
-		ghStatClients,
+		clientPool,
 		caches.Stars,
 		ctx,
 	))
 	app.Get("/recentStarsByHour", handlers.RecentStarsByHourHandler(
-		ghStatClients,
+		clientPool,
 		caches.RecentStarsByHour,
 	))
 }
@@ -126,72 +131,159 @@ func RegisterStarsRoutes(
 func RegisterRepoActivityRoutes(
 	app *fiber.App,
 	ctx context.Context,
-	ghStatClients map[string]*repostats.ClientGQL,
+	clientPool *tokens.Pool,
 	caches *Caches,
-	onGoingMaps *OnGoingMaps,
-	currentSessions *session.SessionsLock,
+	onGoing *OnGoingFetches,
+	progressHub *session.Hub,
 ) {
 	app.Get("/allIssues", handlers.AllIssuesHandler(
-		ghStatClients,
+		clientPool,
 		caches.Issues,
-		onGoingMaps.Issues,
-		currentSessions,
+		&onGoing.Issues,
+		progressHub,
 		ctx,
 	))
 	app.Get("/allForks", handlers.AllForksHandler(
-		ghStatClients,
+		clientPool,
 		caches.Forks,
-		onGoingMaps.Forks,
-		currentSessions,
+		&onGoing.Forks,
+		progressHub,
 		ctx,
 	))
 	app.Get("/allPRs", handlers.AllPRsHandler(
-		ghStatClients,
+		clientPool,
 		caches.PRs,
-		onGoingMaps.PRs,
-		currentSessions,
+		&onGoing.PRs,
+		progressHub,
 		ctx,
 	))
 	app.Get("/allCommits", handlers.AllCommitsHandler(
-		ghStatClients,
+		clientPool,
 		caches.Commits,
-		onGoingMaps.Commits,
-		currentSessions,
+		&onGoing.Commits,
+		progressHub,
 		ctx,
 	))
 	app.Get("/allContributors", handlers.AllContributorsHandler(
-		ghStatClients,
+		clientPool,
 		caches.Contributors,
-		onGoingMaps.Contributors,
-		currentSessions,
+		&onGoing.Contributors,
+		progressHub,
 		ctx,
 	))
 	app.Get("/newRepos", handlers.NewReposHandler(
-		ghStatClients,
+		clientPool,
 		caches.NewRepos,
-		onGoingMaps.NewRepos,
-		currentSessions,
+		&onGoing.NewRepos,
+		progressHub,
 		ctx,
 	))
 	app.Get("/newPRs", handlers.NewPRsHandler(
-		ghStatClients,
+		clientPool,
 		caches.NewPRs,
-		onGoingMaps.NewPRs,
-		currentSessions,
+		&onGoing.NewPRs,
+		progressHub,
 		ctx,
 	))
 }
 
 // RegisterSSERoutes registers Server-Sent Events routes
-func RegisterSSERoutes(app *fiber.App, currentSessions *session.SessionsLock) {
-	app.Get("/sse", handlers.SSEHandler(currentSessions))
+func RegisterSSERoutes(app *fiber.App, progressHub *session.Hub) {
+	app.Get("/sse", handlers.SSEHandler(progressHub))
 }
 
 // RegisterLimitsRoutes registers API limits routes
 func RegisterLimitsRoutes(
 	app *fiber.App,
 	ctx context.Context,
-	ghStatClients map[string]*repostats.ClientGQL,
+	clientPool *tokens.Pool,
+) {
+	app.Get("/limits", handlers.LimitsHandler(clientPool, ctx))
+}
+
+// MetricFetchers returns the fetchers for the metrics that can be fetched in the background, keyed by metric name
+func MetricFetchers(caches *Caches, onGoing *OnGoingFetches, progressHub *session.Hub) map[string]handlers.MetricFetcher {
+	return map[string]handlers.MetricFetcher{
+		session.MetricStars:        handlers.StarsFetcher(progressHub, caches.Stars, &onGoing.Stars),
+		session.MetricIssues:       handlers.IssuesFetcher(progressHub, caches.Issues, &onGoing.Issues),
+		session.MetricForks:        handlers.ForksFetcher(progressHub, caches.Forks, &onGoing.Forks),
+		session.MetricPRs:          handlers.PRsFetcher(progressHub, caches.PRs, &onGoing.PRs),
+		session.MetricCommits:      handlers.CommitsFetcher(progressHub, caches.Commits, &onGoing.Commits),
+		session.MetricContributors: handlers.ContributorsFetcher(progressHub, caches.Contributors, &onGoing.Contributors),
+	}
+}
+
+// RegisterJobRoutes registers the asynchronous fetch job routes
+func RegisterJobRoutes(
+	app *fiber.App,
+	ctx context.Context,
+	clientPool *tokens.Pool,
+	fetchers map[string]handlers.MetricFetcher,
+	manager *jobs.Manager,
+) {
+	app.Post("/jobs", handlers.CreateJobHandler(ctx, clientPool, manager, fetchers))
+	app.Get("/jobs/:id", handlers.GetJobHandler(manager))
+	app.Delete("/jobs/:id", handlers.CancelJobHandler(manager))
+}
+
+// RegisterEstimateRoutes registers the fetch cost estimate route
+func RegisterEstimateRoutes(
+	app *fiber.App,
+	ctx context.Context,
+	clientPool *tokens.Pool,
+	fetchers map[string]handlers.MetricFetcher,
+) {
+	app.Get("/estimate", handlers.EstimateHandler(ctx, clientPool, fetchers))
+}
+
+// RegisterCompareRoutes registers the repo comparison route, filling missing caches with jobs of manager
+func RegisterCompareRoutes(
+	app *fiber.App,
+	ctx context.Context,
+	clientPool *tokens.Pool,
+	caches *Caches,
+	fetchers map[string]handlers.MetricFetcher,
+	manager *jobs.Manager,
+) {
+	app.Get("/compare", handlers.CompareHandler(ctx, clientPool, manager, fetchers, caches.CreatedAt))
+}
+
+// RegisterForecastRoutes registers the stars forecast route, filling missing caches with jobs of manager
+func RegisterForecastRoutes(
+	app *fiber.App,
+	ctx context.Context,
+	clientPool *tokens.Pool,
+	fetchers map[string]handlers.MetricFetcher,
+	manager *jobs.Manager,
 ) {
-	app.Get("/limits", handlers.LimitsHandler(ghStatClients, ctx))
+	app.Get("/forecast", handlers.ForecastHandler(ctx, clientPool, manager, fetchers))
+}
+
+// RegisterSpikesRoutes registers the stars spikes route, filling missing caches with jobs of manager
+func RegisterSpikesRoutes(
+	app *fiber.App,
+	ctx context.Context,
+	clientPool *tokens.Pool,
+	caches *Caches,
+	fetchers map[string]handlers.MetricFetcher,
+	manager *jobs.Manager,
+) {
+	app.Get("/spikes", handlers.SpikesHandler(ctx, clientPool, manager, fetchers,
+		caches.HackerNews, caches.Reddit, caches.YouTube, caches.Releases))
+}
+
+// RegisterMilestonesRoutes registers the stars milestones route, filling missing caches with jobs of manager
+func RegisterMilestonesRoutes(
+	app *fiber.App,
+	ctx context.Context,
+	clientPool *tokens.Pool,
+	fetchers map[string]handlers.MetricFetcher,
+	manager *jobs.Manager,
+) {
+	app.Get("/milestones", handlers.MilestonesHandler(ctx, clientPool, manager, fetchers))
+}
+
+// RegisterWarmupRoutes registers the cache warmer admin routes, warmer is nil when the warmer is disabled
+func RegisterWarmupRoutes(app *fiber.App, warmer *warmup.Warmer) {
+	app.Get("/admin/warmup", handlers.WarmupStatusHandler(warmer))
 }
@@ -0,0 +1,240 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBufferSize is how many events a subscriber can lag behind before the oldest are dropped
+	DefaultBufferSize = 64
+	// ReplaySize is how many recent events are kept per topic for clients resuming with Last-Event-ID
+	ReplaySize = 32
+	// ReplayTTL is how long the replay buffer of a topic nobody listens to is kept after its last event
+	ReplayTTL = 15 * time.Minute
+)
+
+// Hub broadcasts events to the subscribers of a topic. Publishing never blocks:
+// when a subscriber's queue is full its oldest event is dropped, so a slow or
+// disconnected client can't stall the fetch producing the events.
+//
+// Every event gets an ID that increases per topic, and the last events of each topic
+// are kept so a reconnecting client can catch up on what it missed.
+type Hub struct {
+	mu         sync.Mutex
+	bufferSize int
+	topics     map[string]*topicState
+	lastPrune  time.Time
+}
+
+type topicState struct {
+	lastID      uint64
+	replay      []Event
+	terminal    *Event
+	updatedAt   time.Time
+	subscribers map[*Subscriber]struct{}
+}
+
+// Subscriber receives the events published to its topics
+type Subscriber struct {
+	topics []string
+	events chan Event
+	// start holds, per topic, the ID of the last event before the first one queued for this subscriber
+	start map[string]uint64
+
+	mu      sync.Mutex
+	dropped int
+}
+
+// NewHub creates a Hub whose subscribers buffer up to bufferSize events
+func NewHub(bufferSize int) *Hub {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	return &Hub{
+		bufferSize: bufferSize,
+		topics:     make(map[string]*topicState),
+	}
+}
+
+func (h *Hub) topic(name string) *topicState {
+	t, ok := h.topics[name]
+	if !ok {
+		t = &topicState{subscribers: make(map[*Subscriber]struct{})}
+		h.topics[name] = t
+	}
+	return t
+}
+
+// Subscribe registers a new subscriber for the given topics
+func (h *Hub) Subscribe(topics ...string) *Subscriber {
+	return h.Resume(nil, topics...)
+}
+
+// Resume registers a new subscriber for the given topics and queues the events it missed
+// on each topic after the ID in lastIDs. The last completed or failed event of a topic is
+// always replayed when it is newer, even if it already left the replay buffer.
+// Topics missing from lastIDs start from the next event. An ID ahead of the topic,
+// left over from before a restart, replays everything still kept.
+func (h *Hub) Resume(lastIDs map[string]uint64, topics ...string) *Subscriber {
+	s := &Subscriber{
+		topics: topics,
+		events: make(chan Event, h.bufferSize),
+		start:  make(map[string]uint64, len(topics)),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, name := range topics {
+		t := h.topic(name)
+		t.subscribers[s] = struct{}{}
+
+		lastID, resuming := lastIDs[name]
+		if !resuming {
+			s.start[name] = t.lastID
+			continue
+		}
+		if lastID > t.lastID {
+			lastID = 0
+		}
+		s.start[name] = lastID
+
+		replayedTerminal := false
+		for _, ev := range t.replay {
+			if ev.ID > lastID {
+				s.offer(ev)
+				replayedTerminal = replayedTerminal || (t.terminal != nil && ev.ID == t.terminal.ID)
+			}
+		}
+		if t.terminal != nil && t.terminal.ID > lastID && !replayedTerminal {
+			s.offer(*t.terminal)
+		}
+	}
+
+	return s
+}
+
+// Unsubscribe removes the subscriber from all its topics and closes its event channel.
+// It is safe to call more than once.
+func (h *Hub) Unsubscribe(s *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subscribed := false
+	for _, name := range s.topics {
+		if t, ok := h.topics[name]; ok {
+			if _, ok := t.subscribers[s]; ok {
+				subscribed = true
+				delete(t.subscribers, s)
+			}
+		}
+	}
+
+	if subscribed {
+		close(s.events)
+	}
+}
+
+// Publish assigns ev the next ID of topic and sends it to every subscriber of topic without blocking
+func (h *Hub) Publish(topic string, ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	h.prune(now)
+
+	t := h.topic(topic)
+	t.lastID++
+	t.updatedAt = now
+	ev.ID = t.lastID
+
+	t.replay = append(t.replay, ev)
+	if len(t.replay) > ReplaySize {
+		t.replay = t.replay[len(t.replay)-ReplaySize:]
+	}
+	if ev.Type == EventCompleted || ev.Type == EventFailed {
+		terminal := ev
+		t.terminal = &terminal
+	}
+
+	for s := range t.subscribers {
+		s.offer(ev)
+	}
+}
+
+// Last returns the last event published on topic, false when there is none
+func (h *Hub) Last(topic string) (Event, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t, ok := h.topics[topic]
+	if !ok || len(t.replay) == 0 {
+		return Event{}, false
+	}
+	return t.replay[len(t.replay)-1], true
+}
+
+// Subscribers returns how many subscribers are listening to topic
+func (h *Hub) Subscribers(topic string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if t, ok := h.topics[topic]; ok {
+		return len(t.subscribers)
+	}
+	return 0
+}
+
+// prune drops the topics nobody listens to whose last event is older than ReplayTTL.
+// It runs at most once a minute. h.mu must be held.
+func (h *Hub) prune(now time.Time) {
+	if now.Sub(h.lastPrune) < time.Minute {
+		return
+	}
+	h.lastPrune = now
+
+	for name, t := range h.topics {
+		if len(t.subscribers) == 0 && now.Sub(t.updatedAt) > ReplayTTL {
+			delete(h.topics, name)
+		}
+	}
+}
+
+// Events returns the channel delivering the subscriber's events. It is closed on Unsubscribe.
+func (s *Subscriber) Events() <-chan Event {
+	return s.events
+}
+
+// StartID returns the ID the subscriber's events on topic follow, so a client that
+// receives nothing on a topic can still resume it from the right place
+func (s *Subscriber) StartID(topic string) uint64 {
+	return s.start[topic]
+}
+
+// Dropped returns how many events were discarded because the subscriber fell behind
+func (s *Subscriber) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// offer queues ev, dropping the oldest queued event if the queue is full
+func (s *Subscriber) offer(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		select {
+		case s.events <- ev:
+			return
+		default:
+		}
+
+		select {
+		case <-s.events:
+			s.dropped++
+		default:
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package session
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHubPublishToTopic(t *testing.T) {
+	hub := NewHub(4)
+	helm := hub.Subscribe("helm/helm")
+	other := hub.Subscribe("other/repo")
+
+	hub.Publish("helm/helm", Event{Type: EventProgress, PagesDone: 1})
+
+	ev := <-helm.Events()
+	assert.Equal(t, 1, ev.PagesDone)
+	assert.Empty(t, other.Events())
+	assert.Equal(t, 1, hub.Subscribers("helm/helm"))
+}
+
+func TestHubDropsOldestWhenFull(t *testing.T) {
+	hub := NewHub(2)
+	sub := hub.Subscribe("helm/helm")
+
+	// Nobody is reading, publishing must not block
+	for i := 1; i <= 5; i++ {
+		hub.Publish("helm/helm", Event{Type: EventProgress, PagesDone: i})
+	}
+
+	assert.Equal(t, 4, (<-sub.Events()).PagesDone)
+	assert.Equal(t, 5, (<-sub.Events()).PagesDone)
+	assert.Equal(t, 3, sub.Dropped())
+}
+
+func TestHubUnsubscribe(t *testing.T) {
+	hub := NewHub(2)
+	sub := hub.Subscribe("helm/helm", "helm/helm-mapkubeapis")
+
+	hub.Unsubscribe(sub)
+	hub.Unsubscribe(sub)
+
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+	assert.Zero(t, hub.Subscribers("helm/helm"))
+
+	assert.NotPanics(t, func() {
+		hub.Publish("helm/helm", Event{Type: EventCompleted})
+	})
+}
+
+func TestHubConcurrentPublish(t *testing.T) {
+	hub := NewHub(8)
+	sub := hub.Subscribe("helm/helm")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				hub.Publish("helm/helm", Event{Type: EventProgress, PagesDone: j})
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for range sub.Events() {
+		}
+		close(done)
+	}()
+
+	wg.Wait()
+	hub.Unsubscribe(sub)
+	<-done
+}
+
+func TestHubEventIDsPerTopic(t *testing.T) {
+	hub := NewHub(8)
+	sub := hub.Subscribe("stars:helm/helm", "issues:helm/helm")
+
+	hub.Publish("stars:helm/helm", Event{Type: EventStarted})
+	hub.Publish("issues:helm/helm", Event{Type: EventStarted})
+	hub.Publish("stars:helm/helm", Event{Type: EventProgress})
+
+	assert.Equal(t, uint64(1), (<-sub.Events()).ID)
+	assert.Equal(t, uint64(1), (<-sub.Events()).ID)
+	assert.Equal(t, uint64(2), (<-sub.Events()).ID)
+}
+
+func TestHubResumeReplaysMissedEvents(t *testing.T) {
+	hub := NewHub(8)
+	for i := 1; i <= 4; i++ {
+		hub.Publish("stars:helm/helm", Event{Type: EventProgress, PagesDone: i})
+	}
+
+	sub := hub.Resume(map[string]uint64{"stars:helm/helm": 2}, "stars:helm/helm")
+	assert.Equal(t, uint64(2), sub.StartID("stars:helm/helm"))
+	assert.Equal(t, 3, (<-sub.Events()).PagesDone)
+	assert.Equal(t, 4, (<-sub.Events()).PagesDone)
+	assert.Empty(t, sub.Events())
+
+	fresh := hub.Subscribe("stars:helm/helm")
+	assert.Equal(t, uint64(4), fresh.StartID("stars:helm/helm"))
+	assert.Empty(t, fresh.Events())
+}
+
+func TestHubResumeDeliversTerminalEvent(t *testing.T) {
+	hub := NewHub(8)
+	hub.Publish("stars:helm/helm", Event{Type: EventStarted})
+	hub.Publish("stars:helm/helm", Event{Type: EventCompleted})
+	// Enough progress on the topic to push the completed event out of the replay buffer
+	for i := 0; i < ReplaySize; i++ {
+		hub.Publish("stars:helm/helm", Event{Type: EventProgress})
+	}
+
+	sub := hub.Resume(map[string]uint64{"stars:helm/helm": 1}, "stars:helm/helm")
+
+	first := <-sub.Events()
+	assert.Equal(t, EventProgress, first.Type)
+	var last Event
+	for len(sub.Events()) > 0 {
+		last = <-sub.Events()
+	}
+	assert.Equal(t, EventCompleted, last.Type)
+	assert.Equal(t, uint64(2), last.ID)
+}
+
+func TestHubResumeAfterRestart(t *testing.T) {
+	hub := NewHub(8)
+	hub.Publish("stars:helm/helm", Event{Type: EventCompleted})
+
+	// An ID ahead of the topic comes from before a restart
+	sub := hub.Resume(map[string]uint64{"stars:helm/helm": 40}, "stars:helm/helm")
+
+	ev := <-sub.Events()
+	assert.Equal(t, EventCompleted, ev.Type)
+	assert.Empty(t, sub.Events())
+}
+
+func TestHubLast(t *testing.T) {
+	hub := NewHub(4)
+	_, ok := hub.Last("helm/helm")
+	assert.False(t, ok)
+
+	hub.Publish("helm/helm", Event{Type: EventProgress, PagesDone: 1})
+	hub.Publish("helm/helm", Event{Type: EventProgress, PagesDone: 2})
+
+	ev, ok := hub.Last("helm/helm")
+	assert.True(t, ok)
+	assert.Equal(t, 2, ev.PagesDone)
+	assert.Equal(t, uint64(2), ev.ID)
+}
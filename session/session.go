@@ -4,35 +4,47 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"slices"
 	"strings"
-	"sync"
 )
 
-type Session struct {
-	Repo         string
-	StateChannel chan int
-}
-
-type SessionsLock struct {
-	MU       sync.Mutex
-	Sessions []*Session
-}
+// Progress event types sent to SSE clients while a fetch runs
+const (
+	EventStarted   = "started"
+	EventProgress  = "progress"
+	EventCompleted = "completed"
+	EventFailed    = "failed"
+	// EventRetrying is sent before a failed GitHub call is tried again, the fetch restarts
+	EventRetrying = "retrying"
+	// EventFailover is sent when a fetch moves to another client because its token ran dry
+	EventFailover = "failover"
+)
 
-func (sl *SessionsLock) AddSession(s *Session) {
-	sl.MU.Lock()
-	sl.Sessions = append(sl.Sessions, s)
-	sl.MU.Unlock()
-}
-
-func (sl *SessionsLock) RemoveSession(s *Session) {
-	sl.MU.Lock()
-	idx := slices.Index(sl.Sessions, s)
-	if idx != -1 {
-		sl.Sessions[idx] = nil
-		sl.Sessions = slices.Delete(sl.Sessions, idx, idx+1)
-	}
-	sl.MU.Unlock()
+// Event describes the state of a long-running fetch
+type Event struct {
+	// ID is assigned by the Hub and increases with every event published on Topic
+	ID     uint64 `json:"id"`
+	Topic  string `json:"topic"`
+	Type   string `json:"type"`
+	Metric string `json:"metric"`
+	// Repo is the repository being fetched, empty for metrics not tied to a repository
+	Repo   string `json:"repo,omitempty"`
+	Client string `json:"client,omitempty"`
+	// Total is the expected number of items (e.g. stars), zero when unknown
+	Total int `json:"total,omitempty"`
+	// TotalPages is the expected number of pages to fetch, zero when unknown
+	TotalPages int `json:"totalPages,omitempty"`
+	PagesDone  int `json:"pagesDone"`
+	// ETASeconds estimates the time left, only set when TotalPages is known
+	ETASeconds int    `json:"etaSeconds,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// ErrorKind classifies Error, e.g. rate_limited or not_found
+	ErrorKind string `json:"errorKind,omitempty"`
+	// Attempt is the number of the retry about to be made, on retrying events
+	Attempt int `json:"attempt,omitempty"`
+	// RetryInSeconds is the wait before the retry, on retrying events
+	RetryInSeconds float64 `json:"retryInSeconds,omitempty"`
+	// PreviousClient is the client given up on, on failover events
+	PreviousClient string `json:"previousClient,omitempty"`
 }
 
 func Filter[T any](filter func(n T) bool) func(T []T) []T {
@@ -48,6 +60,12 @@ func Filter[T any](filter func(n T) bool) func(T []T) []T {
 }
 
 func FormatSSEMessage(eventType string, data any) (string, error) {
+	return FormatSSEEvent("", eventType, data)
+}
+
+// FormatSSEEvent formats an SSE message carrying id, which the browser sends back as Last-Event-ID when it reconnects.
+// An empty id leaves the last one seen by the client unchanged.
+func FormatSSEEvent(id, eventType string, data any) (string, error) {
 	var buf bytes.Buffer
 	enc := json.NewEncoder(&buf)
 
@@ -61,6 +79,11 @@ func FormatSSEMessage(eventType string, data any) (string, error) {
 	}
 	sb := strings.Builder{}
 
+	if id != "" {
+		if _, err := fmt.Fprintf(&sb, "id: %s\n", id); err != nil {
+			return "", err
+		}
+	}
 	if _, err := fmt.Fprintf(&sb, "event: %s\n", eventType); err != nil {
 		return "", err
 	}
@@ -0,0 +1,54 @@
+package session
+
+import (
+	"strings"
+
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
+)
+
+// Metrics whose fetch progress is published on the hub
+const (
+	MetricStars        = "stars"
+	MetricIssues       = "issues"
+	MetricForks        = "forks"
+	MetricPRs          = "prs"
+	MetricCommits      = "commits"
+	MetricContributors = "contributors"
+	MetricNewRepos     = "newrepos"
+	MetricNewPRs       = "newprs"
+)
+
+// RepoMetrics are the metrics fetched for a single repository
+var RepoMetrics = []string{MetricStars, MetricIssues, MetricForks, MetricPRs, MetricCommits, MetricContributors}
+
+var knownMetrics = map[string]bool{
+	MetricStars:        true,
+	MetricIssues:       true,
+	MetricForks:        true,
+	MetricPRs:          true,
+	MetricCommits:      true,
+	MetricContributors: true,
+	MetricNewRepos:     true,
+	MetricNewPRs:       true,
+}
+
+// Topic returns the hub topic for a metric and its key, e.g. stars:helm/helm, stars:ghe.corp/team/repo
+// or newrepos:2024-01-01_2024-02-01
+func Topic(metric, key string) string {
+	return metric + ":" + key
+}
+
+// ParseTopic normalizes a topic received from a client and reports whether it names a known metric.
+// Repos are normalized like the handlers do, so that github.com/helm/helm is helm/helm while
+// ghe.corp/team/repo stays apart from team/repo.
+func ParseTopic(topic string) (string, bool) {
+	metric, key, found := strings.Cut(strings.ToLower(strings.TrimSpace(topic)), ":")
+	if !found || !knownMetrics[metric] {
+		return "", false
+	}
+	key = repoid.Normalize(key)
+	if key == "" {
+		return "", false
+	}
+	return Topic(metric, key), true
+}
@@ -0,0 +1,30 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTopic(t *testing.T) {
+	topic, ok := ParseTopic(" Stars:Helm/Helm ")
+	assert.True(t, ok)
+	assert.Equal(t, "stars:helm/helm", topic)
+
+	topic, ok = ParseTopic("newrepos:2024-01-01_2024-02-01")
+	assert.True(t, ok)
+	assert.Equal(t, Topic(MetricNewRepos, "2024-01-01_2024-02-01"), topic)
+
+	// Repos on other hosts stay apart from github.com ones
+	topic, ok = ParseTopic("stars:https://github.com/Helm/Helm")
+	assert.True(t, ok)
+	assert.Equal(t, "stars:helm/helm", topic)
+	topic, ok = ParseTopic("stars:GHE.corp/helm/helm")
+	assert.True(t, ok)
+	assert.Equal(t, "stars:ghe.corp/helm/helm", topic)
+
+	for _, invalid := range []string{"helm/helm", "watchers:helm/helm", "stars:", ""} {
+		_, ok := ParseTopic(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
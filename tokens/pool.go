@@ -0,0 +1,248 @@
+package tokens
+
+import (
+	"context"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+)
+
+// AnonymousLabel labels the client without a token used when the pool would otherwise be empty,
+// as GitHub still serves the REST API without a token
+const AnonymousLabel = "PAT"
+
+// Pool holds a GitHub client per configured token and can reload the tokens without a restart.
+// Clients whose token didn't change are kept across reloads, so in-flight fetches are not affected.
+type Pool struct {
+	load      func() ([]Token, error)
+	newClient func(t Token) *repostats.ClientGQL
+
+	mu      sync.RWMutex
+	clients map[string]*repostats.ClientGQL
+	tokens  map[string]Token // label -> token the client was created with
+	// fixed clients are not backed by a token, e.g. GitHub App installations, and survive reloads
+	fixed map[string]fixedClient
+	// baseURLs are the API roots of the clients, by label
+	baseURLs map[string]string
+	// onForget is called with the labels whose client is gone, see OnForget
+	onForget func(labels []string)
+}
+
+type fixedClient struct {
+	client  *repostats.ClientGQL
+	baseURL string
+}
+
+// NewPool loads the tokens with load and creates their clients with newClient
+func NewPool(load func() ([]Token, error), newClient func(t Token) *repostats.ClientGQL) (*Pool, error) {
+	p := &Pool{
+		load:      load,
+		newClient: newClient,
+		clients:   make(map[string]*repostats.ClientGQL),
+		tokens:    make(map[string]Token),
+		fixed:     make(map[string]fixedClient),
+		baseURLs:  make(map[string]string),
+	}
+
+	if _, err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload loads the tokens again and reports whether the pool changed.
+// On error the current clients are kept.
+func (p *Pool) Reload() (bool, error) {
+	loaded, err := p.load()
+	if err != nil {
+		return false, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.apply(loaded), nil
+}
+
+// AddClient adds a client that isn't created from a token, such as a GitHub App installation client,
+// calling the API at baseURL. It is kept across reloads and takes precedence over a token with the same label.
+func (p *Pool) AddClient(label, baseURL string, client *repostats.ClientGQL) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.fixed[label] = fixedClient{client: client, baseURL: baseURL}
+
+	loaded := make([]Token, 0, len(p.tokens))
+	for _, t := range p.tokens {
+		// The anonymous client is no longer needed
+		if t.Value != "" {
+			loaded = append(loaded, t)
+		}
+	}
+	p.apply(loaded)
+}
+
+// OnForget sets fn to be called on each reload with the labels whose client is gone: removed, or replaced
+// by the client of another token. State kept by label for the old clients, like their rate limits, can
+// then be dropped. fn is called with the pool locked and must not use it.
+func (p *Pool) OnForget(fn func(labels []string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onForget = fn
+}
+
+// apply replaces the clients with the fixed ones and those of loaded, reporting whether anything changed.
+// p.mu must be held.
+func (p *Pool) apply(loaded []Token) bool {
+	if len(loaded) == 0 && len(p.fixed) == 0 {
+		loaded = []Token{{Label: AnonymousLabel}}
+	}
+
+	clients := make(map[string]*repostats.ClientGQL, len(loaded)+len(p.fixed))
+	tokens := make(map[string]Token, len(loaded))
+	baseURLs := make(map[string]string, len(loaded)+len(p.fixed))
+	var added, removed, forgotten []string
+
+	for label, fixed := range p.fixed {
+		clients[label] = fixed.client
+		baseURLs[label] = fixed.baseURL
+		if p.clients[label] != fixed.client {
+			added = append(added, label)
+		}
+	}
+	for _, t := range loaded {
+		if _, ok := p.fixed[t.Label]; ok {
+			log.Printf("GitHub token %s ignored, the label is already used", t.Label)
+			continue
+		}
+		if client, ok := p.clients[t.Label]; ok && p.tokens[t.Label] == t {
+			clients[t.Label] = client
+		} else {
+			if ok {
+				forgotten = append(forgotten, t.Label)
+			}
+			clients[t.Label] = p.newClient(t)
+			added = append(added, t.Label)
+		}
+		tokens[t.Label] = t
+		baseURLs[t.Label] = t.BaseURL
+	}
+	for label := range p.clients {
+		if _, ok := clients[label]; !ok {
+			removed = append(removed, label)
+		}
+	}
+
+	p.clients = clients
+	p.tokens = tokens
+	p.baseURLs = baseURLs
+
+	if len(added) == 0 && len(removed) == 0 {
+		return false
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	if forgotten = append(forgotten, removed...); len(forgotten) > 0 && p.onForget != nil {
+		p.onForget(forgotten)
+	}
+	log.Printf("GitHub token pool: %d clients (added: %s, removed: %s)",
+		len(clients), strings.Join(added, ", "), strings.Join(removed, ", "))
+	return true
+}
+
+// Clients returns a snapshot of the clients keyed by label, safe to use while the pool reloads
+func (p *Pool) Clients() map[string]*repostats.ClientGQL {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	clients := make(map[string]*repostats.ClientGQL, len(p.clients))
+	for label, client := range p.clients {
+		clients[label] = client
+	}
+	return clients
+}
+
+// ClientsFor returns a snapshot of the clients that can fetch the repos of host, keyed by label
+func (p *Pool) ClientsFor(host string) map[string]*repostats.ClientGQL {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	clients := make(map[string]*repostats.ClientGQL)
+	for label, client := range p.clients {
+		if h, _ := repoid.BaseURLHost(p.baseURLs[label]); h == host {
+			clients[label] = client
+		}
+	}
+	return clients
+}
+
+// BaseURL returns the API root of the clients of host, reporting false when no client is for host
+func (p *Pool) BaseURL(host string) (string, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, baseURL := range p.baseURLs {
+		if h, _ := repoid.BaseURLHost(baseURL); h == host {
+			return baseURL, true
+		}
+	}
+	return "", false
+}
+
+// Host returns the host of the repos the client labelled label can fetch
+func (p *Pool) Host(label string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	host, _ := repoid.BaseURLHost(p.baseURLs[label])
+	return host
+}
+
+// Get returns the client labelled label
+func (p *Pool) Get(label string) (*repostats.ClientGQL, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	client, ok := p.clients[label]
+	return client, ok
+}
+
+// Labels returns the labels of the clients in the pool, sorted
+func (p *Pool) Labels() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	labels := make([]string, 0, len(p.clients))
+	for label := range p.clients {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// Watch reloads the pool every interval and whenever trigger fires (e.g. on SIGHUP), until ctx is done.
+// With FromEnv only the GITHUB_TOKENS_FILE file can change in between: the environment of a running process can't.
+func (p *Pool) Watch(ctx context.Context, interval time.Duration, trigger <-chan os.Signal) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-trigger:
+			log.Println("Reloading GitHub tokens")
+		}
+
+		if _, err := p.Reload(); err != nil {
+			log.Printf("Error reloading GitHub tokens, keeping the current ones: %v", err)
+		}
+	}
+}
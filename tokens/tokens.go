@@ -0,0 +1,194 @@
+package tokens
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
+)
+
+// Environment variables the tokens are read from, besides PAT, PAT2 and PAT_1..PAT_n
+const (
+	// EnvList holds comma separated tokens, each optionally prefixed with its label as label=token
+	EnvList = "GITHUB_TOKENS"
+	// EnvFile names a file with one token per line, in the same format as EnvList
+	EnvFile = "GITHUB_TOKENS_FILE"
+	// EnvEnterpriseURL is the REST API root of a GitHub Enterprise Server instance, e.g. https://ghe.corp/api/v3
+	EnvEnterpriseURL = "GITHUB_ENTERPRISE_URL"
+	// EnvEnterpriseList holds the tokens of the EnvEnterpriseURL instance, in the same format as EnvList
+	EnvEnterpriseList = "GITHUB_ENTERPRISE_TOKENS"
+)
+
+// Token is a GitHub token and the label identifying its client, e.g. in ?client= and in the logs.
+// The token itself must never be logged.
+type Token struct {
+	Label string
+	Value string
+	// BaseURL is the REST API root the token is for, github.com when empty
+	BaseURL string
+}
+
+var numberedPAT = regexp.MustCompile(`^PAT_(\d+)$`)
+
+// FromEnv collects the configured tokens, in order: PAT, PAT2, PAT_1..PAT_n, the GITHUB_TOKENS list,
+// the GITHUB_TOKENS_FILE file and the GITHUB_ENTERPRISE_TOKENS list for GITHUB_ENTERPRISE_URL.
+// A token configured twice is only kept once, under its first label.
+// The environment is fixed once the process started, so calling it again only picks up changes to the file.
+func FromEnv() ([]Token, error) {
+	var all []Token
+
+	for _, label := range []string{"PAT", "PAT2"} {
+		if value := strings.TrimSpace(os.Getenv(label)); value != "" {
+			all = append(all, Token{Label: label, Value: value})
+		}
+	}
+
+	type numbered struct {
+		n     int
+		token Token
+	}
+	var pats []numbered
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		m := numberedPAT.FindStringSubmatch(name)
+		value = strings.TrimSpace(value)
+		if m == nil || value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+		pats = append(pats, numbered{n: n, token: Token{Label: name, Value: value}})
+	}
+	sort.Slice(pats, func(i, j int) bool { return pats[i].n < pats[j].n })
+	for _, pat := range pats {
+		all = append(all, pat.token)
+	}
+
+	if list := os.Getenv(EnvList); list != "" {
+		listed, err := Parse(strings.Split(list, ","), "TOKEN")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", EnvList, err)
+		}
+		all = append(all, listed...)
+	}
+
+	if path := os.Getenv(EnvFile); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		fromFile, err := ParseFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		all = append(all, fromFile...)
+	}
+
+	if list := os.Getenv(EnvEnterpriseList); list != "" {
+		baseURL := strings.TrimSpace(os.Getenv(EnvEnterpriseURL))
+		host, err := repoid.BaseURLHost(baseURL)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", EnvEnterpriseURL, err)
+		}
+		if host == repoid.PublicHost {
+			return nil, fmt.Errorf("%s must be set to the API of the instance with %s", EnvEnterpriseURL, EnvEnterpriseList)
+		}
+
+		listed, err := Parse(strings.Split(list, ","), "GHE")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", EnvEnterpriseList, err)
+		}
+		for _, t := range listed {
+			t.BaseURL = baseURL
+			all = append(all, t)
+		}
+	}
+
+	return dedup(all)
+}
+
+// ParseFile reads one token per line, skipping blank lines and # comments. Unlabelled tokens are labelled
+// FILE_ and the start of the hash of the token, see Parse.
+func ParseFile(r io.Reader) ([]Token, error) {
+	var entries []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return Parse(entries, "FILE")
+}
+
+// Parse turns entries of the form label=token or token into Tokens.
+// Unlabelled tokens are labelled with prefix and the start of the SHA-256 of the token, e.g. TOKEN_1f2e3d4c:
+// a token keeps its label, and the rate limit and health tracked under it, when the tokens around it change.
+func Parse(entries []string, prefix string) ([]Token, error) {
+	tokens := make([]Token, 0, len(entries))
+	for i, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		label, value, labelled := strings.Cut(entry, "=")
+		if !labelled {
+			label, value = hashLabel(prefix, strings.TrimSpace(entry)), entry
+		}
+		label, value = strings.TrimSpace(label), strings.TrimSpace(value)
+		if label == "" || value == "" {
+			return nil, fmt.Errorf("entry %d: empty label or token", i+1)
+		}
+
+		tokens = append(tokens, Token{Label: label, Value: value})
+	}
+	return tokens, nil
+}
+
+// hashLabel labels value with prefix and the first 8 hex digits of its SHA-256, which don't reveal the token
+func hashLabel(prefix, value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return prefix + "_" + hex.EncodeToString(sum[:4])
+}
+
+// dedup drops repeated tokens and rejects a label used for two different tokens
+func dedup(all []Token) ([]Token, error) {
+	byLabel := make(map[string]Token, len(all))
+	seen := make(map[Token]bool, len(all))
+	result := make([]Token, 0, len(all))
+
+	for _, t := range all {
+		unlabelled := Token{Value: t.Value, BaseURL: t.BaseURL}
+		if previous, ok := byLabel[t.Label]; ok {
+			if previous != t {
+				return nil, fmt.Errorf("label %s is used for more than one token", t.Label)
+			}
+			continue
+		}
+		if seen[unlabelled] {
+			continue
+		}
+		byLabel[t.Label] = t
+		seen[unlabelled] = true
+		result = append(result, t)
+	}
+	return result, nil
+}
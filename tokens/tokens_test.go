@@ -0,0 +1,183 @@
+package tokens
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFile(t *testing.T) {
+	tokens, err := ParseFile(strings.NewReader(`
+# backup tokens
+ghp_first
+ci=ghp_second
+
+ghp_third
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []Token{
+		{Label: "FILE_cd19452a", Value: "ghp_first"},
+		{Label: "ci", Value: "ghp_second"},
+		{Label: "FILE_ff9755cd", Value: "ghp_third"},
+	}, tokens)
+
+	// Removing a token doesn't relabel the others
+	tokens, err = ParseFile(strings.NewReader("ghp_third\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []Token{{Label: "FILE_ff9755cd", Value: "ghp_third"}}, tokens)
+
+	_, err = Parse([]string{"label="}, "TOKEN")
+	assert.Error(t, err)
+}
+
+func TestFromEnv(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tokens.txt")
+	require.NoError(t, os.WriteFile(file, []byte("ghp_file\nghp_one\n"), 0o600))
+
+	t.Setenv("PAT", "ghp_main")
+	t.Setenv("PAT2", "")
+	t.Setenv("PAT_10", "ghp_ten")
+	t.Setenv("PAT_2", "ghp_two")
+	t.Setenv(EnvList, "ghp_one, backup=ghp_backup")
+	t.Setenv(EnvFile, file)
+
+	tokens, err := FromEnv()
+	require.NoError(t, err)
+
+	labels := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		labels = append(labels, token.Label)
+	}
+	// ghp_one from the file is already in the list
+	assert.Equal(t, []string{"PAT", "PAT_2", "PAT_10", "TOKEN_3f3b3f04", "backup", "FILE_010a4dbc"}, labels)
+}
+
+func TestFromEnvRejectsReusedLabel(t *testing.T) {
+	t.Setenv("PAT", "ghp_main")
+	t.Setenv(EnvList, "PAT=ghp_other")
+	t.Setenv(EnvFile, "")
+
+	_, err := FromEnv()
+	assert.Error(t, err)
+}
+
+func TestPoolReload(t *testing.T) {
+	loaded := []Token{{Label: "PAT", Value: "a"}, {Label: "PAT2", Value: "b"}}
+	created := 0
+
+	pool, err := NewPool(func() ([]Token, error) { return loaded, nil }, func(Token) *repostats.ClientGQL {
+		created++
+		return &repostats.ClientGQL{}
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"PAT", "PAT2"}, pool.Labels())
+	pat, _ := pool.Get("PAT")
+
+	// PAT is unchanged, PAT2 gets a new token and PAT_3 is added
+	loaded = []Token{{Label: "PAT", Value: "a"}, {Label: "PAT2", Value: "c"}, {Label: "PAT_3", Value: "d"}}
+	changed, err := pool.Reload()
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, 4, created)
+
+	samePAT, _ := pool.Get("PAT")
+	assert.Same(t, pat, samePAT)
+	assert.Len(t, pool.Clients(), 3)
+
+	changed, err = pool.Reload()
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestPoolForgetsGoneClients(t *testing.T) {
+	loaded := []Token{{Label: "PAT", Value: "a"}, {Label: "PAT2", Value: "b"}, {Label: "PAT_3", Value: "c"}}
+	pool, err := NewPool(func() ([]Token, error) { return loaded, nil }, func(Token) *repostats.ClientGQL {
+		return &repostats.ClientGQL{}
+	})
+	require.NoError(t, err)
+
+	var forgotten []string
+	pool.OnForget(func(labels []string) { forgotten = labels })
+
+	// PAT2 gets a new token and PAT_3 is removed
+	loaded = []Token{{Label: "PAT", Value: "a"}, {Label: "PAT2", Value: "d"}}
+	_, err = pool.Reload()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"PAT2", "PAT_3"}, forgotten)
+
+	forgotten = nil
+	_, err = pool.Reload()
+	require.NoError(t, err)
+	assert.Nil(t, forgotten)
+}
+
+func TestPoolFallsBackToAnonymousClient(t *testing.T) {
+	pool, err := NewPool(func() ([]Token, error) { return nil, nil }, func(Token) *repostats.ClientGQL {
+		return &repostats.ClientGQL{}
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{AnonymousLabel}, pool.Labels())
+
+	// An App client replaces the anonymous one and survives reloads
+	app := &repostats.ClientGQL{}
+	pool.AddClient("APP_42", "", app)
+	assert.Equal(t, []string{"APP_42"}, pool.Labels())
+
+	_, err = pool.Reload()
+	require.NoError(t, err)
+	client, ok := pool.Get("APP_42")
+	assert.True(t, ok)
+	assert.Same(t, app, client)
+	assert.Len(t, pool.Clients(), 1)
+}
+
+func TestFromEnvEnterprise(t *testing.T) {
+	t.Setenv("PAT", "ghp_main")
+	t.Setenv(EnvList, "")
+	t.Setenv(EnvFile, "")
+	t.Setenv(EnvEnterpriseURL, "https://ghe.corp/api/v3")
+	t.Setenv(EnvEnterpriseList, "ghp_main, team=ghp_team")
+
+	tokens, err := FromEnv()
+	require.NoError(t, err)
+	// The same token on another host is another token
+	assert.Equal(t, []Token{
+		{Label: "PAT", Value: "ghp_main"},
+		{Label: "GHE_de3097b1", Value: "ghp_main", BaseURL: "https://ghe.corp/api/v3"},
+		{Label: "team", Value: "ghp_team", BaseURL: "https://ghe.corp/api/v3"},
+	}, tokens)
+
+	t.Setenv(EnvEnterpriseURL, "")
+	_, err = FromEnv()
+	assert.Error(t, err)
+}
+
+func TestPoolClientsForHost(t *testing.T) {
+	loaded := []Token{{Label: "PAT", Value: "a"}, {Label: "GHE_1", Value: "b", BaseURL: "https://ghe.corp/api/v3"}}
+	pool, err := NewPool(func() ([]Token, error) { return loaded, nil }, func(Token) *repostats.ClientGQL {
+		return &repostats.ClientGQL{}
+	})
+	require.NoError(t, err)
+	pool.AddClient("APP_42", "https://GHE.corp/api/v3", &repostats.ClientGQL{})
+
+	assert.Len(t, pool.Clients(), 3)
+	assert.Contains(t, pool.ClientsFor(repoid.PublicHost), "PAT")
+	assert.Len(t, pool.ClientsFor(repoid.PublicHost), 1)
+	assert.Len(t, pool.ClientsFor("ghe.corp"), 2)
+	assert.Empty(t, pool.ClientsFor("other.corp"))
+
+	assert.Equal(t, "ghe.corp", pool.Host("GHE_1"))
+	assert.Equal(t, repoid.PublicHost, pool.Host("PAT"))
+
+	baseURL, ok := pool.BaseURL("ghe.corp")
+	assert.True(t, ok)
+	assert.Contains(t, []string{"https://ghe.corp/api/v3", "https://GHE.corp/api/v3"}, baseURL)
+	_, ok = pool.BaseURL("other.corp")
+	assert.False(t, ok)
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
+)
+
+// publicAPIHost is where repostats sends its requests, it only knows the github.com API
+const publicAPIHost = "api.github.com"
+
+// endpointTransport sends the requests made to the github.com API to the API of a GitHub Enterprise
+// Server instance: /graphql to <root>/api/graphql and the REST paths under <root>/api/v3
+type endpointTransport struct {
+	base    http.RoundTripper
+	graphQL *url.URL
+	rest    *url.URL
+}
+
+func (t *endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != publicAPIHost {
+		return t.base.RoundTrip(req)
+	}
+
+	target := t.graphQL
+	if req.URL.Path != "/graphql" {
+		target = t.rest.JoinPath(req.URL.Path)
+	}
+	u := *target
+	u.RawQuery = req.URL.RawQuery
+
+	// A RoundTripper must not modify the request it was given
+	req = req.Clone(req.Context())
+	req.URL = &u
+	req.Host = u.Host
+	return t.base.RoundTrip(req)
+}
+
+// failingTransport fails every request, so that a token is never sent to the wrong host
+type failingTransport struct {
+	err error
+}
+
+func (t failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, t.err
+}
+
+// NewEndpointTransport wraps base, http.DefaultTransport when nil, so that requests go to the GitHub API at
+// baseURL, the REST API root of a GitHub Enterprise Server instance such as https://ghe.corp/api/v3.
+// An empty baseURL, or the github.com one, returns base unchanged.
+func NewEndpointTransport(base http.RoundTripper, baseURL string) (http.RoundTripper, error) {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	host, err := repoid.BaseURLHost(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if host == repoid.PublicHost {
+		return base, nil
+	}
+
+	root, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub base URL %q: %w", baseURL, err)
+	}
+	// Relative to the web root, joined paths would be relative too
+	root.Path = "/" + strings.Trim(strings.TrimSuffix(strings.TrimSuffix(root.Path, "/"), "/api/v3"), "/")
+	root.RawPath, root.RawQuery, root.Fragment = "", "", ""
+
+	return &endpointTransport{
+		base:    base,
+		graphQL: root.JoinPath("api", "graphql"),
+		rest:    root.JoinPath("api", "v3"),
+	}, nil
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointTransport(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.RequestURI())
+	}))
+	defer server.Close()
+
+	// The REST API root or the web root of the instance
+	for _, baseURL := range []string{server.URL + "/api/v3/", server.URL} {
+		paths = nil
+		transport, err := NewEndpointTransport(nil, baseURL)
+		require.NoError(t, err)
+		client := &http.Client{Transport: transport}
+
+		for _, target := range []string{
+			"https://api.github.com/graphql",
+			"https://api.github.com/repos/team/repo/releases?per_page=100",
+		} {
+			resp, err := client.Get(target)
+			require.NoError(t, err)
+			resp.Body.Close()
+		}
+		assert.Equal(t, []string{"/api/graphql", "/api/v3/repos/team/repo/releases?per_page=100"}, paths, baseURL)
+	}
+
+	// github.com needs no rewriting
+	transport, err := NewEndpointTransport(http.DefaultTransport, "https://api.github.com")
+	require.NoError(t, err)
+	assert.Same(t, http.DefaultTransport, transport)
+
+	_, err = NewEndpointTransport(nil, "ghe.corp")
+	assert.Error(t, err)
+}
@@ -0,0 +1,218 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
+	"github.com/emanuelef/github-repo-activity-stats/repostats"
+	"golang.org/x/oauth2"
+)
+
+const (
+	defaultGitHubAPIURL = "https://api.github.com"
+
+	// appJWTLifetime is how long the JWT signed for the App is valid, GitHub accepts at most 10 minutes
+	appJWTLifetime = 9 * time.Minute
+	// appTokenEarlyExpiry is how long before its expiry an installation token is replaced
+	appTokenEarlyExpiry = 5 * time.Minute
+)
+
+// GitHubApp holds what is needed to authenticate as one installation of a GitHub App
+type GitHubApp struct {
+	AppID          string
+	InstallationID int64
+	PrivateKey     *rsa.PrivateKey
+	// BaseURL is the REST API root, https://api.github.com when empty, e.g. https://ghe.corp/api/v3 for
+	// an App of a GitHub Enterprise Server instance
+	BaseURL string
+	// HTTPClient exchanges the JWT for installation tokens, a client with a 30s timeout when nil
+	HTTPClient *http.Client
+}
+
+// Label names the clients of the installation, e.g. in ?client= and /limits
+func (app GitHubApp) Label() string {
+	return fmt.Sprintf("APP_%d", app.InstallationID)
+}
+
+// GitHubAppsFromEnv reads the GitHub App configuration: GITHUB_APP_ID, GITHUB_APP_PRIVATE_KEY_FILE and
+// GITHUB_APP_INSTALLATION_IDS, a comma separated list with one client per installation.
+// GITHUB_APP_BASE_URL optionally points to another API root. It returns nil when no App is configured.
+func GitHubAppsFromEnv() ([]GitHubApp, error) {
+	appID := os.Getenv("GITHUB_APP_ID")
+	if appID == "" {
+		return nil, nil
+	}
+
+	keyFile := os.Getenv("GITHUB_APP_PRIVATE_KEY_FILE")
+	if keyFile == "" {
+		return nil, errors.New("GITHUB_APP_PRIVATE_KEY_FILE is required with GITHUB_APP_ID")
+	}
+	key, err := ReadAppPrivateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := os.Getenv("GITHUB_APP_BASE_URL")
+	if _, err := repoid.BaseURLHost(baseURL); err != nil {
+		return nil, fmt.Errorf("GITHUB_APP_BASE_URL: %w", err)
+	}
+
+	var apps []GitHubApp
+	for _, raw := range strings.Split(os.Getenv("GITHUB_APP_INSTALLATION_IDS"), ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		installationID, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GitHub App installation ID %q", raw)
+		}
+		apps = append(apps, GitHubApp{
+			AppID:          appID,
+			InstallationID: installationID,
+			PrivateKey:     key,
+			BaseURL:        baseURL,
+		})
+	}
+
+	if len(apps) == 0 {
+		return nil, errors.New("GITHUB_APP_INSTALLATION_IDS is required with GITHUB_APP_ID")
+	}
+	return apps, nil
+}
+
+// ReadAppPrivateKey reads the PEM private key downloaded from the GitHub App settings
+func ReadAppPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAppPrivateKey(data)
+}
+
+// ParseAppPrivateKey parses a PKCS#1 or PKCS#8 PEM encoded RSA private key
+func ParseAppPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data in GitHub App private key")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing GitHub App private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("GitHub App private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// NewClientWithApp is the GitHub App sibling of NewClientWithPAT: the client authenticates with
+// installation tokens, exchanged for a JWT signed with the App key and refreshed before they expire.
+// It calls the API at app.BaseURL.
+func NewClientWithApp(app GitHubApp, onRateLimit func(RateLimitReading)) *repostats.ClientGQL {
+	oauthClient := oauth2.NewClient(oauthContext(app.BaseURL, onRateLimit), NewAppTokenSource(app))
+	return repostats.NewClientGQL(oauthClient)
+}
+
+// NewAppTokenSource returns a TokenSource handing out the installation token until shortly before it expires
+func NewAppTokenSource(app GitHubApp) oauth2.TokenSource {
+	if app.BaseURL == "" {
+		app.BaseURL = defaultGitHubAPIURL
+	}
+	if app.HTTPClient == nil {
+		app.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return oauth2.ReuseTokenSourceWithExpiry(nil, &appTokenSource{app: app}, appTokenEarlyExpiry)
+}
+
+type appTokenSource struct {
+	app GitHubApp
+}
+
+// Token exchanges a freshly signed JWT for an installation token
+func (s *appTokenSource) Token() (*oauth2.Token, error) {
+	jwt, err := s.app.signJWT(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", strings.TrimSuffix(s.app.BaseURL, "/"), s.app.InstallationID)
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	resp, err := s.app.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting installation token for %s: %w", s.app.Label(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("requesting installation token for %s: %s: %s", s.app.Label(), resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decoding installation token for %s: %w", s.app.Label(), err)
+	}
+
+	return &oauth2.Token{
+		AccessToken: result.Token,
+		TokenType:   "token",
+		Expiry:      result.ExpiresAt,
+	}, nil
+}
+
+// signJWT builds the RS256 JWT identifying the App. It is backdated a minute to allow for clock drift.
+func (app GitHubApp) signJWT(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(appJWTLifetime).Unix(),
+		"iss": app.AppID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, app.PrivateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTokenEndpoint stands in for the GitHub installation token endpoint, checking the App JWT
+// and handing out tokens valid for validity
+func newTokenEndpoint(t *testing.T, key *rsa.PrivateKey, validity time.Duration) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var issued atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/app/installations/42/access_tokens" {
+			http.NotFound(w, r)
+			return
+		}
+
+		parts := strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), ".")
+		if len(parts) != 3 {
+			http.Error(w, "malformed JWT", http.StatusUnauthorized)
+			return
+		}
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+
+		var claims struct {
+			Iss string `json:"iss"`
+			Exp int64  `json:"exp"`
+		}
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		if err := json.Unmarshal(payload, &claims); err != nil || claims.Iss != "1234" || claims.Exp < time.Now().Unix() {
+			http.Error(w, "bad claims", http.StatusUnauthorized)
+			return
+		}
+
+		n := issued.Add(1)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"token":      fmt.Sprintf("ghs_%d", n),
+			"expires_at": time.Now().Add(validity).UTC().Format(time.RFC3339),
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &issued
+}
+
+func TestAppTokenSourceReusesToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	server, issued := newTokenEndpoint(t, key, time.Hour)
+
+	ts := NewAppTokenSource(GitHubApp{AppID: "1234", InstallationID: 42, PrivateKey: key, BaseURL: server.URL})
+
+	first, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "ghs_1", first.AccessToken)
+
+	second, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "ghs_1", second.AccessToken)
+	assert.Equal(t, int32(1), issued.Load())
+}
+
+func TestAppTokenSourceRefreshesBeforeExpiry(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	// Tokens expiring within appTokenEarlyExpiry are replaced on every use
+	server, issued := newTokenEndpoint(t, key, time.Minute)
+
+	ts := NewAppTokenSource(GitHubApp{AppID: "1234", InstallationID: 42, PrivateKey: key, BaseURL: server.URL})
+
+	_, err = ts.Token()
+	require.NoError(t, err)
+	second, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "ghs_2", second.AccessToken)
+	assert.Equal(t, int32(2), issued.Load())
+}
+
+func TestAppTokenSourceRejected(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	server, _ := newTokenEndpoint(t, key, time.Hour)
+
+	ts := NewAppTokenSource(GitHubApp{AppID: "1234", InstallationID: 42, PrivateKey: other, BaseURL: server.URL})
+
+	_, err = ts.Token()
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestParseAppPrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	parsed, err := ParseAppPrivateKey(pkcs1)
+	require.NoError(t, err)
+	assert.True(t, key.Equal(parsed))
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	parsed, err = ParseAppPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	require.NoError(t, err)
+	assert.True(t, key.Equal(parsed))
+
+	_, err = ParseAppPrivateKey([]byte("not a key"))
+	assert.Error(t, err)
+}
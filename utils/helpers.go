@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -37,11 +36,14 @@ func GenerateCSVData(repo string, data []stats.StarsPerDay) (string, error) {
 	return strings.Join(csvData, "\n"), nil
 }
 
-func NewClientWithPAT(token string) *repostats.ClientGQL {
+// NewClientWithPAT returns a client authenticating with token against the GitHub API at baseURL, github.com
+// when empty (see NewEndpointTransport). onRateLimit, when not nil, gets the rate limit read from the headers
+// of every response.
+func NewClientWithPAT(baseURL, token string, onRateLimit func(RateLimitReading)) *repostats.ClientGQL {
 	tokenSource := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: token},
 	)
 
-	oauthClient := oauth2.NewClient(context.Background(), tokenSource)
+	oauthClient := oauth2.NewClient(oauthContext(baseURL, onRateLimit), tokenSource)
 	return repostats.NewClientGQL(oauthClient)
 }
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// RateLimitReading is the rate limit GitHub reports in the X-RateLimit-* headers of a response
+type RateLimitReading struct {
+	Limit     int
+	Remaining int
+	Used      int
+	ResetAt   time.Time
+	// Resource is the quota the request counted against, e.g. graphql or core
+	Resource string
+}
+
+// ParseRateLimitHeaders reads the X-RateLimit-* headers, reporting false when the response has none
+func ParseRateLimitHeaders(h http.Header) (RateLimitReading, bool) {
+	limit, err := strconv.Atoi(h.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return RateLimitReading{}, false
+	}
+	remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return RateLimitReading{}, false
+	}
+
+	reading := RateLimitReading{
+		Limit:     limit,
+		Remaining: remaining,
+		Resource:  h.Get("X-RateLimit-Resource"),
+	}
+	if used, err := strconv.Atoi(h.Get("X-RateLimit-Used")); err == nil {
+		reading.Used = used
+	} else {
+		reading.Used = limit - remaining
+	}
+	if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		reading.ResetAt = time.Unix(reset, 0)
+	}
+	return reading, true
+}
+
+// rateLimitTransport hands the rate limit of every GitHub response to observe
+type rateLimitTransport struct {
+	base    http.RoundTripper
+	observe func(RateLimitReading)
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if reading, ok := ParseRateLimitHeaders(resp.Header); ok {
+		t.observe(reading)
+	}
+	return resp, nil
+}
+
+// NewRateLimitTransport wraps base, http.DefaultTransport when nil, so that observe gets the rate limit
+// read from every response. A nil observe returns base unchanged.
+func NewRateLimitTransport(base http.RoundTripper, observe func(RateLimitReading)) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if observe == nil {
+		return base
+	}
+	return &rateLimitTransport{base: base, observe: observe}
+}
+
+// oauthContext is the context the oauth2 clients are built from, it makes their requests go to the
+// API at baseURL through a rate limit transport. With an invalid baseURL every request fails.
+func oauthContext(baseURL string, observe func(RateLimitReading)) context.Context {
+	transport, err := NewEndpointTransport(nil, baseURL)
+	if err != nil {
+		transport = failingTransport{err: err}
+	}
+	return context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{
+		Transport: NewRateLimitTransport(transport, observe),
+	})
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRateLimitHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("X-RateLimit-Limit", "5000")
+	h.Set("X-RateLimit-Remaining", "4990")
+	h.Set("X-RateLimit-Used", "10")
+	h.Set("X-RateLimit-Reset", "1767225600")
+	h.Set("X-RateLimit-Resource", "graphql")
+
+	reading, ok := ParseRateLimitHeaders(h)
+	require.True(t, ok)
+	assert.Equal(t, RateLimitReading{
+		Limit:     5000,
+		Remaining: 4990,
+		Used:      10,
+		ResetAt:   time.Unix(1767225600, 0),
+		Resource:  "graphql",
+	}, reading)
+
+	_, ok = ParseRateLimitHeaders(http.Header{})
+	assert.False(t, ok)
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/graphql" {
+			w.Header().Set("X-RateLimit-Limit", "5000")
+			w.Header().Set("X-RateLimit-Remaining", "4321")
+		}
+	}))
+	defer server.Close()
+
+	var readings []RateLimitReading
+	client := &http.Client{Transport: NewRateLimitTransport(nil, func(r RateLimitReading) {
+		readings = append(readings, r)
+	})}
+
+	for _, path := range []string{"/graphql", "/no-headers"} {
+		resp, err := client.Get(server.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	require.Len(t, readings, 1)
+	assert.Equal(t, 4321, readings[0].Remaining)
+	assert.Equal(t, 679, readings[0].Used)
+}
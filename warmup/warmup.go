@@ -0,0 +1,247 @@
+package warmup
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emanuelef/gh-repo-stats-server/repoid"
+)
+
+// Fetcher fetches one metric for a repository into the server caches
+type Fetcher interface {
+	// Fresh reports whether the metric for repo is cached and not expired. An expired stars history still kept
+	// for stale serving isn't fresh, but Fetch only refreshes its missing days.
+	Fresh(repo, metric string) bool
+	// Fetch downloads the metric for repo and stores it in its cache
+	Fetch(ctx context.Context, repo, metric string) error
+}
+
+// QuotaFunc returns the remaining GitHub API quota of the best available token for repo and when it resets
+type QuotaFunc func(ctx context.Context, repo string) (remaining int, resetAt time.Time)
+
+// Config controls what the warmer fetches and how fast
+type Config struct {
+	Repos   []string
+	Metrics []string
+	// Pause is the delay between two fetches, so the warmer never hogs the tokens
+	Pause time.Duration
+	// CycleInterval is the delay between two passes over the repo list
+	CycleInterval time.Duration
+	// MinRemaining is the quota to leave for visitors, the warmer waits for the reset below it
+	MinRemaining int
+}
+
+// Status is the JSON representation of the warmer progress
+type Status struct {
+	Running         bool       `json:"running"`
+	Cycle           int        `json:"cycle"`
+	Repos           int        `json:"repos"`
+	Metrics         []string   `json:"metrics"`
+	Total           int        `json:"total"`
+	Fetched         int        `json:"fetched"`
+	Skipped         int        `json:"skipped"`
+	Failed          int        `json:"failed"`
+	Current         string     `json:"current,omitempty"`
+	LastError       string     `json:"lastError,omitempty"`
+	WaitingForQuota *time.Time `json:"waitingForQuotaUntil,omitempty"`
+	CycleStartedAt  *time.Time `json:"cycleStartedAt,omitempty"`
+	CycleFinishedAt *time.Time `json:"cycleFinishedAt,omitempty"`
+	NextCycleAt     *time.Time `json:"nextCycleAt,omitempty"`
+}
+
+// Warmer periodically fetches the configured repositories so visitors hit a warm cache
+type Warmer struct {
+	cfg     Config
+	fetcher Fetcher
+	quota   QuotaFunc
+
+	mu     sync.Mutex
+	status Status
+}
+
+// New creates a Warmer, call Run to start it
+func New(cfg Config, fetcher Fetcher, quota QuotaFunc) *Warmer {
+	return &Warmer{
+		cfg:     cfg,
+		fetcher: fetcher,
+		quota:   quota,
+		status: Status{
+			Repos:   len(cfg.Repos),
+			Metrics: cfg.Metrics,
+			Total:   len(cfg.Repos) * len(cfg.Metrics),
+		},
+	}
+}
+
+// Status returns a snapshot of the warmer progress
+func (w *Warmer) Status() Status {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+// Run warms the caches every CycleInterval until ctx is done
+func (w *Warmer) Run(ctx context.Context) {
+	for {
+		w.runCycle(ctx)
+
+		next := time.Now().Add(w.cfg.CycleInterval)
+		w.update(func(s *Status) {
+			s.NextCycleAt = &next
+		})
+
+		if !sleep(ctx, w.cfg.CycleInterval) {
+			return
+		}
+	}
+}
+
+func (w *Warmer) runCycle(ctx context.Context) {
+	started := time.Now()
+	w.update(func(s *Status) {
+		s.Running = true
+		s.Cycle++
+		s.Fetched, s.Skipped, s.Failed = 0, 0, 0
+		s.LastError = ""
+		s.CycleStartedAt = &started
+		s.CycleFinishedAt = nil
+		s.NextCycleAt = nil
+	})
+	defer w.update(func(s *Status) {
+		finished := time.Now()
+		s.Running = false
+		s.Current = ""
+		s.CycleFinishedAt = &finished
+	})
+
+	fetched := false
+	for _, repo := range w.cfg.Repos {
+		for _, metric := range w.cfg.Metrics {
+			if ctx.Err() != nil {
+				return
+			}
+
+			if w.fetcher.Fresh(repo, metric) {
+				w.update(func(s *Status) { s.Skipped++ })
+				continue
+			}
+
+			// Leave some room between fetches for visitors
+			if fetched && !sleep(ctx, w.cfg.Pause) {
+				return
+			}
+
+			if !w.waitForQuota(ctx, repo) {
+				return
+			}
+
+			w.update(func(s *Status) { s.Current = metric + " " + repo })
+			log.Printf("Warmup fetching %s for %s", metric, repo)
+
+			err := w.fetcher.Fetch(ctx, repo, metric)
+			fetched = true
+
+			w.update(func(s *Status) {
+				s.Current = ""
+				if err != nil {
+					s.Failed++
+					s.LastError = metric + " " + repo + ": " + err.Error()
+				} else {
+					s.Fetched++
+				}
+			})
+			if err != nil {
+				log.Printf("Warmup error fetching %s for %s: %v", metric, repo, err)
+			}
+		}
+	}
+}
+
+// waitForQuota blocks until the best token for repo has more than MinRemaining requests left.
+// It returns false if ctx is done first.
+func (w *Warmer) waitForQuota(ctx context.Context, repo string) bool {
+	defer w.update(func(s *Status) { s.WaitingForQuota = nil })
+
+	for {
+		remaining, resetAt := w.quota(ctx, repo)
+		if remaining > w.cfg.MinRemaining {
+			return true
+		}
+
+		wait := time.Until(resetAt)
+		if wait < time.Minute {
+			wait = time.Minute
+		}
+		until := time.Now().Add(wait)
+		w.update(func(s *Status) { s.WaitingForQuota = &until })
+		log.Printf("Warmup waiting %v for GitHub quota (%d remaining)", wait.Round(time.Second), remaining)
+
+		if !sleep(ctx, wait) {
+			return false
+		}
+	}
+}
+
+func (w *Warmer) update(fn func(s *Status)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fn(&w.status)
+}
+
+// sleep waits for d and returns false if ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// ReadRepoList reads a repo list file with one owner/name (or host/owner/name) per line, as in
+// scripts/preloaded-repositories.txt.
+// Blank lines and lines starting with # are ignored.
+func ReadRepoList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseRepoList(f)
+}
+
+// ParseRepoList parses the repo list format read by ReadRepoList
+func ParseRepoList(r io.Reader) ([]string, error) {
+	var repos []string
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		repo := repoid.Normalize(line)
+		if seen[repo] {
+			continue
+		}
+		seen[repo] = true
+		repos = append(repos, repo)
+	}
+
+	return repos, scanner.Err()
+}
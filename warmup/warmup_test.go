@@ -0,0 +1,93 @@
+package warmup
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeFetcher struct {
+	mu      sync.Mutex
+	fresh   map[string]bool
+	fetched []string
+	fail    map[string]bool
+}
+
+func (f *fakeFetcher) Fresh(repo, metric string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fresh[metric+" "+repo]
+}
+
+func (f *fakeFetcher) Fetch(ctx context.Context, repo, metric string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := metric + " " + repo
+	f.fetched = append(f.fetched, key)
+	if f.fail[key] {
+		return errors.New("not found")
+	}
+	return nil
+}
+
+func plentyOfQuota(ctx context.Context, repo string) (int, time.Time) {
+	return 5000, time.Now().Add(time.Hour)
+}
+
+func TestParseRepoList(t *testing.T) {
+	repos, err := ParseRepoList(strings.NewReader("helm/helm\n\n# comment\nFacebook/React\nhelm/helm\n  ollama/ollama  \n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"helm/helm", "facebook/react", "ollama/ollama"}, repos)
+}
+
+func TestRunCycleSkipsFreshRepos(t *testing.T) {
+	fetcher := &fakeFetcher{
+		fresh: map[string]bool{"stars helm/helm": true},
+		fail:  map[string]bool{"issues facebook/react": true},
+	}
+
+	w := New(Config{
+		Repos:   []string{"helm/helm", "facebook/react"},
+		Metrics: []string{"stars", "issues"},
+	}, fetcher, plentyOfQuota)
+
+	w.runCycle(context.Background())
+
+	assert.Equal(t, []string{"issues helm/helm", "stars facebook/react", "issues facebook/react"}, fetcher.fetched)
+
+	status := w.Status()
+	assert.False(t, status.Running)
+	assert.Equal(t, 1, status.Cycle)
+	assert.Equal(t, 4, status.Total)
+	assert.Equal(t, 1, status.Skipped)
+	assert.Equal(t, 2, status.Fetched)
+	assert.Equal(t, 1, status.Failed)
+	assert.Contains(t, status.LastError, "issues facebook/react")
+	assert.NotNil(t, status.CycleFinishedAt)
+}
+
+func TestWaitForQuotaStopsOnCancel(t *testing.T) {
+	calls := 0
+	w := New(Config{MinRemaining: 100}, &fakeFetcher{}, func(ctx context.Context, repo string) (int, time.Time) {
+		calls++
+		assert.Equal(t, "helm/helm", repo)
+		return 10, time.Now().Add(time.Hour)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() { done <- w.waitForQuota(ctx, "helm/helm") }()
+
+	require.Eventually(t, func() bool { return w.Status().WaitingForQuota != nil }, time.Second, time.Millisecond)
+	cancel()
+
+	assert.False(t, <-done)
+	assert.Equal(t, 1, calls)
+	assert.Nil(t, w.Status().WaitingForQuota)
+}